@@ -4,11 +4,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,12 +15,28 @@ import (
 
 	"github.com/andreacioni/motionctrl/backup"
 	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/metrics"
 	"github.com/andreacioni/motionctrl/motion"
 	"github.com/andreacioni/motionctrl/notify"
+	"github.com/andreacioni/motionctrl/privacy"
+	"github.com/andreacioni/motionctrl/share"
+	"github.com/andreacioni/motionctrl/stream"
+	"github.com/andreacioni/motionctrl/thumbnail"
 	"github.com/andreacioni/motionctrl/utils"
 	"github.com/andreacioni/motionctrl/version"
 )
 
+const (
+	frameTimeout = 5 * time.Second
+
+	//placeholder frames are sent once per second to stream viewers in privacy mode
+	privacyFrameInterval = time.Second
+
+	//seconds
+	snapshotDefaultTimeout = 10
+	snapshotMaxTimeout     = 60
+)
+
 // MethodHandler utility struct that contains method and associated handler
 type MethodHandler struct {
 	method string
@@ -34,6 +49,7 @@ var internalHandlersMap = map[string]MethodHandler{
 	"/event/end":             {method: http.MethodGet, f: eventEnd},
 	"/event/motion/detected": {method: http.MethodGet, f: motionDetected},
 	"/event/picture/saved":   {method: http.MethodGet, f: pictureSaved},
+	"/event/movie/saved":     {method: http.MethodGet, f: movieSaved},
 }
 
 var handlersMap = map[string]MethodHandler{
@@ -46,95 +62,114 @@ var handlersMap = map[string]MethodHandler{
 	"/detection/start":  {method: http.MethodGet, f: startDetectionHandler, m: []gin.HandlerFunc{needMotionUp}},
 	"/detection/stop":   {method: http.MethodGet, f: stopDetectionHandler, m: []gin.HandlerFunc{needMotionUp}},
 
-	"/camera/stream":    {method: http.MethodGet, f: proxyStream, m: []gin.HandlerFunc{needMotionUp}},
-	"/camera/snapshot":  {method: http.MethodGet, f: takeSnapshot, m: []gin.HandlerFunc{needMotionUp}},
-	"/camera/makemovie": {method: http.MethodGet, f: makeMovie, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/stream":       {method: http.MethodGet, f: proxyStream, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/stream/stats": {method: http.MethodGet, f: streamStats},
+	"/camera/frame":        {method: http.MethodGet, f: latestFrame, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/snapshot":     {method: http.MethodGet, f: takeSnapshot, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/makemovie":    {method: http.MethodGet, f: makeMovie, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/track/status": {method: http.MethodGet, f: trackStatusHandler, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/track/center": {method: http.MethodGet, f: trackCenterHandler, m: []gin.HandlerFunc{needMotionUp}},
+	"/camera/track/set":    {method: http.MethodGet, f: trackSetHandler, m: []gin.HandlerFunc{needMotionUp}},
 
 	"/config/list":       {method: http.MethodGet, f: listConfigHandler, m: []gin.HandlerFunc{needMotionUp}},
 	"/config/set":        {method: http.MethodGet, f: setConfigHandler, m: []gin.HandlerFunc{needMotionUp}},
 	"/config/get/:param": {method: http.MethodGet, f: getConfigHandler, m: []gin.HandlerFunc{needMotionUp}},
 	"/config/write":      {method: http.MethodGet, f: writeConfigHandler, m: []gin.HandlerFunc{needMotionUp}},
 
+	"/mask/:type":         {method: http.MethodPost, f: setMask, m: []gin.HandlerFunc{needMotionUp}},
+	"/mask/:type/preview": {method: http.MethodGet, f: getMaskPreview},
+
 	"/targetdir/list":             {method: http.MethodGet, f: listTargetDir},
 	"/targetdir/size":             {method: http.MethodGet, f: sizeTargetDir},
-	"/targetdir/get/:filename":    {method: http.MethodGet, f: retrieveFromTargetDir},
-	"/targetdir/remove/:filename": {method: http.MethodGet, f: removeFromTargetDir},
+	"/targetdir/get/*filename":    {method: http.MethodGet, f: retrieveFromTargetDir},
+	"/targetdir/remove/*filename": {method: http.MethodGet, f: removeFromTargetDir},
+	"/targetdir/thumb/*filename":  {method: http.MethodGet, f: thumbTargetDir},
+	"/targetdir/download":         {method: http.MethodGet, f: downloadTargetDir},
+
+	"/timelapse/create":     {method: http.MethodGet, f: createTimelapse},
+	"/timelapse/list":       {method: http.MethodGet, f: listTimelapse},
+	"/timelapse/status/:id": {method: http.MethodGet, f: timelapseStatus},
+	"/timelapse/get/:id":    {method: http.MethodGet, f: retrieveTimelapse},
+	"/timelapse/remove/:id": {method: http.MethodGet, f: removeTimelapse},
 
 	"/backup/status": {method: http.MethodGet, f: backupStatus},
 	"/backup/launch": {method: http.MethodGet, f: backupLaunch},
 
+	"/retention/status":  {method: http.MethodGet, f: retentionStatus},
+	"/retention/preview": {method: http.MethodGet, f: retentionPreview},
+	"/retention/run":     {method: http.MethodGet, f: retentionRun},
+
+	"/storage": {method: http.MethodGet, f: storageStatus},
+
+	"/ssl/fingerprint": {method: http.MethodGet, f: sslFingerprint},
+
+	"/auth/logout":              {method: http.MethodGet, f: logout},
+	"/auth/sessions":            {method: http.MethodGet, f: listSessions},
+	"/auth/sessions/revoke/:id": {method: http.MethodGet, f: revokeSession},
+
+	"/totp/status":  {method: http.MethodGet, f: totpStatus},
+	"/totp/enroll":  {method: http.MethodGet, f: totpEnroll},
+	"/totp/confirm": {method: http.MethodGet, f: totpConfirm},
+	"/totp/verify":  {method: http.MethodGet, f: totpVerify},
+	"/totp/disable": {method: http.MethodGet, f: totpDisable},
+
+	"/share/create":     {method: http.MethodGet, f: createShare},
+	"/share/list":       {method: http.MethodGet, f: listShares},
+	"/share/revoke/:id": {method: http.MethodGet, f: revokeShare},
+
+	"/privacy/status":  {method: http.MethodGet, f: privacyStatus},
+	"/privacy/enable":  {method: http.MethodGet, f: privacyEnable},
+	"/privacy/disable": {method: http.MethodGet, f: privacyDisable},
+
 	"/notify/status":     {method: http.MethodGet, f: notifyStatus},
 	"/notify/activate":   {method: http.MethodGet, f: notifyActivate},
 	"/notify/deactivate": {method: http.MethodGet, f: notifyDeactivate},
+
+	"/events":             {method: http.MethodGet, f: eventsHandler},
+	"/events/history":     {method: http.MethodGet, f: eventsHistory},
+	"/events/history/:id": {method: http.MethodGet, f: eventFromHistory},
 }
 
 func Init(conf config.Configuration, shutdownHook func()) error {
 	glg.Info("Initializing REST API ...")
 
-	if conf.Address == "" || conf.Port <= 0 {
-		return fmt.Errorf("Address and/or port not defined in configuration")
+	list, err := listeners(conf)
+	if err != nil {
+		return err
 	}
 
-	var group *gin.RouterGroup
-	router := gin.Default()
-
-	// /app
-	if conf.AppPath != "" {
-		glg.Infof("Serving static files from %s to /app", conf.AppPath)
-		router.Static("/app", conf.AppPath)
+	if basePath, err = normalizeBasePath(conf.Proxy.BasePath); err != nil {
+		return err
 	}
 
-	// /internal
-	internal := router.Group("/internal", isLocalhost)
-
-	for path, handler := range internalHandlersMap {
-		internal.Handle(handler.method, path, handler.f)
-	}
+	var servers []*server
 
-	// /api
-	if conf.Username != "" && conf.Password != "" {
-		glg.Info("Username and password defined, authentication enabled")
-		group = router.Group("/api", gin.BasicAuth(gin.Accounts{conf.Username: conf.Password}))
-	} else {
-		glg.Warn("Username and password not defined, authentication disabled")
-		group = router.Group("/api")
-	}
+	for _, l := range list {
+		s, err := newServer(conf, l)
+		if err != nil {
+			for _, s := range servers {
+				s.listener.Close()
+			}
+			return fmt.Errorf("unable to listen on %s: %v", l.Address, err)
+		}
 
-	for path, handler := range handlersMap {
-		group.Handle(handler.method, path, append(handler.m, handler.f)...)
+		servers = append(servers, s)
 	}
 
-	if err := listenAndServe(router, shutdownHook, fmt.Sprintf("%s:%d", conf.Address, conf.Port), conf.Ssl); err != nil {
-		return fmt.Errorf("unable to listen & serve: %v", err)
-	}
+	listenAndServe(servers, shutdownHook)
 
 	return nil
 }
 
 //From: https://github.com/gin-gonic/gin#graceful-restart-or-stop
-func listenAndServe(router *gin.Engine, shutdownHook func(), addressPort string, sslConf config.SSL) error {
-	server := &http.Server{
-		Addr:    addressPort,
-		Handler: router,
+func listenAndServe(servers []*server, shutdownHook func()) {
+	for _, s := range servers {
+		go s.serve()
 	}
 
-	go func() {
-		if !sslConf.IsEmpty() {
-			glg.Infof("SSL/TLS enabled for API using certificate: %s, key: %s", sslConf.CertFile, sslConf.KeyFile)
-			if err := server.ListenAndServeTLS(sslConf.CertFile, sslConf.KeyFile); err != nil {
-				glg.Error(err)
-			}
-		} else {
-			glg.Warn("SSL/TLS NOT enabled for API")
-			if err := server.ListenAndServe(); err != nil {
-				glg.Error(err)
-			}
-		}
-	}()
-
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt)
 	<-quit
 	glg.Debug("Shutdown Server ...")
@@ -143,12 +178,13 @@ func listenAndServe(router *gin.Engine, shutdownHook func(), addressPort string,
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		glg.Fatal("Server Shutdown:", err)
+
+	for _, s := range servers {
+		if err := s.http.Shutdown(ctx); err != nil {
+			glg.Fatal("Server Shutdown:", err)
+		}
 	}
 	glg.Info("Server exiting")
-
-	return nil
 }
 
 func startHandler(c *gin.Context) {
@@ -190,7 +226,7 @@ func stopHandler(c *gin.Context) {
 
 func statusHandler(c *gin.Context) {
 	if started, err := motion.IsStarted(); err == nil {
-		c.JSON(http.StatusOK, gin.H{"motionStarted": started})
+		c.JSON(http.StatusOK, gin.H{"motionStarted": started, "privacyMode": privacy.Enabled()})
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Unable to check if motion is up: %v", err)})
 	}
@@ -226,21 +262,238 @@ func stopDetectionHandler(c *gin.Context) {
 	}
 }
 
-//proxyStream is a courtesy of: https://github.com/gin-gonic/gin/issues/686
+func trackStatusHandler(c *gin.Context) {
+	status, err := motion.GetTrackStatus()
+
+	if err != nil {
+		c.JSON(trackErrorStatus(err), gin.H{"message": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, status)
+	}
+}
+
+func trackCenterHandler(c *gin.Context) {
+	err := motion.TrackCenter()
+
+	if err != nil {
+		c.JSON(trackErrorStatus(err), gin.H{"message": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "camera centered"})
+	}
+}
+
+// trackSetHandler moves the camera to an absolute position (x and y) or by relative steps (pan and/or tilt)
+func trackSetHandler(c *gin.Context) {
+	var err error
+	absolute := c.Query("x") != "" || c.Query("y") != ""
+	relative := c.Query("pan") != "" || c.Query("tilt") != ""
+
+	if absolute == relative {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "either 'x' and 'y' or 'pan' and 'tilt' parameters are required"})
+		return
+	}
+
+	if absolute {
+		x, errX := strconv.Atoi(c.Query("x"))
+		y, errY := strconv.Atoi(c.Query("y"))
+		if errX != nil || errY != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "'x' and 'y' parameters must be integers"})
+			return
+		}
+		err = motion.TrackSet(x, y)
+	} else {
+		pan, errPan := strconv.Atoi(c.DefaultQuery("pan", "0"))
+		tilt, errTilt := strconv.Atoi(c.DefaultQuery("tilt", "0"))
+		if errPan != nil || errTilt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "'pan' and 'tilt' parameters must be integers"})
+			return
+		}
+		err = motion.TrackMove(pan, tilt)
+	}
+
+	if err != nil {
+		c.JSON(trackErrorStatus(err), gin.H{"message": err.Error()})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "camera moved"})
+	}
+}
+
+func trackErrorStatus(err error) int {
+	if _, ok := err.(*motion.TrackRangeError); ok {
+		return http.StatusBadRequest
+	}
+
+	switch err {
+	case motion.ErrTrackingDisabled:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// proxyStream sends to the client frames received by the stream hub, every viewer shares the same connection to motion
 func proxyStream(c *gin.Context) {
-	url, _ := url.Parse(motion.GetStreamBaseURL())
-	proxy := httputil.NewSingleHostReverseProxy(url)
-	proxy.ServeHTTP(c.Writer, c.Request)
+	profile, err := streamProfile(c)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	client := stream.SubscribeProfile(profile)
+	defer stream.Unsubscribe(client)
+
+	//Viewers using a share link are disconnected when it expires or is revoked
+	shareID := c.GetString(shareKey)
+
+	c.Header("Content-Type", stream.ContentType())
+	c.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	c.Status(http.StatusOK)
+
+	//In privacy mode live frames are dropped and the placeholder is sent instead
+	placeholderTicker := time.NewTicker(privacyFrameInterval)
+	defer placeholderTicker.Stop()
+
+	for {
+		var data []byte
+
+		select {
+		case frame, ok := <-client.C:
+			if !ok {
+				return
+			}
+
+			if privacy.Enabled() {
+				continue
+			}
+			data = frame.Data
+		case <-placeholderTicker.C:
+			if !privacy.Enabled() {
+				continue
+			}
+
+			if data, err = profile.Transform(privacy.Placeholder()); err != nil {
+				glg.Errorf("Unable to send privacy placeholder: %v", err)
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+
+		if shareID != "" && !share.Active(shareID) {
+			glg.Infof("Share link %s is no longer valid, stream viewer %s disconnected", shareID, c.ClientIP())
+			return
+		}
+
+		n, err := stream.WriteFrame(c.Writer, data)
+		metrics.StreamSent(n)
+
+		if err != nil {
+			glg.Debugf("Stream viewer %s gone: %v", c.Request.RemoteAddr, err)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+func streamStats(c *gin.Context) {
+	c.JSON(http.StatusOK, stream.GetStats())
+}
+
+// latestFrame returns the most recent frame of the stream, no file is written by motion
+func latestFrame(c *gin.Context) {
+	profile, err := streamProfile(c)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var frame []byte
+
+	if privacy.Enabled() {
+		frame = privacy.Placeholder()
+	} else if latest, err := stream.WaitFrame(frameTimeout); err == nil {
+		frame = latest.Data
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	data, err := profile.Transform(frame)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	} else {
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/jpeg", data)
+	}
+}
+
+// streamProfile reads 'fps', 'width' and 'quality' query parameters
+func streamProfile(c *gin.Context) (stream.Profile, error) {
+	var profile stream.Profile
+	var err error
+
+	if fps := c.Query("fps"); fps != "" {
+		if profile.FPS, err = strconv.ParseFloat(fps, 64); err != nil {
+			return profile, fmt.Errorf("'fps' parameter must be a number")
+		}
+	}
+
+	if width := c.Query("width"); width != "" {
+		if profile.Width, err = strconv.Atoi(width); err != nil {
+			return profile, fmt.Errorf("'width' parameter must be an integer")
+		}
+	}
+
+	if quality := c.Query("quality"); quality != "" {
+		if profile.Quality, err = strconv.Atoi(quality); err != nil {
+			return profile, fmt.Errorf("'quality' parameter must be an integer")
+		}
+	}
+
+	return profile, profile.Validate()
 }
 
 func takeSnapshot(c *gin.Context) {
-	snapFile, err := motion.Snapshot()
+	save, err := strconv.ParseBool(c.DefaultQuery("save", "true"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "'save' parameter must be 'true' or 'false'"})
+		return
+	}
+
+	timeout, err := strconv.Atoi(c.DefaultQuery("timeout", strconv.Itoa(snapshotDefaultTimeout)))
+
+	if err != nil || timeout <= 0 || timeout > snapshotMaxTimeout {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'timeout' parameter must be between 1 and %d seconds", snapshotMaxTimeout)})
+		return
+	}
+
+	//Nothing is taken or saved by motion in privacy mode
+	if privacy.Enabled() {
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/jpeg", privacy.Placeholder())
+		return
+	}
+
+	snapFile, err := motion.Snapshot(time.Duration(timeout) * time.Second)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 	} else {
 		glg.Debugf("Snapshot file: %s", snapFile)
+
+		c.Header("Content-Type", utils.MimeType(snapFile))
+		c.Header("Cache-Control", "no-store")
 		c.File(snapFile)
+
+		if !save {
+			if err := motion.RemoveSnapshot(snapFile); err != nil {
+				glg.Errorf("Unable to remove snapshot: %v", err)
+			}
+		}
 	}
 }
 
@@ -334,13 +587,71 @@ func backupLaunch(c *gin.Context) {
 }
 
 func listTargetDir(c *gin.Context) {
-	if fileList, err := motion.TargetDirListFiles(); err == nil {
-		c.JSON(http.StatusOK, fileList)
+	query, err := targetDirQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	for param, value := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		if s := c.Query(param); s != "" {
+			if *value, err = strconv.Atoi(s); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'%s' parameter must be an integer", param)})
+				return
+			}
+		}
+	}
+
+	query.Cursor = c.Query("cursor")
+
+	if err := query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if page, err := motion.TargetDirList(query); err == nil {
+		c.JSON(http.StatusOK, page)
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Unable to list files in target dir: %v", err)})
 	}
 }
 
+// targetDirQuery reads filter and sort parameters shared by target_dir endpoints
+func targetDirQuery(c *gin.Context) (motion.TargetDirQuery, error) {
+	var query motion.TargetDirQuery
+	var err error
+
+	if from := c.Query("from"); from != "" {
+		if query.From, err = utils.ParseTime(from); err != nil {
+			return query, fmt.Errorf("invalid 'from' parameter: %v", err)
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		if query.To, err = utils.ParseTime(to); err != nil {
+			return query, fmt.Errorf("invalid 'to' parameter: %v", err)
+		}
+	}
+
+	if ext := c.Query("ext"); ext != "" {
+		query.Extensions = strings.Split(ext, ",")
+	}
+
+	switch order := c.DefaultQuery("order", "asc"); order {
+	case "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return query, fmt.Errorf("'order' parameter must be 'asc' or 'desc'")
+	}
+
+	query.Type = c.Query("type")
+	query.Glob = c.Query("glob")
+	query.Sort = c.Query("sort")
+
+	return query, nil
+}
+
 func sizeTargetDir(c *gin.Context) {
 	if size, err := motion.TargetDirSize(); err == nil {
 		c.JSON(http.StatusOK, gin.H{"size": size})
@@ -350,13 +661,13 @@ func sizeTargetDir(c *gin.Context) {
 }
 
 func retrieveFromTargetDir(c *gin.Context) {
-	fileName := c.Param("filename")
+	fileName := strings.TrimPrefix(c.Param("filename"), "/")
 
 	if fileName != "" {
 		if filePath, err := motion.TargetDirGetFile(fileName); err == nil {
 			c.File(filePath)
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Unable to get: %s in target dir: %v", fileName, err)})
+			c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to get: %s in target dir: %v", fileName, err)})
 		}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "missing 'filename' parameter"})
@@ -365,13 +676,18 @@ func retrieveFromTargetDir(c *gin.Context) {
 }
 
 func removeFromTargetDir(c *gin.Context) {
-	fileName := c.Param("filename")
+	fileName := strings.TrimPrefix(c.Param("filename"), "/")
 
 	if fileName != "" {
+		filePath, _ := motion.TargetDirGetFile(fileName)
+
 		if err := motion.TargetDirRemoveFile(fileName); err == nil {
+			if filePath != "" {
+				thumbnail.Invalidate(filePath)
+			}
 			c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("%s successfully removed", fileName)})
 		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Unable to remove: %s in target dir: %v", fileName, err)})
+			c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to remove: %s in target dir: %v", fileName, err)})
 		}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "missing 'filename' parameter"})
@@ -379,6 +695,18 @@ func removeFromTargetDir(c *gin.Context) {
 
 }
 
+// targetDirErrorStatus maps errors returned by target_dir functions to HTTP status codes
+func targetDirErrorStatus(err error) int {
+	switch {
+	case err == motion.ErrInvalidPath:
+		return http.StatusBadRequest
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func notifyStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"ready":  notify.IsReady(),
@@ -1,9 +1,33 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"image/jpeg"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/certificate"
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/dashboard"
+	"github.com/andreacioni/motionctrl/privacy"
+	"github.com/andreacioni/motionctrl/session"
+	"github.com/andreacioni/motionctrl/share"
+	"github.com/andreacioni/motionctrl/twofactor"
 )
 
 func TestEmptyAppend(t *testing.T) {
@@ -15,3 +39,774 @@ func TestEmptyAppend(t *testing.T) {
 
 	require.ElementsMatch(t, []int{1}, append(a, 1))
 }
+
+func TestMetricsAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Configuration{Username: "user", Password: "pass"}
+
+	request := func(conf config.Configuration, username, password string) int {
+		handlers, err := metricsHandlers(conf)
+		require.NoError(t, err)
+
+		router := gin.New()
+		router.GET("/metrics", handlers...)
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	//Same credentials of /api by default
+	require.Equal(t, http.StatusUnauthorized, request(conf, "", ""))
+	require.Equal(t, http.StatusOK, request(conf, "user", "pass"))
+
+	conf.Metrics = config.Metrics{Auth: MetricsAuthBasic, Username: "prometheus", Password: "secret"}
+	require.Equal(t, http.StatusUnauthorized, request(conf, "user", "pass"))
+	require.Equal(t, http.StatusOK, request(conf, "prometheus", "secret"))
+
+	conf.Metrics = config.Metrics{Auth: MetricsAuthNone}
+	require.Equal(t, http.StatusOK, request(conf, "", ""))
+
+	conf.Metrics = config.Metrics{Auth: MetricsAuthBasic}
+	_, err := metricsHandlers(conf)
+	require.Error(t, err)
+
+	conf.Metrics = config.Metrics{Auth: "token"}
+	_, err = metricsHandlers(conf)
+	require.Error(t, err)
+}
+
+func TestClientCertAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	request := func(conf config.Configuration, subject *pkix.Name, username, password string) (int, string) {
+		router := gin.New()
+		router.GET("/api/test", authentication(conf), func(c *gin.Context) {
+			c.String(http.StatusOK, c.GetString(gin.AuthUserKey))
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/test", nil)
+		if subject != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: *subject}}}}
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code, w.Body.String()
+	}
+
+	phone := &pkix.Name{CommonName: "phone", Organization: []string{"Home"}}
+	nvr := &pkix.Name{CommonName: "nvr"}
+
+	require.Nil(t, authentication(config.Configuration{}))
+
+	//Common name is the user when no mapping is defined
+	conf := config.Configuration{Ssl: config.SSL{ClientCA: "ca.pem"}}
+	code, user := request(conf, phone, "", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "phone", user)
+
+	code, _ = request(conf, nil, "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	//Subjects are mapped by distinguished name or common name, others fall back to basic auth
+	conf.Username, conf.Password = "user", "pass"
+	conf.Ssl.ClientUsers = map[string]string{"CN=phone,O=Home": "admin", "nvr": "recorder"}
+
+	code, user = request(conf, phone, "", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "admin", user)
+
+	code, user = request(conf, nvr, "", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "recorder", user)
+
+	code, _ = request(conf, &pkix.Name{CommonName: "unknown"}, "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, user = request(conf, &pkix.Name{CommonName: "unknown"}, "user", "pass")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "user", user)
+
+	code, _ = request(conf, nil, "user", "wrong")
+	require.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestInternalAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	request := func(conf config.Internal, remoteAddr, token string) int {
+		router := gin.New()
+		router.GET("/internal/event/end", append(internalMiddlewares(conf, false), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})...)
+
+		req := httptest.NewRequest(http.MethodGet, "/internal/event/end", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set(InternalTokenHeader, token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	//No token, local IPs only
+	require.Equal(t, http.StatusOK, request(config.Internal{}, "127.0.0.1:4000", ""))
+	require.Equal(t, http.StatusUnauthorized, request(config.Internal{}, "203.0.113.1:4000", ""))
+
+	//Token required, IP checked only with localOnly
+	conf := config.Internal{Token: "s3cret"}
+	require.Equal(t, http.StatusUnauthorized, request(conf, "127.0.0.1:4000", ""))
+	require.Equal(t, http.StatusUnauthorized, request(conf, "127.0.0.1:4000", "wrong"))
+	require.Equal(t, http.StatusOK, request(conf, "127.0.0.1:4000", "s3cret"))
+	require.Equal(t, http.StatusOK, request(conf, "172.17.0.2:4000", "s3cret"))
+
+	conf.LocalOnly = true
+	require.Equal(t, http.StatusUnauthorized, request(conf, "203.0.113.1:4000", "s3cret"))
+	require.Equal(t, http.StatusOK, request(conf, "127.0.0.1:4000", "s3cret"))
+}
+
+func TestHookCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	conf := config.Configuration{Address: "0.0.0.0", Port: 8888}
+
+	commands, err := HookCommands(conf)
+	require.NoError(t, err)
+	require.Len(t, commands, 4)
+	require.Equal(t, `on_event_start curl -s "http://localhost:8888/internal/event/start?camera=%t&event=%v"`, commands[0])
+
+	//Certificate generated at startup for localhost
+	conf.Internal.Token = "s3cret"
+	conf.Ssl = config.SSL{CertFile: cert, KeyFile: key, Generate: true}
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_event_end curl -s --cacert `+cert+` -H "X-Motionctrl-Token: s3cret" "https://localhost:8888/internal/event/end?camera=%t&event=%v"`, commands[1])
+
+	//Names of the certificate are resolved to the listener
+	conf.Ssl.Hosts = []string{"*.lan", "camera.lan"}
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_event_end curl -s --cacert `+cert+` --resolve camera.lan:8888:127.0.0.1 -H "X-Motionctrl-Token: s3cret" "https://camera.lan:8888/internal/event/end?camera=%t&event=%v"`, commands[1])
+
+	conf.Internal.Socket = "/run/motionctrl.sock"
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_movie_end curl -s --unix-socket /run/motionctrl.sock -H "X-Motionctrl-Token: s3cret" "http://localhost/internal/event/movie/saved?camera=%t&event=%v" -G --data-urlencode "moviepath=%f"`, commands[3])
+
+	conf.Internal.Token = `s3cret" $(reboot) "`
+	_, err = HookCommands(conf)
+	require.Error(t, err)
+
+	//Bound address in the certificate
+	require.NoError(t, certificate.Generate(cert, key, []string{"192.168.1.5"}))
+	tlsListener := config.Listener{Address: "192.168.1.5:8443", Ssl: config.SSL{CertFile: cert, KeyFile: key}}
+
+	conf = config.Configuration{Listeners: []config.Listener{tlsListener}}
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_event_start curl -s --cacert `+cert+` "https://192.168.1.5:8443/internal/event/start?camera=%t&event=%v"`, commands[0])
+
+	conf.Listeners[0].Address = "192.168.1.6:8443"
+	_, err = HookCommands(conf)
+	require.Error(t, err)
+
+	//Client certificates can't be sent by hooks
+	conf.Listeners[0] = tlsListener
+	conf.Listeners[0].Ssl.RequireClientCert = true
+	_, err = HookCommands(conf)
+	require.Error(t, err)
+
+	//Plain listeners are preferred, loopback first
+	conf.Listeners = append(conf.Listeners, config.Listener{Address: "192.168.1.5:8080"})
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_event_start curl -s "http://192.168.1.5:8080/internal/event/start?camera=%t&event=%v"`, commands[0])
+
+	conf.Listeners = []config.Listener{
+		{Address: "[::]:8080", Groups: []string{GroupAPI, GroupApp}},
+		tlsListener,
+		{Address: "[::1]:8081", Groups: []string{GroupInternal}},
+	}
+	commands, err = HookCommands(conf)
+	require.NoError(t, err)
+	require.Equal(t, `on_event_start curl -s "http://[::1]:8081/internal/event/start?camera=%t&event=%v"`, commands[0])
+
+	conf.Listeners = conf.Listeners[:1]
+	_, err = HookCommands(conf)
+	require.Error(t, err)
+}
+
+func TestInternalSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "api")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.Internal{Token: "s3cret", Socket: filepath.Join(dir, "motionctrl.sock")}
+
+	//Stale socket file is replaced
+	require.NoError(t, ioutil.WriteFile(conf.Socket, nil, 0600))
+
+	list, err := listeners(config.Configuration{Address: "127.0.0.1", Port: 8888, Internal: conf})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	s, err := newServer(config.Configuration{Internal: conf}, list[1])
+	require.NoError(t, err)
+	go s.serve()
+	defer s.http.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", conf.Socket)
+		},
+	}}
+
+	get := func(token string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/internal/event/end?camera=1&event=1", nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(InternalTokenHeader, token)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusUnauthorized, get(""))
+	require.Equal(t, http.StatusOK, get("s3cret"))
+}
+
+func TestListeners(t *testing.T) {
+	list, err := listeners(config.Configuration{Address: "::", Port: 8888})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	require.Equal(t, "[::]:8888", list[0].Address)
+	require.Equal(t, allGroups, list[0].Groups)
+
+	_, err = listeners(config.Configuration{})
+	require.Error(t, err)
+
+	conf := config.Configuration{Listeners: []config.Listener{{Address: "127.0.0.1:8080", Groups: []string{GroupAPI}}}}
+	list, err = listeners(conf)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	conf.Listeners[0].Groups = []string{"admin"}
+	_, err = listeners(conf)
+	require.Error(t, err)
+
+	conf.Listeners[0] = config.Listener{Address: "127.0.0.1:8080", Auth: "basic"}
+	_, err = listeners(conf)
+	require.Error(t, err)
+
+	conf.Listeners[0] = config.Listener{Address: ":80", Redirect: "443", Ssl: config.SSL{CertFile: "cert.pem", KeyFile: "key.pem"}}
+	_, err = listeners(conf)
+	require.Error(t, err)
+}
+
+func TestListenerGroups(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	conf := config.Configuration{Username: "user", Password: "pass", Metrics: config.Metrics{Enabled: true, Auth: MetricsAuthNone}}
+
+	request := func(l config.Listener, path string) int {
+		router, err := newRouter(conf, l, nil)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "127.0.0.1:4000"
+		router.ServeHTTP(w, req)
+
+		return w.Code
+	}
+
+	all := config.Listener{Address: "127.0.0.1:8888", Groups: allGroups}
+	require.Equal(t, http.StatusUnauthorized, request(all, "/api/ssl/fingerprint"))
+	require.Equal(t, http.StatusOK, request(all, "/metrics"))
+
+	metricsOnly := config.Listener{Address: "127.0.0.1:9100", Groups: []string{GroupMetrics}}
+	require.Equal(t, http.StatusOK, request(metricsOnly, "/metrics"))
+	require.Equal(t, http.StatusNotFound, request(metricsOnly, "/api/ssl/fingerprint"))
+	require.Equal(t, http.StatusNotFound, request(metricsOnly, "/internal/event/end"))
+
+	kiosk := config.Listener{Address: "127.0.0.1:8080", Groups: []string{GroupAPI}, Auth: ListenerAuthNone}
+	require.Equal(t, http.StatusNotFound, request(kiosk, "/api/ssl/fingerprint"))
+	require.Equal(t, http.StatusNotFound, request(kiosk, "/metrics"))
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	location := func(target, host, path string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		redirectToHTTPS(target).ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusMovedPermanently, w.Code)
+		return w.Header().Get("Location")
+	}
+
+	require.Equal(t, "https://camera.lan/api/control/status", location("443", "camera.lan", "/api/control/status"))
+	require.Equal(t, "https://camera.lan:8443/app/?a=1", location("8443", "camera.lan:8080", "/app/?a=1"))
+	require.Equal(t, "https://[fe80::1]:8443/", location("8443", "[fe80::1]:8080", "/"))
+	require.Equal(t, "https://[fe80::1]/", location("443", "[fe80::1]", "/"))
+	require.Equal(t, "https://camera.example.com/api", location("https://camera.example.com/", "10.0.0.2", "/api"))
+}
+
+func TestBasePath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for path, expected := range map[string]string{"": "", "/": "", "camera": "/camera", "/camera/": "/camera", "/home/camera": "/home/camera"} {
+		normalized, err := normalizeBasePath(path)
+		require.NoError(t, err)
+		require.Equal(t, expected, normalized)
+	}
+
+	for _, path := range []string{"/camera/../api", "/camera?a=1", "//camera"} {
+		_, err := normalizeBasePath(path)
+		require.Error(t, err, path)
+	}
+
+	basePath = "/camera"
+	defer func() { basePath = "" }()
+
+	router, err := newRouter(config.Configuration{}, config.Listener{Address: "127.0.0.1:8888", Groups: allGroups}, nil)
+	require.NoError(t, err)
+
+	request := func(path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, request("/camera/api/ssl/fingerprint"))
+	require.Equal(t, http.StatusOK, request("/camera/api/notify/status"))
+	require.Equal(t, http.StatusNotFound, request("/api/notify/status"))
+
+	commands, err := HookCommands(config.Configuration{Address: "127.0.0.1", Port: 8888, Proxy: config.Proxy{BasePath: "/camera/"}})
+	require.NoError(t, err)
+	require.Equal(t, `on_event_start curl -s "http://127.0.0.1:8888/camera/internal/event/start?camera=%t&event=%v"`, commands[0])
+}
+
+func TestForwarded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRequest := func(conf config.Proxy) func(string, map[string]string) string {
+		fwd, err := forwarded(conf)
+		require.NoError(t, err)
+
+		router := gin.New()
+		router.ForwardedByClientIP = false
+		router.Use(fwd)
+		router.GET("/client", func(c *gin.Context) {
+			c.String(http.StatusOK, "%s %s", c.ClientIP(), externalURL(c, "/api/control/status"))
+		})
+		router.GET("/internal", isLocalhost, func(c *gin.Context) {
+			c.String(http.StatusOK, "local")
+		})
+
+		return func(remoteAddr string, headers map[string]string) string {
+			path := "/client"
+			if headers["path"] != "" {
+				path = headers["path"]
+			}
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = remoteAddr
+			req.Host = "127.0.0.1:8888"
+			for k, v := range headers {
+				req.Header.Set(k, v)
+			}
+			router.ServeHTTP(w, req)
+			return w.Body.String()
+		}
+	}
+
+	request := newRequest(config.Proxy{Trusted: []string{"10.0.0.0/8", "127.0.0.1", "::1"}})
+
+	//Headers of untrusted clients are ignored
+	require.Equal(t, "203.0.113.7 http://127.0.0.1:8888/api/control/status", request("203.0.113.7:4000", map[string]string{"X-Forwarded-For": "127.0.0.1", "X-Forwarded-Proto": "https"}))
+
+	//Client is the first untrusted address from right, spoofed addresses on the left are skipped
+	require.Equal(t, "198.51.100.2 https://home.example.com/api/control/status", request("10.0.0.1:4000", map[string]string{
+		"X-Forwarded-For": "127.0.0.1, 198.51.100.2, 10.0.0.5", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "home.example.com",
+	}))
+
+	//Proto and host appended by the proxy win over the ones of the client
+	require.Equal(t, "198.51.100.2 http://home.example.com/api/control/status", request("10.0.0.1:4000", map[string]string{
+		"X-Forwarded-For": "198.51.100.2", "X-Forwarded-Proto": "https, http", "X-Forwarded-Host": "evil.example.com, home.example.com",
+	}))
+
+	//Forwarded is passed through unchanged by proxies that set X-Forwarded-For
+	require.Equal(t, "198.51.100.2 http://127.0.0.1:8888/api/control/status", request("127.0.0.1:4000", map[string]string{
+		"X-Forwarded-For": "198.51.100.2", "Forwarded": "for=127.0.0.1;proto=https",
+	}))
+
+	//Remote clients of a local proxy are not local
+	require.NotEqual(t, "local", request("127.0.0.1:4000", map[string]string{"path": "/internal", "X-Forwarded-For": "198.51.100.2", "Forwarded": "for=127.0.0.1"}))
+	require.Equal(t, "local", request("127.0.0.1:4000", map[string]string{"path": "/internal", "X-Forwarded-For": "127.0.0.1"}))
+	require.Equal(t, "local", request("127.0.0.1:4000", map[string]string{"path": "/internal"}))
+
+	//Local clients of a remote proxy are not local
+	require.NotEqual(t, "local", request("10.0.0.1:4000", map[string]string{"path": "/internal", "X-Forwarded-For": "127.0.0.1"}))
+
+	request = newRequest(config.Proxy{Trusted: []string{"10.0.0.0/8", "::1"}, Header: "forwarded"})
+
+	require.Equal(t, "2001:db8::1 https://home.example.com/api/control/status", request("[::1]:4000", map[string]string{
+		"Forwarded": `for=127.0.0.1;proto=http;host=evil.example.com, for="[2001:db8::1]:4711";proto=https;host=home.example.com`,
+	}))
+
+	require.Equal(t, "::1 http://127.0.0.1:8888/api/control/status", request("[::1]:4000", map[string]string{"X-Forwarded-For": "198.51.100.2"}))
+
+	_, err := forwarded(config.Proxy{Trusted: []string{"10.0.0.0/33"}})
+	require.Error(t, err)
+
+	_, err = forwarded(config.Proxy{Header: "X-Real-IP"})
+	require.Error(t, err)
+}
+
+func TestAppHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "app")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{"index.html": "<html>", "app.0123abcd.js": "js", "style.css": "css", ".env": "secret"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	request := func(fs http.FileSystem, path string, headers map[string]string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/app/*filepath", appHandler(fs))
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.Dir(dir), "/app/", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>", w.Body.String())
+	require.Equal(t, cacheIndex, w.Header().Get("Cache-Control"))
+
+	//Routes of the app get index.html, missing assets don't
+	w = request(http.Dir(dir), "/app/gallery/2018", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "<html>", w.Body.String())
+	require.Equal(t, http.StatusNotFound, request(http.Dir(dir), "/app/missing.js", nil).Code)
+	require.Equal(t, http.StatusNotFound, request(http.Dir(dir), "/app/.env", nil).Code)
+
+	require.Equal(t, cacheImmutable, request(http.Dir(dir), "/app/app.0123abcd.js", nil).Header().Get("Cache-Control"))
+	require.Equal(t, cacheAsset, request(http.Dir(dir), "/app/style.css", nil).Header().Get("Cache-Control"))
+
+	//Default dashboard is revalidated with its ETag
+	w = request(dashboard.FS(), "/app/", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), "<title>motionctrl</title>")
+	require.NotEmpty(t, w.Header().Get("ETag"))
+	require.Equal(t, http.StatusNotModified, request(dashboard.FS(), "/app/", map[string]string{"If-None-Match": w.Header().Get("ETag")}).Code)
+	require.Equal(t, http.StatusOK, request(dashboard.FS(), "/app/app.js", nil).Code)
+}
+
+func TestTrackSetParameters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/camera/track/set", trackSetHandler)
+
+	for _, query := range []string{
+		"",
+		"x=10",
+		"x=10&y=up",
+		"x=10&y=10&pan=5",
+		"y=10&tilt=5",
+		"pan=left",
+		"pan=5&tilt=1.5",
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/camera/track/set?"+query, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestMaskPreview(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/mask/:type/preview", getMaskPreview)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mask/everything/preview", nil))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/mask/privacy/preview", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPrivacyPlaceholder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	require.NoError(t, privacy.Init(config.Privacy{}, ""))
+	defer privacy.Shutdown()
+
+	router := gin.New()
+	router.GET("/privacy/enable", privacyEnable)
+	router.GET("/camera/snapshot", takeSnapshot)
+	router.GET("/camera/frame", latestFrame)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/privacy/enable", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"by":"api"`)
+
+	//motion is not running: the placeholder is returned without asking it
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/camera/snapshot?save=false", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	require.Equal(t, privacy.Placeholder(), w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/camera/frame?width=320", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	img, err := jpeg.Decode(w.Body)
+	require.NoError(t, err)
+	require.Equal(t, 320, img.Bounds().Dx())
+}
+
+func TestSessionLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	require.NoError(t, session.Init(config.Session{}))
+	defer session.Shutdown()
+
+	router, err := newRouter(config.Configuration{Username: "user", Password: "pass"}, config.Listener{Address: "127.0.0.1:8888", Groups: allGroups}, nil)
+	require.NoError(t, err)
+
+	request := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	jsonBody := map[string]string{"Content-Type": "application/json"}
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/api/auth/login", `{"username":"user","password":"wrong"}`, jsonBody).Code)
+
+	w := request(http.MethodPost, "/api/auth/login", `{"username":"user","password":"pass"}`, jsonBody)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	cookie := w.Header().Get("Set-Cookie")
+	require.Contains(t, cookie, session.CookieName+"=")
+	require.Contains(t, cookie, "Path=/api")
+	require.Contains(t, cookie, "HttpOnly")
+	require.Contains(t, cookie, "SameSite=Strict")
+	require.NotContains(t, cookie, "Secure")
+
+	withCookie := map[string]string{"Cookie": strings.Split(cookie, ";")[0]}
+
+	w = request(http.MethodGet, "/api/auth/sessions", "", withCookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"current":true`)
+
+	//Browser apps aren't asked for basic auth credentials
+	w = request(http.MethodGet, "/api/auth/sessions", "", map[string]string{requestedWithHeader: "XMLHttpRequest"})
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Empty(t, w.Header().Get("WWW-Authenticate"))
+	require.NotEmpty(t, request(http.MethodGet, "/api/auth/sessions", "", nil).Header().Get("WWW-Authenticate"))
+
+	//Form login
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/api/auth/login", "username=user&password=pass", form).Code)
+	require.Len(t, session.List(), 2)
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/auth/logout", "", withCookie).Code)
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/api/auth/sessions", "", withCookie).Code)
+
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, "/api/auth/sessions/revoke/missing", "", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}).Code)
+}
+
+func TestSecondFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir, err := ioutil.TempDir("", "totp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, session.Init(config.Session{}))
+	defer session.Shutdown()
+
+	require.NoError(t, twofactor.Init(config.TOTP{File: filepath.Join(dir, "totp.json")}))
+	defer twofactor.Shutdown()
+
+	router, err := newRouter(config.Configuration{Username: "user", Password: "pass"}, config.Listener{Address: "127.0.0.1:8888", Groups: allGroups}, nil)
+	require.NoError(t, err)
+
+	request := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	basic := map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}
+	jsonBody := map[string]string{"Content-Type": "application/json"}
+	protected := "/api/auth/sessions/revoke/missing"
+
+	//Not enrolled users don't need a second factor
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, protected, "", basic).Code)
+
+	w := request(http.MethodGet, "/api/totp/enroll", "", basic)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var enrolment struct {
+		Secret string `json:"secret"`
+		URL    string `json:"url"`
+		QR     string `json:"qr"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrolment))
+	require.Contains(t, enrolment.URL, "otpauth://totp/")
+	require.True(t, strings.HasPrefix(enrolment.QR, "data:image/png;base64,"))
+
+	require.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/totp/confirm?code=000000", "", basic).Code)
+
+	code, err := totp.GenerateCode(enrolment.Secret, time.Now())
+	require.NoError(t, err)
+
+	w = request(http.MethodGet, "/api/totp/confirm?code="+code, "", basic)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var confirmed struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+	require.Len(t, confirmed.RecoveryCodes, twofactor.RecoveryCodes)
+
+	require.Equal(t, http.StatusConflict, request(http.MethodGet, "/api/totp/enroll", "", basic).Code)
+
+	//Protected routes need a verified session, other routes don't
+	w = request(http.MethodGet, protected, "", basic)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), `"secondFactor":true`)
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/auth/sessions", "", basic).Code)
+	require.Equal(t, http.StatusBadRequest, request(http.MethodGet, "/api/totp/verify?code="+confirmed.RecoveryCodes[0], "", basic).Code)
+
+	//Session verified after login
+	w = request(http.MethodPost, "/api/auth/login", `{"username":"user","password":"pass"}`, jsonBody)
+	require.Equal(t, http.StatusOK, w.Code)
+	withCookie := map[string]string{"Cookie": strings.Split(w.Header().Get("Set-Cookie"), ";")[0]}
+
+	require.Equal(t, http.StatusForbidden, request(http.MethodGet, protected, "", withCookie).Code)
+	require.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/totp/verify?code=000000", "", withCookie).Code)
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/totp/verify?code="+confirmed.RecoveryCodes[0], "", withCookie).Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, protected, "", withCookie).Code)
+
+	//Session verified at login
+	require.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/api/auth/login", `{"username":"user","password":"pass","code":"000000"}`, jsonBody).Code)
+
+	w = request(http.MethodPost, "/api/auth/login", `{"username":"user","password":"pass","code":"`+confirmed.RecoveryCodes[1]+`"}`, jsonBody)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"secondFactor":true`)
+	withCookie = map[string]string{"Cookie": strings.Split(w.Header().Get("Set-Cookie"), ";")[0]}
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, protected, "", withCookie).Code)
+
+	w = request(http.MethodGet, "/api/totp/status", "", withCookie)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), `"verified":true`)
+	require.Contains(t, w.Body.String(), `"recoveryCodes":8`)
+
+	require.Equal(t, http.StatusOK, request(http.MethodGet, "/api/totp/disable?code="+confirmed.RecoveryCodes[2], "", withCookie).Code)
+	require.Equal(t, http.StatusNotFound, request(http.MethodGet, protected, "", basic).Code)
+}
+
+func TestShareLinks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	require.NoError(t, share.Init(config.Share{}, ""))
+	defer share.Shutdown()
+
+	router, err := newRouter(config.Configuration{Username: "user", Password: "pass"}, config.Listener{Address: "127.0.0.1:8888", Groups: allGroups}, nil)
+	require.NoError(t, err)
+
+	request := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = "camera.lan:8888"
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	auth := map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}
+
+	require.Equal(t, http.StatusBadRequest, request("/api/share/create?target=everything", auth).Code)
+	require.Equal(t, http.StatusBadRequest, request("/api/share/create?target=stream&duration=30d", auth).Code)
+
+	w := request("/api/share/create?target=snapshot&duration=2h&uses=1", auth)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var link struct {
+		ID      string `json:"id"`
+		URL     string `json:"url"`
+		MaxUses int    `json:"maxUses"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &link))
+	require.Equal(t, 1, link.MaxUses)
+	require.True(t, strings.HasPrefix(link.URL, "http://camera.lan:8888/api/camera/snapshot?"), link.URL)
+
+	//Share link grants access only to its target, without credentials
+	path := strings.TrimPrefix(link.URL, "http://camera.lan:8888")
+	require.Equal(t, http.StatusForbidden, request(strings.Replace(path, "/camera/snapshot", "/camera/stream", 1), nil).Code)
+	require.Equal(t, http.StatusForbidden, request(strings.Replace(path, "/camera/snapshot", "/config/list", 1), nil).Code)
+	require.Equal(t, http.StatusForbidden, request(path+"&timeout=60", nil).Code)
+	require.NotEqual(t, http.StatusForbidden, request(path, nil).Code)
+	require.NotEqual(t, http.StatusUnauthorized, request(path, nil).Code)
+
+	w = request("/api/share/list", auth)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Contains(t, w.Body.String(), link.ID)
+
+	require.Equal(t, http.StatusOK, request("/api/share/revoke/"+link.ID, auth).Code)
+	require.Equal(t, http.StatusNotFound, request("/api/share/revoke/"+link.ID, auth).Code)
+	require.Equal(t, http.StatusForbidden, request(path, nil).Code)
+}
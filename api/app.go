@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	appIndex = "/index.html"
+
+	// index is revalidated on every load, so a new version of the app is picked up at once
+	cacheIndex = "no-cache"
+	// fingerprinted assets (e.g. app.3f2a9c1d.js) never change
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheAsset     = "public, max-age=300"
+)
+
+var fingerprinted = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[a-zA-Z0-9]+$`)
+
+// appHandler serves a single page application: paths that aren't files (e.g. /app/gallery/2018) get index.html,
+// so the app can route them. Missing assets (paths with an extension) get 404
+func appHandler(fs http.FileSystem) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := path.Clean("/" + c.Param("filepath"))
+
+		//Hidden files (e.g. .git, .env) are never served
+		if strings.Contains(name, "/.") {
+			c.Status(http.StatusNotFound)
+			return
+		}
+
+		f, info, err := openAppFile(fs, name)
+
+		if os.IsNotExist(err) && path.Ext(name) == "" {
+			f, info, err = openAppFile(fs, appIndex)
+		}
+
+		if err != nil {
+			if os.IsNotExist(err) {
+				c.Status(http.StatusNotFound)
+			} else {
+				c.Status(http.StatusInternalServerError)
+			}
+			return
+		}
+		defer f.Close()
+
+		switch {
+		case info.Name() == path.Base(appIndex):
+			c.Header("Cache-Control", cacheIndex)
+		case fingerprinted.MatchString(info.Name()):
+			c.Header("Cache-Control", cacheImmutable)
+		default:
+			c.Header("Cache-Control", cacheAsset)
+		}
+
+		//Files compiled into motionctrl have no modification time
+		if e, ok := f.(interface{ ETag() string }); ok {
+			c.Header("ETag", e.ETag())
+		}
+
+		http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+	}
+}
+
+// openAppFile opens a file of the app, index.html is opened for directories
+func openAppFile(fs http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		f.Close()
+		return openAppFile(fs, path.Join(name, appIndex))
+	}
+
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/share"
+)
+
+const (
+	sessionKey = "session"
+
+	// requestedWithHeader is sent by browser apps (e.g. the dashboard): their 401 responses don't make browsers prompt for credentials
+	requestedWithHeader = "X-Requested-With"
+)
+
+// authentication accepts requests carrying a share link for the requested api, coming with a valid session cookie,
+// with a verified client certificate mapped to a user or with valid username and password. It returns nil when neither client certificates nor username/password are configured
+func authentication(conf config.Configuration) gin.HandlerFunc {
+	basic := conf.Username != "" && conf.Password != ""
+	clientCert := conf.Ssl.ClientCA != ""
+
+	if !basic && !clientCert {
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		if c.Query(share.ParamID) != "" {
+			if link, err := useShare(c); err == nil {
+				c.Set(gin.AuthUserKey, "share:"+link.ID)
+				c.Set(shareKey, link.ID)
+			} else {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			}
+			return
+		}
+
+		if s, ok := sessionOf(c); ok {
+			c.Set(gin.AuthUserKey, s.User)
+			c.Set(sessionKey, s.ID)
+			c.Set(secondFactorKey, s.SecondFactor)
+			return
+		}
+
+		if user, ok := credentials(conf, c.Request); ok {
+			c.Set(gin.AuthUserKey, user)
+			return
+		}
+
+		if basic && c.GetHeader(requestedWithHeader) == "" {
+			c.Header("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "authentication required"})
+	}
+}
+
+// credentials returns the user of a verified client certificate or of valid username and password
+func credentials(conf config.Configuration, r *http.Request) (string, bool) {
+	if user, ok := clientCertUser(r, conf.Ssl.ClientUsers); ok {
+		return user, true
+	}
+
+	username, password, ok := r.BasicAuth()
+	return username, ok && validPassword(conf, username, password)
+}
+
+func validPassword(conf config.Configuration, username, password string) bool {
+	return conf.Username != "" && conf.Password != "" && secureCompare(username, conf.Username) && secureCompare(password, conf.Password)
+}
+
+// clientCertUser returns the user of the verified client certificate: users maps certificate subjects
+// (full distinguished name or common name) to user names, when empty common name is the user name
+func clientCertUser(r *http.Request, users map[string]string) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+
+	if len(users) == 0 {
+		return subject.CommonName, subject.CommonName != ""
+	}
+
+	if user, ok := users[subject.String()]; ok {
+		return user, true
+	}
+
+	user, ok := users[subject.CommonName]
+	return user, ok
+}
+
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
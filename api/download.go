@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/archive"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/version"
+)
+
+func downloadTargetDir(c *gin.Context) {
+	format := c.DefaultQuery("format", archive.FormatZip)
+	if !archive.ValidFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'format' parameter must be '%s' or '%s'", archive.FormatZip, archive.FormatTarGz)})
+		return
+	}
+
+	var files []archive.File
+
+	if names := c.QueryArray("file"); len(names) > 0 {
+		//Explicit list of files
+		for _, name := range names {
+			name = strings.TrimPrefix(name, "/")
+
+			filePath, err := motion.TargetDirGetFile(name)
+			if err != nil {
+				c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to get: %s in target dir: %v", name, err)})
+				return
+			}
+
+			files = append(files, archive.File{Name: name, Path: filePath})
+		}
+	} else {
+		//Files matching filters, as in /targetdir/list
+		query, err := targetDirQuery(c)
+		if err == nil {
+			err = query.Validate()
+		}
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+
+		if query.From.IsZero() && query.To.IsZero() && query.Glob == "" && query.Type == "" && len(query.Extensions) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "at least one among 'file', 'from', 'to', 'glob', 'type' and 'ext' parameters is required"})
+			return
+		}
+
+		selected, err := motion.TargetDirSelect(query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		for _, f := range selected {
+			filePath, err := motion.TargetDirGetFile(f.Name)
+			if err != nil {
+				c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to get: %s in target dir: %v", f.Name, err)})
+				return
+			}
+
+			files = append(files, archive.File{Name: f.Name, Path: filePath})
+		}
+	}
+
+	if len(files) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "no file matches the request"})
+		return
+	}
+
+	fileName := version.Name + "_" + time.Now().Format("20060102_150405") + "." + format
+
+	c.Header("Content-Type", archive.ContentType(format))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	//Response is already started, on error the archive is left truncated and the client notices it
+	if err := archive.Write(c.Writer, format, files); err != nil {
+		glg.Errorf("Archive %s interrupted: %v", fileName, err)
+	}
+}
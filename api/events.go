@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/events"
+)
+
+const (
+	eventsKeepAlive = 30 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+// eventsHandler streams events to the client through WebSocket (if requested) or Server-Sent Events
+func eventsHandler(c *gin.Context) {
+	var types []events.Type
+
+	if typesParam := c.Query("types"); typesParam != "" {
+		for _, t := range strings.Split(typesParam, ",") {
+			types = append(types, events.Type(strings.TrimSpace(t)))
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("lastEventId")
+	}
+
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "'Last-Event-ID' must be a positive integer"})
+			return
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		eventsWebSocket(c, lastID, types)
+	} else {
+		eventsSSE(c, lastID, types)
+	}
+}
+
+func eventsSSE(c *gin.Context, lastID uint64, types []events.Type) {
+	flusher, ok := c.Writer.(http.Flusher)
+
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "streaming not supported"})
+		return
+	}
+
+	sub := events.Subscribe(lastID, types)
+	defer events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	glg.Debugf("SSE client connected from %s", c.Request.RemoteAddr)
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				glg.Errorf("Unable to marshal event: %v", err)
+				continue
+			}
+
+			if _, err = fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(c.Writer, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-c.Request.Context().Done():
+			glg.Debugf("SSE client disconnected from %s", c.Request.RemoteAddr)
+			return
+		}
+	}
+}
+
+func eventsWebSocket(c *gin.Context, lastID uint64, types []events.Type) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+
+	if err != nil {
+		glg.Errorf("Unable to upgrade connection to WebSocket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := events.Subscribe(lastID, types)
+	defer events.Unsubscribe(sub)
+
+	//Nothing is expected from the client, reading is only needed to notice when it goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	glg.Debugf("WebSocket client connected from %s", c.Request.RemoteAddr)
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"))
+				return
+			}
+
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			glg.Debugf("WebSocket client disconnected from %s", c.Request.RemoteAddr)
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/history"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+func eventsHistory(c *gin.Context) {
+	var query history.Query
+	var err error
+
+	if from := c.Query("from"); from != "" {
+		if query.From, err = utils.ParseTime(from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid 'from' parameter: %v", err)})
+			return
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		if query.To, err = utils.ParseTime(to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid 'to' parameter: %v", err)})
+			return
+		}
+	}
+
+	if camera := c.Query("camera"); camera != "" {
+		id, err := strconv.Atoi(camera)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "'camera' parameter must be an integer"})
+			return
+		}
+		query.Camera = &id
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "'limit' parameter must be an integer"})
+			return
+		}
+	}
+
+	if cursor := c.Query("cursor"); cursor != "" {
+		if query.Before, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid 'cursor' parameter"})
+			return
+		}
+	}
+
+	if page, err := history.List(query); err == nil {
+		c.JSON(http.StatusOK, page)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	}
+}
+
+func eventFromHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid event 'id'"})
+		return
+	}
+
+	if record, err := history.Get(id); err == nil {
+		c.JSON(http.StatusOK, record)
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	}
+}
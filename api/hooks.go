@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/andreacioni/motionctrl/certificate"
+	"github.com/andreacioni/motionctrl/config"
+)
+
+// hooks are the motion options calling /internal api, the query they send and the parameter carrying the file (%f), if any.
+// File names may contain any character, so they are URL-encoded by curl
+var hooks = []struct {
+	option string
+	path   string
+	query  string
+	file   string
+}{
+	{"on_event_start", "/event/start", "camera=%t&event=%v", ""},
+	{"on_event_end", "/event/end", "camera=%t&event=%v", ""},
+	{"on_picture_save", "/event/picture/saved", "camera=%t&event=%v", "picturepath"},
+	{"on_movie_end", "/event/movie/saved", "camera=%t&event=%v", "moviepath"},
+}
+
+// HookCommands returns motion configuration lines that send events to /internal api, the shared token is included.
+// Unix sockets are preferred, then plain HTTP listeners (on loopback first) and HTTPS listeners. Listeners requiring
+// client certificates can't be used by curl
+func HookCommands(conf config.Configuration) ([]string, error) {
+	list, err := listeners(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, err := normalizeBasePath(conf.Proxy.BasePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *config.Listener
+	var clientCert string
+	for i := range list {
+		l := &list[i]
+		if !hasGroup(*l, GroupInternal) || l.Redirect != "" {
+			continue
+		}
+
+		if l.Ssl.RequireClientCert {
+			clientCert = l.Address
+			continue
+		}
+
+		if target == nil || hookPreference(*l) < hookPreference(*target) {
+			target = l
+		}
+	}
+
+	if target == nil && clientCert != "" {
+		return nil, fmt.Errorf("Listener %s serving /internal api requires client certificates, motion hooks can't use it: define 'internal.socket' or a plain listener on loopback", clientCert)
+	}
+
+	if target == nil {
+		return nil, fmt.Errorf("No listener serves /internal api")
+	}
+
+	var curl, baseURL string
+
+	if isUnix(*target) {
+		curl = fmt.Sprintf("curl -s --unix-socket %s", strings.TrimPrefix(target.Address, unixPrefix))
+		baseURL = "http://localhost"
+	} else {
+		host, port, err := net.SplitHostPort(target.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		if target.Ssl.IsEmpty() {
+			curl = "curl -s"
+			if isUnspecified(host) {
+				host = "localhost"
+			}
+			baseURL = fmt.Sprintf("http://%s", net.JoinHostPort(host, port))
+		} else {
+			urlHost, resolve, err := hookTLSHost(*target, host, port)
+			if err != nil {
+				return nil, err
+			}
+
+			curl = fmt.Sprintf("curl -s --cacert %s%s", target.Ssl.CertFile, resolve)
+			baseURL = fmt.Sprintf("https://%s", net.JoinHostPort(urlHost, port))
+		}
+	}
+
+	if conf.Internal.Token != "" {
+		curl += fmt.Sprintf(" -H \"%s: %s\"", InternalTokenHeader, conf.Internal.Token)
+	}
+
+	commands := make([]string, 0, len(hooks))
+	for _, h := range hooks {
+		command := fmt.Sprintf("%s %s \"%s%s/internal%s?%s\"", h.option, curl, baseURL, prefix, h.path, h.query)
+		if h.file != "" {
+			command += fmt.Sprintf(" -G --data-urlencode \"%s=%%f\"", h.file)
+		}
+		commands = append(commands, command)
+	}
+
+	return commands, nil
+}
+
+// hookPreference ranks listeners for hooks, lower is better
+func hookPreference(l config.Listener) int {
+	switch {
+	case isUnix(l):
+		return 0
+	case !l.Ssl.IsEmpty():
+		return 3
+	}
+
+	if host, _, err := net.SplitHostPort(l.Address); err == nil {
+		if ip := net.ParseIP(host); isUnspecified(host) || host == "localhost" || ip != nil && ip.IsLoopback() {
+			return 1
+		}
+	}
+
+	return 2
+}
+
+// hookTLSHost returns the host of https URLs and, if curl can't connect to it directly, the --resolve option.
+// curl verifies the certificate against the host of the URL, so it must be one of the hosts of the certificate
+func hookTLSHost(l config.Listener, host, port string) (string, string, error) {
+	hosts, err := certificate.Hosts(l.Ssl)
+	if err != nil {
+		return "", "", fmt.Errorf("Listener %s: unable to read certificate: %v", l.Address, err)
+	}
+
+	//Addresses reaching the listener
+	reachable := []string{host}
+	if isUnspecified(host) {
+		reachable = []string{"localhost", "127.0.0.1", "::1"}
+	}
+
+	for _, r := range reachable {
+		for _, h := range hosts {
+			if strings.EqualFold(h, r) || net.ParseIP(h) != nil && net.ParseIP(h).Equal(net.ParseIP(r)) {
+				return r, "", nil
+			}
+		}
+	}
+
+	//Otherwise a name of the certificate is resolved to the address of the listener
+	address := net.ParseIP(host)
+	if isUnspecified(host) {
+		address = net.IPv4(127, 0, 0, 1)
+	}
+
+	if address != nil {
+		for _, h := range hosts {
+			if net.ParseIP(h) == nil && !strings.Contains(h, "*") {
+				return h, fmt.Sprintf(" --resolve %s:%s:%s", h, port, resolveAddress(address)), nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("Listener %s: no host of its certificate (%s) reaches it, define 'internal.socket' or a plain listener on loopback", l.Address, strings.Join(hosts, ", "))
+}
+
+func resolveAddress(ip net.IP) string {
+	if ip.To4() == nil {
+		return "[" + ip.String() + "]"
+	}
+	return ip.String()
+}
+
+func isUnspecified(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || ip != nil && ip.IsUnspecified()
+}
@@ -1,16 +1,24 @@
 package api
 
 import (
+	"strconv"
+
 	"github.com/gin-gonic/gin"
 	"github.com/kpango/glg"
 
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/metrics"
+	"github.com/andreacioni/motionctrl/motion"
 	"github.com/andreacioni/motionctrl/notify"
 )
 
 func eventStart(c *gin.Context) {
+	events.Publish(events.TypeEventStart, cameraID(c), "", eventData(c))
+	metrics.EventStarted(cameraID(c))
 	notify.MotionDetectedStart()
 }
 func eventEnd(c *gin.Context) {
+	events.Publish(events.TypeEventEnd, cameraID(c), "", eventData(c))
 	notify.MotionDetectedStop()
 }
 func motionDetected(c *gin.Context) { //TODO not sure this is useful by now
@@ -22,9 +30,42 @@ func pictureSaved(c *gin.Context) {
 
 	if picturePath != "" {
 		glg.Debugf("Picture saved in: %s", picturePath)
+		events.Publish(events.TypePictureSaved, cameraID(c), motion.TargetDirRelPath(picturePath), eventData(c))
+		metrics.PictureSaved(cameraID(c))
 		notify.PhotoSaved(picturePath)
 	} else {
 		glg.Warnf("'picturepath' not found. Unable to know where picture is.")
 	}
 
 }
+
+func movieSaved(c *gin.Context) {
+	moviePath := c.Query("moviepath")
+
+	if moviePath != "" {
+		glg.Debugf("Movie saved in: %s", moviePath)
+		events.Publish(events.TypeMovieSaved, cameraID(c), motion.TargetDirRelPath(moviePath), eventData(c))
+	} else {
+		glg.Warnf("'moviepath' not found. Unable to know where movie is.")
+	}
+}
+
+// cameraID returns the camera (thread) number passed by motion with %t, 0 if missing
+func cameraID(c *gin.Context) int {
+	id, err := strconv.Atoi(c.Query("camera"))
+
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+// eventData returns the event number passed by motion with %v, if any
+func eventData(c *gin.Context) map[string]interface{} {
+	if event := c.Query("event"); event != "" {
+		return map[string]interface{}{"event": event}
+	}
+
+	return nil
+}
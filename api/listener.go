@@ -0,0 +1,287 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/certificate"
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/dashboard"
+)
+
+const (
+	GroupAPI      = "api"
+	GroupApp      = "app"
+	GroupInternal = "internal"
+	GroupMetrics  = "metrics"
+
+	// ListenerAuthNone disables /api authentication on a listener (e.g. localhost for a kiosk)
+	ListenerAuthNone = "none"
+
+	unixPrefix     = "unix:"
+	certificateKey = "certificate"
+)
+
+var allGroups = []string{GroupAPI, GroupApp, GroupInternal, GroupMetrics}
+
+// server is a listener ready to serve
+type server struct {
+	conf     config.Listener
+	http     *http.Server
+	listener net.Listener
+}
+
+// defaultCertificate is the certificate of the first HTTPS listener, /ssl/fingerprint returns it on plain HTTP listeners
+var defaultCertificate *certificate.Reloader
+
+// listeners returns configured listeners, a single one is built from address, port and ssl when none is defined.
+// The internal socket, if any, is an additional listener serving only /internal api
+func listeners(conf config.Configuration) ([]config.Listener, error) {
+	var list []config.Listener
+
+	if len(conf.Listeners) > 0 {
+		list = append(list, conf.Listeners...)
+	} else {
+		if conf.Address == "" || conf.Port <= 0 {
+			return nil, fmt.Errorf("Address and/or port not defined in configuration")
+		}
+
+		list = append(list, config.Listener{Address: net.JoinHostPort(conf.Address, strconv.Itoa(conf.Port)), Ssl: conf.Ssl})
+	}
+
+	if conf.Internal.Token != "" && !internalTokenRegex.MatchString(conf.Internal.Token) {
+		return nil, fmt.Errorf("Invalid 'internal.token': only letters, digits and . _ ~ + / = - are allowed")
+	}
+
+	if conf.Internal.Socket != "" {
+		list = append(list, config.Listener{Address: unixPrefix + conf.Internal.Socket, Groups: []string{GroupInternal}})
+	}
+
+	for i := range list {
+		l := &list[i]
+
+		if l.Address == "" {
+			return nil, fmt.Errorf("Listener %d: address not defined", i)
+		}
+
+		if len(l.Groups) == 0 {
+			l.Groups = allGroups
+		}
+
+		for _, g := range l.Groups {
+			if g != GroupAPI && g != GroupApp && g != GroupInternal && g != GroupMetrics {
+				return nil, fmt.Errorf("Listener %s: invalid group '%s' (available: %s)", l.Address, g, strings.Join(allGroups, ", "))
+			}
+		}
+
+		if l.Auth != "" && l.Auth != ListenerAuthNone {
+			return nil, fmt.Errorf("Listener %s: invalid auth '%s'", l.Address, l.Auth)
+		}
+
+		if l.Redirect != "" && !l.Ssl.IsEmpty() {
+			return nil, fmt.Errorf("Listener %s: redirect listener can't use SSL/TLS", l.Address)
+		}
+	}
+
+	return list, nil
+}
+
+func isUnix(l config.Listener) bool {
+	return strings.HasPrefix(l.Address, unixPrefix)
+}
+
+func hasGroup(l config.Listener, group string) bool {
+	for _, g := range l.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// newServer binds the listener address, nothing is served until serve is called
+func newServer(conf config.Configuration, l config.Listener) (*server, error) {
+	s := &server{conf: l, http: &http.Server{}}
+
+	if l.Redirect != "" {
+		s.http.Handler = redirectToHTTPS(l.Redirect)
+	} else {
+		var cert *certificate.Reloader
+
+		if !l.Ssl.IsEmpty() {
+			var err error
+			if cert, err = certificate.Load(l.Ssl); err != nil {
+				return nil, fmt.Errorf("unable to load SSL/TLS certificate: %v", err)
+			}
+
+			s.http.TLSConfig = cert.TLSConfig()
+
+			if defaultCertificate == nil {
+				defaultCertificate = cert
+			}
+		}
+
+		router, err := newRouter(conf, l, cert)
+		if err != nil {
+			return nil, err
+		}
+
+		s.http.Handler = router
+	}
+
+	network, address := "tcp", l.Address
+
+	if isUnix(l) {
+		network, address = "unix", strings.TrimPrefix(l.Address, unixPrefix)
+
+		//Stale socket of a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if network == "unix" {
+		//Access is granted by socket file permissions
+		if err := os.Chmod(address, 0660); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	s.listener = listener
+
+	return s, nil
+}
+
+// newRouter serves the route groups of the listener
+func newRouter(conf config.Configuration, l config.Listener, cert *certificate.Reloader) (*gin.Engine, error) {
+	router := gin.Default()
+
+	//Client IP is set by forwarded middleware, only for requests of trusted proxies
+	router.ForwardedByClientIP = false
+
+	fwd, err := forwarded(conf.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(fwd)
+
+	//Client certificates are verified with the CA of this listener
+	conf.Ssl = l.Ssl
+
+	if cert != nil {
+		router.Use(func(c *gin.Context) {
+			c.Set(certificateKey, cert)
+		})
+	}
+
+	root := router.Group(basePath)
+
+	for _, g := range l.Groups {
+		switch g {
+		case GroupApp:
+			fs, source := dashboard.FS(), "default dashboard"
+			if conf.AppPath != "" {
+				fs, source = http.Dir(conf.AppPath), conf.AppPath
+			}
+
+			glg.Infof("Serving %s to %s/app", source, basePath)
+			app := appHandler(fs)
+			root.GET("/app/*filepath", app)
+			root.HEAD("/app/*filepath", app)
+		case GroupInternal:
+			internal := root.Group("/internal", internalMiddlewares(conf.Internal, isUnix(l))...)
+
+			for path, handler := range internalHandlersMap {
+				internal.Handle(handler.method, path, handler.f)
+			}
+		case GroupAPI:
+			var group *gin.RouterGroup
+
+			if auth := authentication(conf); auth != nil && l.Auth != ListenerAuthNone {
+				glg.Infof("Username and password or client certificate CA defined, authentication enabled on %s", l.Address)
+				root.POST("/api/auth/login", login(conf))
+				group = root.Group("/api", auth, secondFactor(conf))
+			} else {
+				glg.Warnf("Authentication disabled on %s", l.Address)
+				group = root.Group("/api")
+			}
+
+			for path, handler := range handlersMap {
+				group.Handle(handler.method, path, append(handler.m, handler.f)...)
+			}
+		case GroupMetrics:
+			if conf.Metrics.Enabled {
+				handlers, err := metricsHandlers(conf)
+				if err != nil {
+					return nil, err
+				}
+
+				root.GET("/metrics", handlers...)
+			}
+		}
+	}
+
+	return router, nil
+}
+
+func (s *server) serve() {
+	var err error
+
+	if s.http.TLSConfig != nil {
+		glg.Infof("Listening on %s with SSL/TLS (certificate: %s, groups: %v)", s.conf.Address, s.conf.Ssl.CertFile, s.conf.Groups)
+		//Certificate and key are reloaded by certificate package when they change
+		err = s.http.ServeTLS(s.listener, "", "")
+	} else if s.conf.Redirect != "" {
+		glg.Infof("Listening on %s, redirecting to HTTPS (%s)", s.conf.Address, s.conf.Redirect)
+		err = s.http.Serve(s.listener)
+	} else {
+		if !isUnix(s.conf) {
+			glg.Warnf("SSL/TLS NOT enabled on %s", s.conf.Address)
+		}
+		glg.Infof("Listening on %s (groups: %v)", s.conf.Address, s.conf.Groups)
+		err = s.http.Serve(s.listener)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
+		glg.Error(err)
+	}
+}
+
+// redirectToHTTPS redirects every request to HTTPS, target is the port of the HTTPS listener (request host is kept) or a base URL
+func redirectToHTTPS(target string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := strings.TrimSuffix(target, "/")
+
+		if _, err := strconv.Atoi(target); err == nil {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+			if target == "443" {
+				base = "https://" + host
+				if strings.Contains(host, ":") {
+					base = "https://[" + host + "]"
+				}
+			} else {
+				base = "https://" + net.JoinHostPort(host, target)
+			}
+		}
+
+		http.Redirect(w, r, base+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
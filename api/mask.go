@@ -0,0 +1,249 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/mask"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/privacy"
+	"github.com/andreacioni/motionctrl/stream"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	maxMaskUpload = 16 << 20
+
+	// maxMaskScale is how much uploaded masks can be larger than the camera, in each dimension
+	maxMaskScale = 4
+)
+
+// maskConfig maps mask types to the motion parameter of their file
+var maskConfig = map[string]string{
+	mask.TypeDetection: motion.ConfigMaskFile,
+	mask.TypePrivacy:   motion.ConfigMaskPrivacy,
+}
+
+// setMask builds a mask from polygons and rectangles (JSON body) or from an uploaded PGM/PNG picture (body or 'file' form field),
+// writes it next to motion.conf, sets it in motion and returns its preview on the current frame
+func setMask(c *gin.Context) {
+	kind, param, ok := maskType(c)
+	if !ok {
+		return
+	}
+
+	width, height, err := cameraSize()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMaskUpload)
+
+	var m *image.Gray
+
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		var shapes mask.Shapes
+		if err := json.NewDecoder(c.Request.Body).Decode(&shapes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid JSON body: %v", err)})
+			return
+		}
+
+		if m, err = mask.Rasterize(shapes, width, height); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+	} else {
+		var body io.Reader = c.Request.Body
+
+		if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+			file, _, err := c.Request.FormFile("file")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "'file' form field is required"})
+				return
+			}
+			defer file.Close()
+			body = file
+		}
+
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("unable to read picture: %v", err)})
+			return
+		}
+
+		//Size is checked before decoding: a small header can ask for gigabytes of pixels
+		conf, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("PGM or PNG picture expected: %v", err)})
+			return
+		}
+
+		if conf.Width > maxMaskScale*width || conf.Height > maxMaskScale*height {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("picture too large: %dx%d (camera: %dx%d)", conf.Width, conf.Height, width, height)})
+			return
+		}
+
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("PGM or PNG picture expected: %v", err)})
+			return
+		}
+
+		m = mask.FromImage(img, width, height)
+	}
+
+	file, err := writeMask(kind, m)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := motion.ConfigSet(param, file); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	//The mask must survive restarts: motion reads it only when the camera starts
+	if err := motion.ConfigWrite(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	glg.Infof("%s mask (%dx%d) written to %s", kind, width, height, file)
+
+	maskPreview(c, m)
+}
+
+// getMaskPreview returns the preview of the last mask set by motionctrl
+func getMaskPreview(c *gin.Context) {
+	kind, _, ok := maskType(c)
+	if !ok {
+		return
+	}
+
+	file, err := maskFile(kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("no %s mask set", kind)})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		}
+		return
+	}
+	defer f.Close()
+
+	img, err := utils.DecodePGM(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	maskPreview(c, img.(*image.Gray))
+}
+
+// maskPreview writes m over the current frame as PNG, over a grey background if the stream isn't available or in privacy mode
+func maskPreview(c *gin.Context, m *image.Gray) {
+	var frameImg image.Image
+
+	if privacy.Enabled() {
+		glg.Debug("Privacy mode enabled, mask preview without frame")
+	} else if frame, err := stream.WaitFrame(frameTimeout); err == nil {
+		if frameImg, err = jpeg.Decode(bytes.NewReader(frame.Data)); err != nil {
+			glg.Warnf("Unable to decode frame for mask preview: %v", err)
+		}
+	} else {
+		glg.Warnf("No frame for mask preview: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, mask.Preview(frameImg, m)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
+
+func maskType(c *gin.Context) (string, string, bool) {
+	kind := c.Param("type")
+
+	param, ok := maskConfig[kind]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("mask type must be '%s' or '%s'", mask.TypeDetection, mask.TypePrivacy)})
+	}
+
+	return kind, param, ok
+}
+
+// cameraSize returns the resolution of the camera, masks must match it
+func cameraSize() (int, int, error) {
+	var size [2]int
+
+	for i, param := range []string{motion.ConfigWidth, motion.ConfigHeight} {
+		value, err := motion.ConfigGet(param)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unable to get '%s': %v", param, err)
+		}
+
+		if size[i], _ = value.(int); size[i] <= 0 {
+			return 0, 0, fmt.Errorf("invalid '%s': %v", param, value)
+		}
+	}
+
+	return size[0], size[1], nil
+}
+
+// maskFile is the path of the mask of kind, next to motion.conf. It is absolute since motion may run from another directory
+func maskFile(kind string) (string, error) {
+	conf, err := filepath.Abs(motion.ConfigFile())
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(conf), "mask_"+kind+".pgm"), nil
+}
+
+// writeMask replaces the file of kind atomically, motion may be reading it
+func writeMask(kind string, m *image.Gray) (string, error) {
+	file, err := maskFile(kind)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := utils.EncodePGM(&buf, m); err != nil {
+		return "", err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return "", fmt.Errorf("unable to write %s: %v", tmp, err)
+	}
+
+	if err := os.Rename(tmp, file); err != nil {
+		return "", fmt.Errorf("unable to write %s: %v", file, err)
+	}
+
+	return file, nil
+}
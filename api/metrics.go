@@ -0,0 +1,42 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/metrics"
+)
+
+const (
+	MetricsAuthAPI   = "api"
+	MetricsAuthBasic = "basic"
+	MetricsAuthNone  = "none"
+)
+
+// metricsHandlers returns /metrics handlers, authentication is configured independently from /api one
+func metricsHandlers(conf config.Configuration) ([]gin.HandlerFunc, error) {
+	var handlers []gin.HandlerFunc
+
+	switch conf.Metrics.Auth {
+	case MetricsAuthAPI, "":
+		if auth := authentication(conf); auth != nil {
+			handlers = append(handlers, auth)
+		} else {
+			glg.Warn("Metrics authentication disabled, same as /api")
+		}
+	case MetricsAuthBasic:
+		if conf.Metrics.Username == "" || conf.Metrics.Password == "" {
+			return nil, fmt.Errorf("'metrics.username' and 'metrics.password' are required by '%s' authentication", MetricsAuthBasic)
+		}
+		handlers = append(handlers, gin.BasicAuth(gin.Accounts{conf.Metrics.Username: conf.Metrics.Password}))
+	case MetricsAuthNone:
+		glg.Warn("Metrics authentication disabled")
+	default:
+		return nil, fmt.Errorf("Invalid 'metrics.auth': %s (available: %s, %s, %s)", conf.Metrics.Auth, MetricsAuthAPI, MetricsAuthBasic, MetricsAuthNone)
+	}
+
+	return append(handlers, gin.WrapH(metrics.Handler())), nil
+}
@@ -4,22 +4,30 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
 	"strings"
 
+	"github.com/andreacioni/motionctrl/config"
 	"github.com/andreacioni/motionctrl/motion"
 	"github.com/andreacioni/motionctrl/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/kpango/glg"
 )
 
-// isLocalhost middlewares permit requests only from localhost
+// isLocalhost middlewares permit requests only from localhost: both the connection and, behind a trusted proxy,
+// the client it was forwarded for must be local
 func isLocalhost(c *gin.Context) {
 	ipStr, _, err := net.SplitHostPort(c.Request.RemoteAddr)
 
 	if err == nil {
 		fromIp := net.ParseIP(ipStr)
 
-		if b, err := utils.IsLocalIP(fromIp); err != nil || !b {
+		local, err := utils.IsLocalIP(fromIp)
+		if peer, _, perr := net.SplitHostPort(peerAddress(c)); err == nil && local && perr == nil && peer != ipStr {
+			local, err = utils.IsLocalIP(net.ParseIP(peer))
+		}
+
+		if err != nil || !local {
 			glg.Warnf("Rejecting request to %s, IP: %s is not authorized", c.Request.URL.Path, c.Request.RemoteAddr)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "call to /internal/* api is allowed only from localhost"})
 		} else {
@@ -33,9 +41,43 @@ func isLocalhost(c *gin.Context) {
 
 }
 
+// InternalTokenHeader carries the shared secret of /internal/* requests
+const InternalTokenHeader = "X-Motionctrl-Token"
+
+// internalMiddlewares authenticate /internal/* requests: the shared token is required when configured, source IP
+// is checked when no token is configured or if required by 'localOnly'. Unix socket connections have no IP to check
+func internalMiddlewares(conf config.Internal, socket bool) []gin.HandlerFunc {
+	var m []gin.HandlerFunc
+
+	if conf.Token != "" {
+		m = append(m, internalToken(conf.Token))
+	} else {
+		glg.Warn("No 'internal.token' defined, /internal/* api trusts any local IP")
+	}
+
+	if !socket && (conf.Token == "" || conf.LocalOnly) {
+		m = append(m, isLocalhost)
+	}
+
+	return m
+}
+
+// internalTokenRegex lists the characters allowed in 'internal.token': it is pasted in the shell commands run by motion
+var internalTokenRegex = regexp.MustCompile(`^[A-Za-z0-9._~+/=-]+$`)
+
+// internalToken middleware permits requests carrying the shared token, compared in constant time
+func internalToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !secureCompare(c.GetHeader(InternalTokenHeader), token) {
+			glg.Warnf("Rejecting request to %s from %s, invalid or missing token", c.Request.URL.Path, c.Request.RemoteAddr)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "call to /internal/* api needs a valid token"})
+		}
+	}
+}
+
 // needMotionUp Every request, except for /control* requests, need motion up and running
 func needMotionUp(c *gin.Context) {
-	if !strings.HasPrefix(fmt.Sprint(c.Request.URL), "/api/control") {
+	if !strings.HasPrefix(c.Request.URL.Path, basePath+"/api/control") {
 
 		if motionStarted, err := motion.IsStarted(); err == nil {
 			if !motionStarted {
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/privacy"
+)
+
+func privacyStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, privacy.GetStatus())
+}
+
+func privacyEnable(c *gin.Context) {
+	setPrivacy(c, true)
+}
+
+func privacyDisable(c *gin.Context) {
+	setPrivacy(c, false)
+}
+
+func setPrivacy(c *gin.Context, enabled bool) {
+	if err := privacy.Set(enabled, privacyUser(c)); err == nil {
+		c.JSON(http.StatusOK, privacy.GetStatus())
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	}
+}
+
+// privacyUser is who changed privacy mode, "api" if authentication is disabled
+func privacyUser(c *gin.Context) string {
+	if user := c.GetString(gin.AuthUserKey); user != "" {
+		return user
+	}
+	return "api"
+}
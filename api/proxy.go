@@ -0,0 +1,247 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+const (
+	schemeKey = "scheme"
+
+	// peerKey keeps the address of the connection when the remote address is replaced by the forwarded client
+	peerKey = "peer"
+
+	// HeaderXForwardedFor (default) and HeaderForwarded are the headers of trusted proxies, only one is read
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// basePath prefixes every route (e.g. /camera when published by a reverse proxy under /camera/), empty by default
+var basePath string
+
+// normalizeBasePath returns the base path with a leading slash and without the trailing one, "/" is the root
+func normalizeBasePath(path string) (string, error) {
+	path = strings.TrimSuffix(strings.TrimSpace(path), "/")
+
+	if path == "" {
+		return "", nil
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	if strings.ContainsAny(path, "?#:* ") || strings.Contains(path, "//") {
+		return "", fmt.Errorf("Invalid 'proxy.basePath': %s", path)
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("Invalid 'proxy.basePath': %s", path)
+		}
+	}
+
+	return path, nil
+}
+
+// parseTrustedProxies parses CIDRs of trusted reverse proxies, single IP addresses are accepted too
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy: %s", cidr)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", cidr, bits)
+		}
+
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy: %s", cidr)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded middleware replaces remote address, scheme and host of requests coming from a trusted proxy with the
+// ones of the client, read from 'proxy.header': X-Forwarded-* (default) or Forwarded. The other header and headers
+// of other clients are ignored: proxies pass them through unchanged
+func forwarded(conf config.Proxy) (gin.HandlerFunc, error) {
+	nets, err := parseTrustedProxies(conf.Trusted)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.CanonicalHeaderKey(conf.Header)
+	if header == "" {
+		header = HeaderXForwardedFor
+	}
+
+	if header != HeaderXForwardedFor && header != HeaderForwarded {
+		return nil, fmt.Errorf("Invalid 'proxy.header': %s (must be %s or %s)", conf.Header, HeaderXForwardedFor, HeaderForwarded)
+	}
+
+	return func(c *gin.Context) {
+		r := c.Request
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		c.Set(schemeKey, scheme)
+
+		c.Set(peerKey, r.RemoteAddr)
+
+		host, port, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !isTrusted(net.ParseIP(host), nets) {
+			return
+		}
+
+		var chain []string
+		var proto, forwardedHost string
+
+		//Values appended by the trusted proxy are the last ones, the first ones come from the client
+		if header == HeaderForwarded {
+			chain, proto, forwardedHost = parseForwarded(strings.Join(r.Header[HeaderForwarded], ","))
+		} else {
+			chain = splitList(r.Header[HeaderXForwardedFor])
+			proto, forwardedHost = lastOf(splitList(r.Header["X-Forwarded-Proto"])), lastOf(splitList(r.Header["X-Forwarded-Host"]))
+		}
+
+		if client := clientOf(chain, nets); client != nil {
+			r.RemoteAddr = net.JoinHostPort(client.String(), port)
+		}
+
+		if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+			c.Set(schemeKey, proto)
+		}
+
+		if forwardedHost != "" {
+			r.Host = forwardedHost
+		}
+	}, nil
+}
+
+// peerAddress returns the address of the connection, before forwarded middleware replaced it with the client one
+func peerAddress(c *gin.Context) string {
+	if peer := c.GetString(peerKey); peer != "" {
+		return peer
+	}
+
+	return c.Request.RemoteAddr
+}
+
+// splitList splits comma separated values of all the header lines
+func splitList(lines []string) []string {
+	var list []string
+
+	for _, line := range lines {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+
+	return list
+}
+
+func lastOf(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	return list[len(list)-1]
+}
+
+// clientOf returns the client in a chain of addresses (client, proxy1, proxy2...): the first one, from right,
+// that is not a trusted proxy. Addresses added by the client itself can't be trusted
+func clientOf(chain []string, nets []*net.IPNet) net.IP {
+	var client net.IP
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr := strings.Trim(chain[i], "\"")
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+
+		ip := net.ParseIP(strings.Trim(addr, "[]"))
+		if ip == nil {
+			break
+		}
+
+		client = ip
+		if !isTrusted(ip, nets) {
+			break
+		}
+	}
+
+	return client
+}
+
+// parseForwarded parses a RFC 7239 Forwarded header: addresses of 'for', proto and host of the last element, the
+// one added by the nearest proxy
+func parseForwarded(header string) (chain []string, proto, host string) {
+	for _, element := range strings.Split(header, ",") {
+		proto, host = "", ""
+
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value := strings.Trim(kv[1], "\"")
+
+			switch strings.ToLower(kv[0]) {
+			case "for":
+				chain = append(chain, value)
+			case "proto":
+				proto = value
+			case "host":
+				host = value
+			}
+		}
+	}
+
+	return chain, proto, host
+}
+
+// externalURL returns the URL of path (relative to base path) as seen by the client, behind a trusted proxy too
+func externalURL(c *gin.Context, path string) string {
+	scheme := c.GetString(schemeKey)
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s%s%s", scheme, c.Request.Host, basePath, path)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/retention"
+)
+
+func retentionStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, retention.GetStatus())
+}
+
+func retentionPreview(c *gin.Context) {
+	if report, err := retention.Preview(); err == nil {
+		c.JSON(http.StatusOK, report)
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	}
+}
+
+func retentionRun(c *gin.Context) {
+	if report, err := retention.RunNow(); err == nil {
+		c.JSON(http.StatusOK, report)
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	}
+}
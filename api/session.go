@@ -0,0 +1,135 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/session"
+	"github.com/andreacioni/motionctrl/twofactor"
+)
+
+type sessionInfo struct {
+	session.Session
+	Current bool `json:"current"`
+}
+
+// login starts a session for a client authenticated by username and password (JSON or form body, or basic auth)
+// or by a client certificate. The token is sent in a HttpOnly, SameSite cookie valid for /api only.
+// Users enrolled in two-factor authentication can give the code too, it is required if 'totp.require' is login
+func login(conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+
+		if strings.HasPrefix(c.ContentType(), "application/json") {
+			if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "invalid JSON body"})
+				return
+			}
+		} else {
+			body.Username, body.Password, body.Code = c.PostForm("username"), c.PostForm("password"), c.PostForm("code")
+		}
+
+		user, ok, verified := body.Username, false, false
+		if user != "" {
+			ok = validPassword(conf, body.Username, body.Password)
+		} else {
+			user, ok = credentials(conf, c.Request)
+			_, verified = clientCertUser(c.Request, conf.Ssl.ClientUsers)
+		}
+
+		if !ok {
+			glg.Warnf("Login failed for '%s' from %s", user, c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "invalid credentials"})
+			return
+		}
+
+		if !verified && twofactor.Enrolled(user) {
+			if body.Code != "" {
+				if err := twofactor.Verify(user, body.Code); err != nil {
+					glg.Warnf("Login failed for '%s' from %s: invalid second factor (%v)", user, c.ClientIP(), err)
+					c.JSON(http.StatusUnauthorized, gin.H{"message": err.Error(), "secondFactor": true})
+					return
+				}
+				verified = true
+			} else if twofactor.RequiredAtLogin() {
+				c.JSON(http.StatusUnauthorized, gin.H{"message": "second factor required", "secondFactor": true})
+				return
+			}
+		}
+
+		s, token, err := session.Create(user, c.ClientIP(), c.Request.UserAgent(), verified)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+
+		setSessionCookie(c, token, int(session.MaxAge().Seconds()))
+
+		c.JSON(http.StatusOK, sessionInfo{Session: s, Current: true})
+	}
+}
+
+func logout(c *gin.Context) {
+	if cookie, err := c.Request.Cookie(session.CookieName); err == nil {
+		session.Delete(cookie.Value)
+	}
+
+	setSessionCookie(c, "", -1)
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+func listSessions(c *gin.Context) {
+	current := c.GetString(sessionKey)
+
+	list := []sessionInfo{}
+	for _, s := range session.List() {
+		list = append(list, sessionInfo{Session: s, Current: s.ID == current})
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func revokeSession(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := session.Revoke(id); err == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "session " + id + " revoked"})
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	}
+}
+
+// sessionOf returns the session of the request cookie, if valid
+func sessionOf(c *gin.Context) (session.Session, bool) {
+	cookie, err := c.Request.Cookie(session.CookieName)
+	if err != nil {
+		return session.Session{}, false
+	}
+
+	return session.Validate(cookie.Value)
+}
+
+// setSessionCookie sets (or removes, maxAge < 0) the session cookie, it is Secure on HTTPS. SameSite is written by hand
+// since http.Cookie of older Go versions doesn't support it
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	cookie := &http.Cookie{
+		Name:     session.CookieName,
+		Value:    token,
+		Path:     basePath + "/api",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.GetString(schemeKey) == "https",
+	}
+
+	c.Writer.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Strict")
+}
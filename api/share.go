@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/share"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	ShareTargetStream   = "stream"
+	ShareTargetSnapshot = "snapshot"
+	ShareTargetFile     = "file"
+
+	shareKey = "share"
+)
+
+type shareInfo struct {
+	share.Link
+	URL string `json:"url"`
+}
+
+// createShare mints a link to the stream, to a snapshot or to a file of target_dir
+func createShare(c *gin.Context) {
+	var target string
+	query := url.Values{}
+
+	switch t := c.Query("target"); t {
+	case ShareTargetStream:
+		target = "/camera/stream"
+	case ShareTargetSnapshot:
+		target = "/camera/snapshot"
+		query.Set("save", "false")
+	case ShareTargetFile:
+		name := path.Clean("/" + c.Query("file"))
+		if _, err := motion.TargetDirGetFile(strings.TrimPrefix(name, "/")); err != nil {
+			c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to share %s: %v", c.Query("file"), err)})
+			return
+		}
+		target = "/targetdir/get" + name
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'target' parameter must be '%s', '%s' or '%s'", ShareTargetStream, ShareTargetSnapshot, ShareTargetFile)})
+		return
+	}
+
+	duration, err := utils.ParseDuration(c.DefaultQuery("duration", share.DefaultDuration))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "'duration' parameter must be a duration (e.g. 30m, 2h, 1d)"})
+		return
+	}
+
+	uses, err := strconv.Atoi(c.DefaultQuery("uses", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "'uses' parameter must be an integer"})
+		return
+	}
+
+	link, err := share.Create(target, query, duration, uses, c.Query("ip"), c.GetString(gin.AuthUserKey))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, newShareInfo(c, link))
+}
+
+func listShares(c *gin.Context) {
+	list := []shareInfo{}
+	for _, link := range share.List() {
+		list = append(list, newShareInfo(c, link))
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func revokeShare(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := share.Revoke(id); err == nil {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("share link %s revoked", id)})
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	}
+}
+
+// useShare accepts requests carrying a valid share link for the requested api
+func useShare(c *gin.Context) (share.Link, error) {
+	target := strings.TrimPrefix(c.Request.URL.Path, basePath+"/api")
+	partial := c.GetHeader("Range") != ""
+
+	link, err := share.Use(target, c.Request.URL.Query(), net.ParseIP(c.ClientIP()), partial)
+	if err != nil {
+		glg.Warnf("Rejecting share link request to %s from %s: %v", c.Request.URL.Path, c.ClientIP(), err)
+	}
+
+	return link, err
+}
+
+func newShareInfo(c *gin.Context, link share.Link) shareInfo {
+	u := url.URL{Path: "/api" + link.Target, RawQuery: share.Query(link)}
+
+	return shareInfo{Link: link, URL: externalURL(c, u.RequestURI())}
+}
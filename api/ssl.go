@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/certificate"
+)
+
+// sslFingerprint returns fingerprints of the certificate in use to let clients pin it,
+// on plain HTTP listeners the certificate of the first HTTPS listener is returned
+func sslFingerprint(c *gin.Context) {
+	cert := defaultCertificate
+	if v, ok := c.Get(certificateKey); ok {
+		cert = v.(*certificate.Reloader)
+	}
+
+	if cert == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "SSL/TLS is not enabled"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cert.Info())
+}
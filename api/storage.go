@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/storage"
+)
+
+// storageStatus returns the result of the last check of the monitor, checks send alerts and take actions so they are never run here
+func storageStatus(c *gin.Context) {
+	if status := storage.GetStatus(); status.Active {
+		c.JSON(http.StatusOK, status)
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "storage monitor is not running"})
+	}
+}
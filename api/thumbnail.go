@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/thumbnail"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+func thumbTargetDir(c *gin.Context) {
+	fileName := strings.TrimPrefix(c.Param("filename"), "/")
+
+	size := thumbnail.DefaultSize
+	if s := c.Query("size"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size < utils.MinImageWidth || size > thumbnail.MaxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'size' parameter must be between %d and %d", utils.MinImageWidth, thumbnail.MaxSize)})
+			return
+		}
+	}
+
+	filePath, err := motion.TargetDirGetFile(fileName)
+	if err != nil {
+		c.JSON(targetDirErrorStatus(err), gin.H{"message": fmt.Sprintf("Unable to get: %s in target dir: %v", fileName, err)})
+		return
+	}
+
+	thumb, err := thumbnail.Get(filePath, size)
+	switch {
+	case err == thumbnail.ErrUnsupported:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": fmt.Sprintf("Unable to build thumbnail of %s: %v", fileName, err)})
+	case os.IsNotExist(err):
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("%s not found in target dir", fileName)})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Unable to build thumbnail of %s: %v", fileName, err)})
+	default:
+		//URL doesn't change with the source: the client revalidates, the file name of the thumbnail is its version
+		c.Header("Content-Type", "image/jpeg")
+		c.Header("Cache-Control", "private, no-cache")
+		c.Header("ETag", fmt.Sprintf("\"%s\"", filepath.Base(thumb)))
+		c.File(thumb)
+	}
+}
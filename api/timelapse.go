@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andreacioni/motionctrl/timelapse"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+func createTimelapse(c *gin.Context) {
+	var req timelapse.Request
+	var err error
+
+	if from := c.Query("from"); from != "" {
+		if req.From, err = utils.ParseTime(from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid 'from' parameter: %v", err)})
+			return
+		}
+	}
+
+	if to := c.Query("to"); to != "" {
+		if req.To, err = utils.ParseTime(to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("invalid 'to' parameter: %v", err)})
+			return
+		}
+	}
+
+	for param, value := range map[string]*int{"every": &req.Every, "maxFrames": &req.MaxFrames, "fps": &req.FPS, "width": &req.Width} {
+		if s := c.Query(param); s != "" {
+			if *value, err = strconv.Atoi(s); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("'%s' parameter must be an integer", param)})
+				return
+			}
+		}
+	}
+
+	req.Glob = c.Query("glob")
+	req.Format = c.Query("format")
+
+	if job, err := timelapse.Create(req); err == nil {
+		c.JSON(http.StatusOK, job)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	}
+}
+
+func listTimelapse(c *gin.Context) {
+	c.JSON(http.StatusOK, timelapse.List())
+}
+
+func timelapseStatus(c *gin.Context) {
+	if job, err := timelapse.Get(c.Param("id")); err == nil {
+		c.JSON(http.StatusOK, job)
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	}
+}
+
+func retrieveTimelapse(c *gin.Context) {
+	if filePath, err := timelapse.OutputFile(c.Param("id")); err == nil {
+		c.Header("Content-Type", utils.MimeType(filePath))
+		c.File(filePath)
+	} else {
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	}
+}
+
+func removeTimelapse(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := timelapse.Remove(id); err == nil {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("time-lapse %s successfully removed", id)})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+	}
+}
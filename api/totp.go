@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/session"
+	"github.com/andreacioni/motionctrl/twofactor"
+)
+
+const secondFactorKey = "secondFactor"
+
+// secondFactorExempt routes are needed to give the second factor (or give up) when every route is protected
+var secondFactorExempt = map[string]bool{
+	"/totp/status": true,
+	"/totp/verify": true,
+	"/auth/logout": true,
+}
+
+type totpStatusInfo struct {
+	twofactor.Status
+	Verified bool `json:"verified"`
+}
+
+// secondFactor rejects requests of users enrolled in two-factor authentication to protected routes, unless their
+// session has been verified with a code. Client certificates and share links are already a second factor
+func secondFactor(conf config.Configuration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := strings.TrimPrefix(c.Request.URL.Path, basePath+"/api")
+
+		if c.GetBool(secondFactorKey) || c.GetString(shareKey) != "" || secondFactorExempt[route] ||
+			!twofactor.Protected(route) || !twofactor.Enrolled(c.GetString(gin.AuthUserKey)) {
+			return
+		}
+
+		if _, ok := clientCertUser(c.Request, conf.Ssl.ClientUsers); ok {
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "second factor required", "secondFactor": true})
+	}
+}
+
+func totpStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, totpStatusInfo{Status: twofactor.GetStatus(c.GetString(gin.AuthUserKey)), Verified: c.GetBool(secondFactorKey)})
+}
+
+// totpEnroll returns a new secret for the user, as provisioning URI and QR code too. It is enabled by totpConfirm
+func totpEnroll(c *gin.Context) {
+	user, ok := totpUser(c)
+	if !ok {
+		return
+	}
+
+	enrolment, err := twofactor.Enroll(user)
+	if err != nil {
+		c.JSON(secondFactorErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": enrolment.Secret,
+		"url":    enrolment.URL,
+		"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrolment.QR),
+	})
+}
+
+// totpConfirm enables two-factor authentication with the first code of the authenticator and returns the recovery codes
+func totpConfirm(c *gin.Context) {
+	user, ok := totpUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := twofactor.Confirm(user, c.Query("code"))
+	if err != nil {
+		c.JSON(secondFactorErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	//The code has just been given, the session is verified
+	if id := c.GetString(sessionKey); id != "" {
+		session.SetSecondFactor(id)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication enabled", "recoveryCodes": codes})
+}
+
+// totpVerify marks the session as verified by a second factor
+func totpVerify(c *gin.Context) {
+	user, ok := totpUser(c)
+	if !ok {
+		return
+	}
+
+	id := c.GetString(sessionKey)
+	if id == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "only sessions can be verified, log in with the code instead"})
+		return
+	}
+
+	if err := twofactor.Verify(user, c.Query("code")); err != nil {
+		glg.Warnf("Invalid second factor of %s from %s: %v", user, c.ClientIP(), err)
+		c.JSON(secondFactorErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := session.SetSecondFactor(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session verified"})
+}
+
+func totpDisable(c *gin.Context) {
+	user, ok := totpUser(c)
+	if !ok {
+		return
+	}
+
+	if err := twofactor.Disable(user, c.Query("code")); err != nil {
+		c.JSON(secondFactorErrorStatus(err), gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// totpUser returns the authenticated user, enrolment isn't available when authentication is disabled
+func totpUser(c *gin.Context) (string, bool) {
+	user := c.GetString(gin.AuthUserKey)
+	if user == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "two-factor authentication needs authentication to be enabled"})
+	}
+
+	return user, user != ""
+}
+
+func secondFactorErrorStatus(err error) int {
+	switch err {
+	case twofactor.ErrDisabled:
+		return http.StatusServiceUnavailable
+	case twofactor.ErrEnrolled:
+		return http.StatusConflict
+	case twofactor.ErrNotEnrolled:
+		return http.StatusBadRequest
+	case twofactor.ErrInvalidCode:
+		return http.StatusForbidden
+	case twofactor.ErrLocked:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,162 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+
+	// ManifestName is the last entry of every archive, it can be checked with: sha256sum -c MANIFEST.sha256
+	ManifestName = "MANIFEST.sha256"
+)
+
+// File is a file to put in archive: Name is the path inside the archive, Path the one on disk
+type File struct {
+	Name string
+	Path string
+}
+
+// entryWriter adds a single entry to an archive, write receives the entry content
+type entryWriter interface {
+	add(name string, size int64, modTime time.Time, write func(io.Writer) error) error
+	Close() error
+}
+
+// ContentType returns the MIME type of format
+func ContentType(format string) string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// ValidFormat tells if format is supported
+func ValidFormat(format string) bool {
+	return format == FormatZip || format == FormatTarGz
+}
+
+// Write streams files to w as a zip or tar.gz archive followed by the checksum manifest. Nothing is buffered
+// in memory or on disk, files are read only once: checksums are evaluated while copying
+func Write(w io.Writer, format string, files []File) error {
+	var archive entryWriter
+
+	switch format {
+	case FormatZip:
+		archive = &zipWriter{zip.NewWriter(w)}
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		archive = &tarWriter{gz: gz, tw: tar.NewWriter(gz)}
+	default:
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+
+	var manifest []byte
+
+	for _, f := range files {
+		sum, err := addFile(archive, f)
+		if err != nil {
+			return fmt.Errorf("unable to archive %s: %v", f.Name, err)
+		}
+
+		manifest = append(manifest, fmt.Sprintf("%s  %s\n", sum, f.Name)...)
+	}
+
+	err := archive.add(ManifestName, int64(len(manifest)), time.Now(), func(w io.Writer) error {
+		_, err := w.Write(manifest)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+func addFile(archive entryWriter, f File) (string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file")
+	}
+
+	hash := sha256.New()
+
+	err = archive.add(f.Name, info.Size(), info.ModTime(), func(w io.Writer) error {
+		//Size is fixed in tar header, a file still growing is truncated to it
+		n, err := io.CopyN(io.MultiWriter(w, hash), file, info.Size())
+		if err == io.EOF {
+			err = fmt.Errorf("file shrunk while archiving (%d/%d bytes)", n, info.Size())
+		}
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) add(name string, size int64, modTime time.Time, write func(io.Writer) error) error {
+	//Pictures and movies are already compressed
+	header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: modTime}
+	header.SetMode(0644)
+
+	w, err := z.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	return write(w)
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+type tarWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarWriter) add(name string, size int64, modTime time.Time, write func(io.Writer) error) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	return write(t.tw)
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+
+	return t.gz.Close()
+}
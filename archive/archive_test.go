@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testFiles(t *testing.T, dir string) ([]File, map[string][]byte) {
+	contents := map[string][]byte{
+		"01.jpg":         bytes.Repeat([]byte{0xFF}, 1000),
+		"2018/03/02.mkv": []byte("movie"),
+	}
+
+	var files []File
+	for _, name := range []string{"01.jpg", "2018/03/02.mkv"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, contents[name], 0666))
+		files = append(files, File{Name: name, Path: path})
+	}
+
+	return files, contents
+}
+
+func expectedManifest(contents map[string][]byte) string {
+	manifest := ""
+	for _, name := range []string{"01.jpg", "2018/03/02.mkv"} {
+		sum := sha256.Sum256(contents[name])
+		manifest += fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return manifest
+}
+
+func TestZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files, contents := testFiles(t, dir)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatZip, files))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 3)
+
+	read := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		read[f.Name] = string(data)
+	}
+
+	require.Equal(t, string(contents["01.jpg"]), read["01.jpg"])
+	require.Equal(t, "movie", read["2018/03/02.mkv"])
+	require.Equal(t, ManifestName, zr.File[2].Name)
+	require.Equal(t, expectedManifest(contents), read[ManifestName])
+}
+
+func TestTarGz(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files, contents := testFiles(t, dir)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, FormatTarGz, files))
+
+	gz, err := gzip.NewReader(&buf)
+	require.NoError(t, err)
+
+	tr := tar.NewReader(gz)
+
+	var names []string
+	var manifest []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+
+		names = append(names, header.Name)
+		if header.Name == ManifestName {
+			manifest = data
+		} else {
+			require.Equal(t, contents[header.Name], data)
+		}
+	}
+
+	require.Equal(t, []string{"01.jpg", "2018/03/02.mkv", ManifestName}, names)
+	require.Equal(t, expectedManifest(contents), string(manifest))
+}
+
+func TestWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	require.Error(t, Write(&buf, "rar", nil))
+	require.Error(t, Write(&buf, FormatZip, []File{{Name: "missing.jpg", Path: "/this/file/does/not/exist"}}))
+	require.Error(t, Write(&buf, FormatTarGz, []File{{Name: "dir", Path: os.TempDir()}}))
+
+	require.True(t, ValidFormat(FormatTarGz))
+	require.False(t, ValidFormat("tar"))
+}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/andreacioni/aescrypt"
 	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/metrics"
 	"github.com/andreacioni/motionctrl/utils"
 	"github.com/andreacioni/motionctrl/version"
 
@@ -144,6 +146,11 @@ func setStatus(s State) {
 	sMutex.Lock()
 	defer sMutex.Unlock()
 	glg.Debugf("Setting backup state from: %s to: %s", backupStatus, s)
+
+	if backupStatus != s {
+		events.Publish(events.TypeBackupStatus, 0, "", map[string]interface{}{"from": backupStatus, "to": s})
+	}
+
 	backupStatus = s
 }
 
@@ -310,6 +317,33 @@ func checkSize() {
 	}
 }
 
+func fileSize(path string) int64 {
+	if info, err := os.Stat(path); err == nil {
+		return info.Size()
+	}
+	return 0
+}
+
+// publishOutcome publishes the backup result of every file, remote is the name of the uploaded file (or archive)
+func publishOutcome(files []string, remote string, err error) {
+	for _, f := range files {
+		data := map[string]interface{}{"method": backupConfig.Method, "success": err == nil}
+
+		if err != nil {
+			data["error"] = err.Error()
+		} else {
+			data["remote"] = remote
+		}
+
+		name := f
+		if rel, relErr := filepath.Rel(targetDirectory, f); relErr == nil {
+			name = filepath.ToSlash(rel)
+		}
+
+		events.Publish(events.TypeBackupFile, 0, name, data)
+	}
+}
+
 func backupWorker() {
 	if workerMutex.TryLock() {
 		defer workerMutex.Unlock()
@@ -321,6 +355,8 @@ func backupWorker() {
 
 		_, fileList, _, err := utils.ListFiles(targetDirectory, backuppableFile)
 
+		defer func() { metrics.BackupRun(err) }()
+
 		glg.Debugf("Backup file list: %+v", fileList)
 
 		if err != nil {
@@ -334,13 +370,18 @@ func backupWorker() {
 					archive, err = archiveFiles(subFileList)
 
 					if err != nil {
+						publishOutcome(subFileList, "", err)
 						return false
 					}
 
 					if archive, err = encryptAndUpload(archive, backupConfig.EncryptionKey); err != nil {
+						publishOutcome(subFileList, "", err)
 						return false
 					}
 
+					publishOutcome(subFileList, filepath.Base(archive), nil)
+					metrics.BackupUploaded(len(subFileList), fileSize(archive))
+
 					if err = removeFiles(append([]string{archive}, subFileList...)); err != nil {
 						return false
 					}
@@ -355,12 +396,19 @@ func backupWorker() {
 			} else { //Encrypt file (if needed) and upload. No archive
 				for _, f := range fileList {
 
-					if f, err = encryptAndUpload(f, backupConfig.EncryptionKey); err != nil {
+					var uploaded string
+					uploaded, err = encryptAndUpload(f, backupConfig.EncryptionKey)
+
+					publishOutcome([]string{f}, filepath.Base(uploaded), err)
+
+					if err != nil {
 						glg.Error(err)
 						return
 					}
 
-					if err = os.Remove(f); err != nil {
+					metrics.BackupUploaded(1, fileSize(uploaded))
+
+					if err = os.Remove(uploaded); err != nil {
 						glg.Error(err)
 						return
 					}
@@ -0,0 +1,359 @@
+package certificate
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/version"
+)
+
+const (
+	// Validity of generated self-signed certificates
+	Validity = 10 * 365 * 24 * time.Hour
+)
+
+// Info describes the certificate in use, fingerprints let clients pin it
+type Info struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DNSNames    []string  `json:"dnsNames"`
+	IPAddresses []string  `json:"ipAddresses"`
+	SelfSigned  bool      `json:"selfSigned"`
+	// SHA256 is the fingerprint of the whole certificate (AA:BB:...)
+	SHA256 string `json:"sha256"`
+	// SPKI is the base64 SHA-256 of the public key, as used by HPKP and mobile pinning libraries
+	SPKI     string    `json:"spki"`
+	LoadedAt time.Time `json:"loadedAt"`
+}
+
+// Reloader serves a certificate, reloading it when files change on disk
+type Reloader struct {
+	mutex       sync.Mutex
+	certFile    string
+	keyFile     string
+	current     *tls.Certificate
+	clientCAs   *x509.CertPool
+	clientAuth  tls.ClientAuthType
+	info        Info
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// Load loads a certificate, it is generated first when files are missing and generation is enabled
+func Load(conf config.SSL) (*Reloader, error) {
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return nil, fmt.Errorf("Both 'ssl.cert' and 'ssl.key' are required")
+	}
+
+	r := &Reloader{certFile: conf.CertFile, keyFile: conf.KeyFile, clientAuth: tls.NoClientCert}
+
+	if conf.ClientCA != "" {
+		pool, err := loadCertPool(conf.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'ssl.clientCA' (%s): %v", conf.ClientCA, err)
+		}
+
+		r.clientCAs, r.clientAuth = pool, tls.VerifyClientCertIfGiven
+		if conf.RequireClientCert {
+			r.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if conf.RequireClientCert {
+		return nil, fmt.Errorf("'ssl.requireClientCert' needs 'ssl.clientCA' to verify clients")
+	}
+
+	if !exists(r.certFile) && !exists(r.keyFile) {
+		if !conf.Generate {
+			return nil, fmt.Errorf("Certificate %s and key %s not found (set 'ssl.generate' to create a self-signed one)", r.certFile, r.keyFile)
+		}
+
+		if err := Generate(r.certFile, r.keyFile, conf.Hosts); err != nil {
+			return nil, fmt.Errorf("Unable to generate self-signed certificate: %v", err)
+		}
+	}
+
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// TLSConfig returns a configuration that reloads certificate and key when they change on disk.
+// Client certificates are verified against the configured CA bundle, if any
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		ClientCAs:      r.clientCAs,
+		ClientAuth:     r.clientAuth,
+	}
+}
+
+// GetCertificate returns the current certificate, reloading files if they have been modified.
+// When the new files can't be loaded (e.g. key not yet replaced) the previous certificate is kept
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.changed() {
+		if err := r.load(); err != nil {
+			glg.Warnf("Unable to reload certificate, previous one is still in use: %v", err)
+		}
+	}
+
+	return r.current, nil
+}
+
+// Info returns details and fingerprints of the certificate in use
+func (r *Reloader) Info() Info {
+	r.GetCertificate(nil)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.info
+}
+
+// Generate writes a new self-signed certificate valid for hosts (DNS names or IP addresses) and its key,
+// when hosts is empty the certificate is valid for hostname, localhost and loopback addresses
+func Generate(certPath, keyPath string, hosts []string) error {
+	if len(hosts) == 0 {
+		hosts = defaultHosts()
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{version.Name}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writePEM(keyPath, "EC PRIVATE KEY", keyDer, 0600); err != nil {
+		return err
+	}
+
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return err
+	}
+
+	glg.Infof("Self-signed certificate generated in %s (key: %s) for: %s", certPath, keyPath, strings.Join(hosts, ", "))
+
+	return nil
+}
+
+// Hosts returns DNS names and IP addresses the certificate of conf is valid for. When the certificate
+// will be generated at startup they are 'hosts' or the defaults of Generate
+func Hosts(conf config.SSL) ([]string, error) {
+	raw, err := ioutil.ReadFile(conf.CertFile)
+	if os.IsNotExist(err) && conf.Generate {
+		if len(conf.Hosts) > 0 {
+			return conf.Hosts, nil
+		}
+		return defaultHosts(), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for block, rest := pem.Decode(raw); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts := append([]string{}, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+
+		return hosts, nil
+	}
+
+	return nil, fmt.Errorf("no PEM certificate found in %s", conf.CertFile)
+}
+
+func defaultHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append([]string{hostname}, hosts...)
+	}
+	return hosts
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER certificate as colon separated hex
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// load and changed require r.mutex to be held, unless r is not shared yet
+func (r *Reloader) load() error {
+	certStat, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyStat, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	//Modification times are recorded even if loading fails, so broken files aren't loaded on every handshake
+	r.certModTime, r.keyModTime = certStat.ModTime(), keyStat.ModTime()
+
+	pair, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return err
+	}
+
+	pair.Leaf = leaf
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+
+	r.info = Info{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		DNSNames:    leaf.DNSNames,
+		SelfSigned:  bytes.Equal(leaf.RawIssuer, leaf.RawSubject) && leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature) == nil,
+		SHA256:      Fingerprint(leaf.Raw),
+		SPKI:        base64.StdEncoding.EncodeToString(spki[:]),
+		LoadedAt:    time.Now(),
+		IPAddresses: []string{},
+	}
+
+	for _, ip := range leaf.IPAddresses {
+		r.info.IPAddresses = append(r.info.IPAddresses, ip.String())
+	}
+
+	r.current = &pair
+
+	glg.Infof("Certificate loaded from %s (SHA-256: %s, expires: %s)", r.certFile, r.info.SHA256, leaf.NotAfter)
+
+	if time.Now().After(leaf.NotAfter) {
+		glg.Warnf("Certificate %s is expired", r.certFile)
+	}
+
+	return nil
+}
+
+func (r *Reloader) changed() bool {
+	certStat, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+
+	keyStat, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+
+	return !certStat.ModTime().Equal(r.certModTime) || !keyStat.ModTime().Equal(r.keyModTime)
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+
+	return pool, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
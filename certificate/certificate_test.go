@@ -0,0 +1,214 @@
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.SSL{CertFile: filepath.Join(dir, "ssl", "cert.pem"), KeyFile: filepath.Join(dir, "ssl", "key.pem")}
+
+	//Missing files are generated only when enabled
+	_, err = Load(conf)
+	require.Error(t, err)
+
+	conf.Generate = true
+	conf.Hosts = []string{"camera.local", "192.168.1.10"}
+	r, err := Load(conf)
+	require.NoError(t, err)
+
+	keyInfo, err := os.Stat(conf.KeyFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), keyInfo.Mode().Perm())
+
+	raw, err := ioutil.ReadFile(conf.CertFile)
+	require.NoError(t, err)
+	block, _ := pem.Decode(raw)
+	require.NotNil(t, block)
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, cert.VerifyHostname("camera.local"))
+	require.NoError(t, cert.VerifyHostname("192.168.1.10"))
+	require.Error(t, cert.VerifyHostname("example.com"))
+
+	info := r.Info()
+	require.True(t, info.SelfSigned)
+	require.Equal(t, Fingerprint(block.Bytes), info.SHA256)
+	require.Len(t, info.SHA256, 32*3-1)
+	require.NotEmpty(t, info.SPKI)
+	require.Equal(t, []string{"192.168.1.10"}, info.IPAddresses)
+
+	//Existing files are never overwritten
+	r, err = Load(conf)
+	require.NoError(t, err)
+	same := r.Info()
+	require.Equal(t, info.SHA256, same.SHA256)
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := config.SSL{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), Generate: true}
+	r, err := Load(conf)
+	require.NoError(t, err)
+
+	first, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+
+	//Rotated files are used by next handshake
+	require.NoError(t, Generate(conf.CertFile, conf.KeyFile, []string{"rotated.local"}))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(conf.CertFile, future, future))
+	require.NoError(t, os.Chtimes(conf.KeyFile, future, future))
+
+	second, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, first.Leaf.Raw, second.Leaf.Raw)
+	require.Equal(t, []string{"rotated.local"}, second.Leaf.DNSNames)
+
+	//Broken files don't replace a working certificate
+	require.NoError(t, ioutil.WriteFile(conf.KeyFile, []byte("broken"), 0600))
+	future = future.Add(time.Minute)
+	require.NoError(t, os.Chtimes(conf.KeyFile, future, future))
+
+	third, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, second, third)
+}
+
+// newClientCert returns a CA in PEM format and a client certificate signed by it
+func newClientCert(t *testing.T, commonName string) ([]byte, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Home CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ca, err := x509.ParseCertificate(caDer)
+	require.NoError(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	clientDer, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+		tls.Certificate{Certificate: [][]byte{clientDer}, PrivateKey: clientKey}
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caPEM, clientCert := newClientCert(t, "phone")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), caPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "bad.pem"), []byte("nothing here"), 0644))
+
+	conf := config.SSL{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), Generate: true}
+
+	conf.RequireClientCert = true
+	_, err = Load(conf)
+	require.Error(t, err)
+
+	conf.ClientCA = filepath.Join(dir, "bad.pem")
+	_, err = Load(conf)
+	require.Error(t, err)
+
+	var r *Reloader
+
+	get := func(certs ...tls.Certificate) (string, error) {
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(r.TLS.VerifiedChains) > 0 {
+				w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+			}
+		}))
+		server.TLS = r.TLSConfig()
+		server.StartTLS()
+		defer server.Close()
+
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	//Optional client certificate
+	conf.ClientCA, conf.RequireClientCert = filepath.Join(dir, "ca.pem"), false
+	r, err = Load(conf)
+	require.NoError(t, err)
+
+	user, err := get(clientCert)
+	require.NoError(t, err)
+	require.Equal(t, "phone", user)
+
+	user, err = get()
+	require.NoError(t, err)
+	require.Equal(t, "", user)
+
+	//Connections without a valid client certificate are rejected at TLS layer
+	conf.RequireClientCert = true
+	r, err = Load(conf)
+	require.NoError(t, err)
+
+	_, otherCert := newClientCert(t, "intruder")
+
+	_, err = get()
+	require.Error(t, err)
+
+	_, err = get(otherCert)
+	require.Error(t, err)
+
+	user, err = get(clientCert)
+	require.NoError(t, err)
+	require.Equal(t, "phone", user)
+}
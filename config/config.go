@@ -15,20 +15,46 @@ const (
 )
 
 type Configuration struct {
-	Address          string `json:"address"`
-	Port             int    `json:"port"`
-	MotionConfigFile string `json:"motionConfigFile"`
-	Username         string `json:"username"`
-	Password         string `json:"password"`
-	AppPath          string `json:"appPath"`
-	Ssl              SSL    `json:"ssl"`
-	Backup           Backup `json:"backup"`
-	Notify           Notify `json:"notify"`
+	Address          string     `json:"address"`
+	Port             int        `json:"port"`
+	MotionConfigFile string     `json:"motionConfigFile"`
+	Username         string     `json:"username"`
+	Password         string     `json:"password"`
+	AppPath          string     `json:"appPath"`
+	Ssl              SSL        `json:"ssl"`
+	Listeners        []Listener `json:"listeners"`
+	Backup           Backup     `json:"backup"`
+	Notify           Notify     `json:"notify"`
+	Timelapse        Timelapse  `json:"timelapse"`
+	Thumbnail        Thumbnail  `json:"thumbnail"`
+	Retention        Retention  `json:"retention"`
+	History          History    `json:"history"`
+	Storage          Storage    `json:"storage"`
+	Metrics          Metrics    `json:"metrics"`
+	Internal         Internal   `json:"internal"`
+	Proxy            Proxy      `json:"proxy"`
+	Session          Session    `json:"session"`
+	Share            Share      `json:"share"`
+	TOTP             TOTP       `json:"totp"`
+	Privacy          Privacy    `json:"privacy"`
+}
+
+type Listener struct {
+	Address  string   `json:"address"`
+	Ssl      SSL      `json:"ssl"`
+	Auth     string   `json:"auth"`
+	Groups   []string `json:"groups"`
+	Redirect string   `json:"redirect"`
 }
 
 type SSL struct {
-	CertFile string `json:"cert"`
-	KeyFile  string `json:"key"`
+	CertFile          string            `json:"cert"`
+	KeyFile           string            `json:"key"`
+	Generate          bool              `json:"generate"`
+	Hosts             []string          `json:"hosts"`
+	ClientCA          string            `json:"clientCA"`
+	RequireClientCert bool              `json:"requireClientCert"`
+	ClientUsers       map[string]string `json:"clientUsers"`
 }
 
 type Backup struct {
@@ -47,6 +73,81 @@ type Notify struct {
 	Photo   int      `json:"photo"`
 }
 
+type Timelapse struct {
+	Folder string `json:"folder"`
+}
+
+type Thumbnail struct {
+	MaxCacheSize string `json:"maxCacheSize"`
+}
+
+type History struct {
+	File   string `json:"file"`
+	MaxAge string `json:"maxAge"`
+}
+
+type Internal struct {
+	Token     string `json:"token"`
+	LocalOnly bool   `json:"localOnly"`
+	Socket    string `json:"socket"`
+}
+
+type Share struct {
+	File        string `json:"file"`
+	Secret      string `json:"secret"`
+	MaxDuration string `json:"maxDuration"`
+}
+
+type Privacy struct {
+	File           string `json:"file"`
+	On             string `json:"on"`
+	Off            string `json:"off"`
+	PauseDetection bool   `json:"pauseDetection"`
+	Placeholder    string `json:"placeholder"`
+}
+
+type TOTP struct {
+	File    string   `json:"file"`
+	Issuer  string   `json:"issuer"`
+	Require string   `json:"require"`
+	Routes  []string `json:"routes"`
+}
+
+type Session struct {
+	IdleTimeout string `json:"idleTimeout"`
+	MaxAge      string `json:"maxAge"`
+}
+
+type Proxy struct {
+	BasePath string   `json:"basePath"`
+	Trusted  []string `json:"trusted"`
+	Header   string   `json:"header"`
+}
+
+type Metrics struct {
+	Enabled  bool   `json:"enabled"`
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type Storage struct {
+	Interval string `json:"interval"`
+	Warning  int    `json:"warning"`
+	Critical int    `json:"critical"`
+	Action   string `json:"action"`
+}
+
+type Retention struct {
+	When            string   `json:"when"`
+	MaxAge          string   `json:"maxAge"`
+	MaxSize         string   `json:"maxSize"`
+	MaxPicturesSize string   `json:"maxPicturesSize"`
+	MaxMoviesSize   string   `json:"maxMoviesSize"`
+	MinFreeSpace    string   `json:"minFreeSpace"`
+	Protect         []string `json:"protect"`
+}
+
 var (
 	mu   sync.Mutex
 	conf Configuration
@@ -116,6 +217,90 @@ func GetNotifyConfig() Notify {
 	return conf.Notify
 }
 
+func GetTimelapseConfig() Timelapse {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Timelapse
+}
+
+func GetThumbnailConfig() Thumbnail {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Thumbnail
+}
+
+func GetHistoryConfig() History {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.History
+}
+
+func GetInternalConfig() Internal {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Internal
+}
+
+func GetShareConfig() Share {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Share
+}
+
+func GetPrivacyConfig() Privacy {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Privacy
+}
+
+func GetTOTPConfig() TOTP {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.TOTP
+}
+
+func GetSessionConfig() Session {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Session
+}
+
+func GetProxyConfig() Proxy {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Proxy
+}
+
+func GetMetricsConfig() Metrics {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Metrics
+}
+
+func GetStorageConfig() Storage {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Storage
+}
+
+func GetRetentionConfig() Retention {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return conf.Retention
+}
+
 func (c Configuration) IsEmpty() bool {
 	return reflect.DeepEqual(c, Configuration{})
 }
@@ -131,3 +316,7 @@ func (c Notify) IsEmpty() bool {
 func (c Backup) IsEmpty() bool {
 	return reflect.DeepEqual(c, Backup{})
 }
+
+func (c Retention) IsEmpty() bool {
+	return reflect.DeepEqual(c, Retention{})
+}
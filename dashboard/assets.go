@@ -0,0 +1,506 @@
+package dashboard
+
+const indexHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>motionctrl</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<header>
+	<h1>motionctrl</h1>
+	<nav>
+		<a href="#live">Live</a>
+		<a href="#gallery">Gallery</a>
+		<a href="#status">Status</a>
+		<a href="#config">Config</a>
+		<a href="#sessions">Sessions</a>
+		<a href="#" id="logout">Logout</a>
+	</nav>
+</header>
+<main>
+	<p id="message" hidden></p>
+
+	<section id="login" hidden>
+		<form id="login-form">
+			<input id="login-username" autocomplete="username" placeholder="Username" required>
+			<input id="login-password" type="password" autocomplete="current-password" placeholder="Password" required>
+			<input id="login-code" autocomplete="one-time-code" placeholder="Two-factor or recovery code" hidden>
+			<button type="submit">Login</button>
+		</form>
+	</section>
+
+	<section id="live" data-page>
+		<div class="toolbar">
+			<span>motion: <b id="motion-state">-</b></span>
+			<button id="motion-start">Start</button>
+			<button id="motion-stop">Stop</button>
+			<span>detection: <b id="detection-state">-</b></span>
+			<button id="detection-toggle">Toggle</button>
+			<span>privacy: <b id="privacy-state">-</b></span>
+			<button id="privacy-toggle">Toggle</button>
+			<button id="snapshot">Snapshot</button>
+		</div>
+		<img id="stream" alt="Live stream">
+	</section>
+
+	<section id="gallery" data-page hidden>
+		<div class="toolbar">
+			<select id="gallery-type">
+				<option value="">All files</option>
+				<option value="picture">Pictures</option>
+				<option value="movie">Movies</option>
+			</select>
+			<button id="gallery-refresh">Refresh</button>
+			<span id="gallery-total"></span>
+		</div>
+		<div id="gallery-files" class="grid"></div>
+		<button id="gallery-more" hidden>Load more</button>
+	</section>
+
+	<section id="status" data-page hidden>
+		<h2>Backup</h2>
+		<div class="toolbar">
+			<span>status: <b id="backup-state">-</b></span>
+			<button id="backup-launch">Run now</button>
+		</div>
+		<h2>Notifications</h2>
+		<div class="toolbar">
+			<span>ready: <b id="notify-ready">-</b></span>
+			<span>active: <b id="notify-active">-</b></span>
+			<button id="notify-toggle">Toggle</button>
+		</div>
+		<h2>Storage</h2>
+		<table id="storage"></table>
+	</section>
+
+	<section id="config" data-page hidden>
+		<div class="toolbar">
+			<input id="config-filter" placeholder="Filter">
+			<button id="config-write">Write to file</button>
+		</div>
+		<table id="config-table"></table>
+	</section>
+
+	<section id="sessions" data-page hidden>
+		<table id="sessions-table"></table>
+
+		<h2>Two-factor authentication</h2>
+		<div class="toolbar">
+			<span>status: <b id="totp-state">-</b></span>
+			<button id="totp-enroll" hidden>Enable</button>
+			<button id="totp-disable" hidden>Disable</button>
+		</div>
+		<div id="totp-setup" hidden>
+			<img id="totp-qr" alt="QR code">
+			<p>Scan the QR code or enter <code id="totp-secret"></code> in the authenticator app, then confirm with its code.</p>
+			<div class="toolbar">
+				<input id="totp-code" autocomplete="one-time-code" placeholder="Code">
+				<button id="totp-confirm">Confirm</button>
+			</div>
+		</div>
+		<div id="totp-recovery" hidden>
+			<p>Recovery codes, each can be used once in place of a code. They won't be shown again:</p>
+			<pre id="totp-recovery-codes"></pre>
+		</div>
+	</section>
+</main>
+<script src="app.js"></script>
+</body>
+</html>
+`
+
+const styleCSS = `* { box-sizing: border-box; }
+body { margin: 0; font-family: -apple-system, "Segoe UI", Roboto, sans-serif; background: #f4f5f7; color: #222; }
+header { display: flex; flex-wrap: wrap; align-items: center; justify-content: space-between; padding: 0 1em; background: #263238; color: #fff; }
+header h1 { font-size: 1.2em; margin: .6em 0; }
+nav a { color: #cfd8dc; margin-left: 1em; text-decoration: none; }
+nav a.active { color: #fff; font-weight: bold; }
+main { padding: 1em; max-width: 1100px; margin: auto; }
+h2 { font-size: 1em; margin: 1.5em 0 .5em; }
+.toolbar { display: flex; flex-wrap: wrap; align-items: center; gap: .6em; margin-bottom: 1em; }
+button, select, input { font: inherit; padding: .3em .8em; border: 1px solid #90a4ae; border-radius: 3px; background: #fff; }
+button:hover { background: #eceff1; cursor: pointer; }
+#stream { width: 100%; background: #000; min-height: 200px; }
+#message { padding: .6em; background: #ffebee; border: 1px solid #e57373; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax(180px, 1fr)); gap: .6em; }
+.grid figure { margin: 0; background: #fff; padding: .4em; border-radius: 3px; }
+.grid img { width: 100%; display: block; }
+.grid figcaption { font-size: .75em; word-break: break-all; }
+table { width: 100%; border-collapse: collapse; background: #fff; }
+td, th { text-align: left; padding: .3em .6em; border-bottom: 1px solid #eceff1; font-size: .9em; }
+td input { width: 100%; }
+#login-form { display: flex; flex-direction: column; gap: .6em; max-width: 300px; margin: 3em auto; }
+#totp-qr { display: block; width: 200px; background: #fff; }
+`
+
+const appJS = `(function () {
+	"use strict";
+
+	var api = "../api";
+	var cursor = "";
+
+	function $(id) {
+		return document.getElementById(id);
+	}
+
+	function show(message) {
+		$("message").textContent = message;
+		$("message").hidden = !message;
+	}
+
+	function request(path, options) {
+		options = options || {};
+		options.credentials = "same-origin";
+		options.headers = options.headers || {};
+		// no native credentials prompt, the login form is shown instead
+		options.headers["X-Requested-With"] = "XMLHttpRequest";
+
+		return fetch(api + path, options).then(function (response) {
+			return response.json().catch(function () {
+				return {};
+			}).then(function (body) {
+				if (response.status === 401) {
+					$("login-code").hidden = !body.secondFactor;
+					login(true);
+				}
+				// protected routes are retried once the session is verified
+				if (response.status === 403 && body.secondFactor && path.indexOf("/totp/") !== 0) {
+					return verify().then(function () {
+						return request(path, options);
+					});
+				}
+				if (!response.ok) {
+					throw new Error(body.message || response.statusText);
+				}
+				return body;
+			});
+		});
+	}
+
+	function get(path) {
+		return request(path);
+	}
+
+	function verify() {
+		var code = window.prompt("Two-factor or recovery code");
+		if (!code) {
+			return Promise.reject(new Error("second factor required"));
+		}
+		return get("/totp/verify?code=" + encodeURIComponent(code));
+	}
+
+	// Login
+
+	function login(required) {
+		$("login").hidden = !required;
+		if (required) {
+			Array.prototype.forEach.call(document.querySelectorAll("[data-page]"), function (section) {
+				section.hidden = true;
+			});
+			$("stream").removeAttribute("src");
+		}
+	}
+
+	$("login-form").onsubmit = function (e) {
+		e.preventDefault();
+		request("/auth/login", {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify({username: $("login-username").value, password: $("login-password").value, code: $("login-code").value})
+		}).then(function () {
+			$("login-password").value = "";
+			$("login-code").value = "";
+			show("");
+			login(false);
+			refresh();
+		}).catch(function (err) {
+			show(err.message);
+		});
+	};
+
+	$("logout").onclick = function (e) {
+		e.preventDefault();
+		get("/auth/logout").then(function () {
+			login(true);
+		});
+	};
+
+	function run(path) {
+		show("");
+		return get(path).then(function (body) {
+			refresh();
+			return body;
+		}).catch(function (err) {
+			show(err.message);
+		});
+	}
+
+	function file(name) {
+		return name.split("/").map(encodeURIComponent).join("/");
+	}
+
+	// Live
+
+	function refreshLive() {
+		get("/control/status").then(function (body) {
+			$("motion-state").textContent = body.motionStarted ? "running" : "stopped";
+			$("privacy-state").textContent = body.privacyMode ? "on" : "off";
+			if (!body.motionStarted) {
+				$("detection-state").textContent = "-";
+				$("stream").removeAttribute("src");
+				return;
+			}
+			if (!$("stream").getAttribute("src")) {
+				$("stream").src = api + "/camera/stream";
+			}
+			return get("/detection/status").then(function (body) {
+				$("detection-state").textContent = body.motionDetectionEnabled ? "enabled" : "paused";
+			});
+		}).catch(function (err) {
+			show(err.message);
+		});
+	}
+
+	$("motion-start").onclick = function () {
+		run("/control/startup?detection=true");
+	};
+
+	$("motion-stop").onclick = function () {
+		$("stream").removeAttribute("src");
+		run("/control/shutdown");
+	};
+
+	$("detection-toggle").onclick = function () {
+		run($("detection-state").textContent === "enabled" ? "/detection/stop" : "/detection/start");
+	};
+
+	$("privacy-toggle").onclick = function () {
+		run($("privacy-state").textContent === "on" ? "/privacy/disable" : "/privacy/enable");
+	};
+
+	$("snapshot").onclick = function () {
+		window.open(api + "/camera/snapshot?save=false", "_blank");
+	};
+
+	// Gallery
+
+	function refreshGallery(more) {
+		var query = "?order=desc&limit=48&type=" + encodeURIComponent($("gallery-type").value);
+		if (more) {
+			query += "&cursor=" + encodeURIComponent(cursor);
+		} else {
+			$("gallery-files").innerHTML = "";
+		}
+
+		get("/targetdir/list" + query).then(function (page) {
+			$("gallery-total").textContent = page.total + " files";
+			(page.files || []).forEach(function (f) {
+				var figure = document.createElement("figure");
+				var link = document.createElement("a");
+				link.href = api + "/targetdir/get/" + file(f.name);
+				link.target = "_blank";
+				if (f.type !== "other") {
+					var img = document.createElement("img");
+					img.loading = "lazy";
+					img.src = api + "/targetdir/thumb/" + file(f.name);
+					img.alt = f.name;
+					link.appendChild(img);
+				}
+				var caption = document.createElement("figcaption");
+				caption.textContent = f.name + " (" + new Date(f.modTime).toLocaleString() + ")";
+				link.appendChild(caption);
+				figure.appendChild(link);
+				$("gallery-files").appendChild(figure);
+			});
+			cursor = page.nextCursor || "";
+			$("gallery-more").hidden = !cursor;
+		}).catch(function (err) {
+			show(err.message);
+		});
+	}
+
+	$("gallery-type").onchange = function () {
+		refreshGallery(false);
+	};
+
+	$("gallery-refresh").onclick = function () {
+		refreshGallery(false);
+	};
+
+	$("gallery-more").onclick = function () {
+		refreshGallery(true);
+	};
+
+	// Status
+
+	function refreshStatus() {
+		get("/backup/status").then(function (body) {
+			$("backup-state").textContent = body.status;
+		}).catch(function () {
+			$("backup-state").textContent = "not available";
+		});
+
+		get("/notify/status").then(function (body) {
+			$("notify-ready").textContent = body.ready;
+			$("notify-active").textContent = body.active;
+		}).catch(function (err) {
+			show(err.message);
+		});
+
+		get("/storage").then(function (body) {
+			var table = $("storage");
+			table.innerHTML = "<tr><th>Volume</th><th>Path</th><th>Used</th><th>Free</th><th>Level</th></tr>";
+			body.volumes.forEach(function (v) {
+				var row = table.insertRow();
+				[v.name, v.path, v.usedPercent.toFixed(1) + "%", Math.round(v.free / 1000000) + " MB", v.level].forEach(function (value) {
+					row.insertCell().textContent = value;
+				});
+			});
+		}).catch(function () {
+			$("storage").innerHTML = "<tr><td>Storage monitor not running</td></tr>";
+		});
+	}
+
+	$("backup-launch").onclick = function () {
+		run("/backup/launch");
+	};
+
+	$("notify-toggle").onclick = function () {
+		run($("notify-active").textContent === "true" ? "/notify/deactivate" : "/notify/activate");
+	};
+
+	// Config
+
+	function refreshConfig() {
+		get("/config/list").then(function (config) {
+			var table = $("config-table");
+			var filter = $("config-filter").value.toLowerCase();
+			table.innerHTML = "";
+			Object.keys(config).sort().forEach(function (name) {
+				if (filter && name.toLowerCase().indexOf(filter) < 0) {
+					return;
+				}
+				var row = table.insertRow();
+				row.insertCell().textContent = name;
+				var input = document.createElement("input");
+				input.value = config[name];
+				input.onchange = function () {
+					run("/config/set?" + encodeURIComponent(name) + "=" + encodeURIComponent(input.value));
+				};
+				row.insertCell().appendChild(input);
+			});
+		}).catch(function (err) {
+			show(err.message);
+		});
+	}
+
+	$("config-filter").oninput = refreshConfig;
+
+	$("config-write").onclick = function () {
+		run("/config/write");
+	};
+
+	// Sessions
+
+	function refreshSessions() {
+		get("/auth/sessions").then(function (list) {
+			var table = $("sessions-table");
+			table.innerHTML = "<tr><th>User</th><th>From</th><th>Browser</th><th>Last seen</th><th>Expires</th><th></th></tr>";
+			list.forEach(function (s) {
+				var row = table.insertRow();
+				[s.user, s.remoteAddr, s.userAgent, new Date(s.lastSeen).toLocaleString(), new Date(s.expires).toLocaleString()].forEach(function (value) {
+					row.insertCell().textContent = value;
+				});
+				var button = document.createElement("button");
+				button.textContent = s.current ? "Logout" : "Revoke";
+				button.onclick = function () {
+					if (s.current) {
+						$("logout").onclick(new Event("click"));
+					} else {
+						run("/auth/sessions/revoke/" + encodeURIComponent(s.id));
+					}
+				};
+				row.insertCell().appendChild(button);
+			});
+		}).catch(function (err) {
+			show(err.message);
+		});
+		refreshTOTP();
+	}
+
+	function refreshTOTP() {
+		get("/totp/status").then(function (status) {
+			$("totp-state").textContent = !status.enabled ? "not available" : status.enrolled ? "enabled (" + status.recoveryCodes + " recovery codes left)" : "disabled";
+			$("totp-enroll").hidden = !status.enabled || status.enrolled;
+			$("totp-disable").hidden = !status.enrolled;
+		}).catch(function (err) {
+			show(err.message);
+		});
+	}
+
+	$("totp-enroll").onclick = function () {
+		show("");
+		get("/totp/enroll").then(function (enrolment) {
+			$("totp-qr").src = enrolment.qr;
+			$("totp-secret").textContent = enrolment.secret;
+			$("totp-setup").hidden = false;
+			$("totp-recovery").hidden = true;
+		}).catch(function (err) {
+			show(err.message);
+		});
+	};
+
+	$("totp-confirm").onclick = function () {
+		show("");
+		get("/totp/confirm?code=" + encodeURIComponent($("totp-code").value)).then(function (body) {
+			$("totp-code").value = "";
+			$("totp-setup").hidden = true;
+			$("totp-recovery-codes").textContent = body.recoveryCodes.join("\n");
+			$("totp-recovery").hidden = false;
+			refreshTOTP();
+		}).catch(function (err) {
+			show(err.message);
+		});
+	};
+
+	$("totp-disable").onclick = function () {
+		var code = window.prompt("Two-factor or recovery code");
+		if (code) {
+			run("/totp/disable?code=" + encodeURIComponent(code));
+		}
+	};
+
+	// Routing
+
+	var pages = {live: refreshLive, gallery: refreshGallery, status: refreshStatus, config: refreshConfig, sessions: refreshSessions};
+
+	function page() {
+		var name = location.hash.replace("#", "");
+		return pages[name] ? name : "live";
+	}
+
+	function refresh() {
+		var current = page();
+		Array.prototype.forEach.call(document.querySelectorAll("[data-page]"), function (section) {
+			section.hidden = section.id !== current;
+		});
+		Array.prototype.forEach.call(document.querySelectorAll("nav a"), function (link) {
+			link.className = link.getAttribute("href") === "#" + current ? "active" : "";
+		});
+		if (current !== "live") {
+			$("stream").removeAttribute("src");
+		}
+		pages[current](false);
+	}
+
+	window.onhashchange = function () {
+		show("");
+		refresh();
+	};
+
+	refresh();
+})();
+`
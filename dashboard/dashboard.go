@@ -0,0 +1,109 @@
+package dashboard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// assets of the default dashboard, paths are relative to /app
+var assets = map[string]string{
+	"/index.html": indexHTML,
+	"/style.css":  styleCSS,
+	"/app.js":     appJS,
+}
+
+// FS returns the default dashboard compiled into motionctrl, it is served when no 'appPath' is configured
+func FS() http.FileSystem {
+	return fileSystem{}
+}
+
+type fileSystem struct{}
+
+func (fileSystem) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+
+	if name == "/" {
+		return &file{name: "/", dir: true}, nil
+	}
+
+	content, ok := assets[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	return &file{Reader: bytes.NewReader([]byte(content)), name: name, size: int64(len(content)), etag: fmt.Sprintf("\"%x\"", sum[:8])}, nil
+}
+
+// file is an asset opened from fileSystem, it implements http.File and os.FileInfo
+type file struct {
+	*bytes.Reader
+	name string
+	size int64
+	etag string
+	dir  bool
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+func (f *file) Readdir(int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("%s: directory listing not supported", f.name)
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	return f, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.dir {
+		return 0, fmt.Errorf("%s is a directory", f.name)
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.dir {
+		return 0, fmt.Errorf("%s is a directory", f.name)
+	}
+	return f.Reader.Seek(offset, whence)
+}
+
+// ETag identifies the content of the asset, modification time is not known
+func (f *file) ETag() string {
+	return f.etag
+}
+
+func (f *file) Name() string {
+	return path.Base(f.name)
+}
+
+func (f *file) Size() int64 {
+	return f.size
+}
+
+func (f *file) Mode() os.FileMode {
+	if f.dir {
+		return os.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (f *file) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (f *file) IsDir() bool {
+	return f.dir
+}
+
+func (f *file) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,37 @@
+package dashboard
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFS(t *testing.T) {
+	fs := FS()
+
+	for name, content := range assets {
+		f, err := fs.Open(name)
+		require.NoError(t, err)
+
+		info, err := f.Stat()
+		require.NoError(t, err)
+		require.False(t, info.IsDir())
+		require.Equal(t, int64(len(content)), info.Size())
+
+		read, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		require.Equal(t, content, string(read))
+		require.NoError(t, f.Close())
+	}
+
+	dir, err := fs.Open("/")
+	require.NoError(t, err)
+	info, err := dir.Stat()
+	require.NoError(t, err)
+	require.True(t, info.IsDir())
+
+	_, err = fs.Open("/../config.json")
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+)
+
+type Type string
+
+const (
+	TypeEventStart   Type = "event_start"
+	TypeEventEnd     Type = "event_end"
+	TypePictureSaved Type = "picture_saved"
+	TypeMovieSaved   Type = "movie_saved"
+
+	TypeMotionStarted   Type = "motion_started"
+	TypeMotionStopped   Type = "motion_stopped"
+	TypeMotionRestarted Type = "motion_restarted"
+
+	TypeBackupStatus Type = "backup_status"
+	TypeBackupFile   Type = "backup_file"
+	TypeNotifySent   Type = "notify_sent"
+
+	TypeStorageLevel Type = "storage_level"
+
+	TypePrivacyMode Type = "privacy_mode"
+)
+
+const (
+	//HistorySize is the number of events kept in memory to let clients resume with Last-Event-ID
+	HistorySize = 512
+
+	subscriberBufferSize = 64
+)
+
+type Event struct {
+	ID     uint64                 `json:"id"`
+	Type   Type                   `json:"type"`
+	Time   time.Time              `json:"time"`
+	Camera int                    `json:"camera"`
+	File   string                 `json:"file,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// Subscription receives published events on C. C is closed when the subscriber
+// is too slow to keep up or when Unsubscribe is called.
+type Subscription struct {
+	C chan Event
+
+	types map[Type]bool
+}
+
+var (
+	eMutex      sync.Mutex
+	lastID      uint64
+	history     []Event
+	subscribers = map[*Subscription]bool{}
+)
+
+// Publish stores a new event in history and forwards it to every subscriber
+func Publish(t Type, camera int, file string, data map[string]interface{}) Event {
+	eMutex.Lock()
+	defer eMutex.Unlock()
+
+	lastID++
+
+	e := Event{ID: lastID, Type: t, Time: time.Now(), Camera: camera, File: file, Data: data}
+
+	history = append(history, e)
+	if len(history) > HistorySize {
+		history = history[len(history)-HistorySize:]
+	}
+
+	glg.Debugf("Publishing event: %+v", e)
+
+	for s := range subscribers {
+		if !s.accept(e) {
+			continue
+		}
+
+		select {
+		case s.C <- e:
+		default:
+			//Slow subscriber, it will resume from its last received event on reconnection
+			glg.Warnf("Event subscriber is too slow, dropping it")
+			delete(subscribers, s)
+			close(s.C)
+		}
+	}
+
+	return e
+}
+
+// Subscribe registers a new subscriber. Events in history with an ID greater than
+// lastEventID are replayed first (0 means no replay). An empty types list means all types.
+func Subscribe(lastEventID uint64, types []Type) *Subscription {
+	eMutex.Lock()
+	defer eMutex.Unlock()
+
+	var backlog []Event
+
+	if lastEventID > 0 {
+		if lastEventID > lastID {
+			//ID from a previous run, everything we have is new for this client
+			lastEventID = 0
+		}
+
+		for _, e := range history {
+			if e.ID > lastEventID {
+				backlog = append(backlog, e)
+			}
+		}
+	}
+
+	s := &Subscription{C: make(chan Event, len(backlog)+subscriberBufferSize)}
+
+	if len(types) > 0 {
+		s.types = make(map[Type]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	for _, e := range backlog {
+		if s.accept(e) {
+			s.C <- e
+		}
+	}
+
+	subscribers[s] = true
+
+	return s
+}
+
+// Unsubscribe removes the subscriber and closes its channel
+func Unsubscribe(s *Subscription) {
+	eMutex.Lock()
+	defer eMutex.Unlock()
+
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.C)
+	}
+}
+
+// History returns a copy of the events currently kept in memory
+func History() []Event {
+	eMutex.Lock()
+	defer eMutex.Unlock()
+
+	return append([]Event{}, history...)
+}
+
+func (s *Subscription) accept(e Event) bool {
+	return s.types == nil || s.types[e.Type]
+}
@@ -0,0 +1,78 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	s := Subscribe(0, nil)
+	defer Unsubscribe(s)
+
+	e := Publish(TypeEventStart, 1, "", nil)
+
+	received := <-s.C
+
+	require.Equal(t, e.ID, received.ID)
+	require.Equal(t, TypeEventStart, received.Type)
+	require.Equal(t, 1, received.Camera)
+}
+
+func TestResume(t *testing.T) {
+	first := Publish(TypePictureSaved, 0, "a.jpg", nil)
+	Publish(TypePictureSaved, 0, "b.jpg", nil)
+	Publish(TypeEventEnd, 0, "", nil)
+
+	s := Subscribe(first.ID, nil)
+	defer Unsubscribe(s)
+
+	require.Equal(t, "b.jpg", (<-s.C).File)
+	require.Equal(t, TypeEventEnd, (<-s.C).Type)
+	require.Empty(t, s.C)
+}
+
+func TestResumeUnknownID(t *testing.T) {
+	Publish(TypeMotionStarted, 0, "", nil)
+
+	s := Subscribe(lastID+1000, nil)
+	defer Unsubscribe(s)
+
+	require.Equal(t, len(History()), len(s.C))
+}
+
+func TestTypeFilter(t *testing.T) {
+	s := Subscribe(0, []Type{TypeEventEnd})
+	defer Unsubscribe(s)
+
+	Publish(TypeEventStart, 0, "", nil)
+	Publish(TypeEventEnd, 0, "", nil)
+
+	require.Equal(t, TypeEventEnd, (<-s.C).Type)
+	require.Empty(t, s.C)
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	s := Subscribe(0, nil)
+
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		Publish(TypePictureSaved, 0, "", nil)
+	}
+
+	n := 0
+	for range s.C {
+		n++
+	}
+
+	require.Equal(t, subscriberBufferSize, n)
+
+	Unsubscribe(s) //Already removed, must not panic
+}
+
+func TestHistoryBounded(t *testing.T) {
+	for i := 0; i < HistorySize+10; i++ {
+		Publish(TypePictureSaved, 0, "", nil)
+	}
+
+	require.Len(t, History(), HistorySize)
+}
@@ -0,0 +1,576 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	// DefaultFile is hidden, so it is skipped by backup and retention
+	DefaultFile = ".history.db"
+
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+
+	// number of closed events per camera still accepting late files (e.g. movies saved after event end)
+	recentPerCamera = 16
+)
+
+var (
+	recordsBucket = []byte("events")
+	filesBucket   = []byte("files")
+
+	subscribedTypes = []events.Type{
+		events.TypeEventStart,
+		events.TypeEventEnd,
+		events.TypePictureSaved,
+		events.TypeMovieSaved,
+		events.TypeNotifySent,
+		events.TypeBackupFile,
+	}
+)
+
+// Outcome is the result of a notification or of a file backup
+type Outcome struct {
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+	Method  string    `json:"method,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// File is a picture or a movie saved during an event, Name is relative to target_dir
+type File struct {
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+	Backup *Outcome  `json:"backup,omitempty"`
+}
+
+type Notification struct {
+	Outcome
+	Kind string `json:"kind"`
+	File string `json:"file,omitempty"`
+}
+
+// Record is a motion event with its files
+type Record struct {
+	ID            uint64         `json:"id"`
+	Camera        int            `json:"camera"`
+	MotionEvent   string         `json:"motionEvent,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           *time.Time     `json:"end,omitempty"`
+	Duration      float64        `json:"duration"`
+	Pictures      []File         `json:"pictures"`
+	Movies        []File         `json:"movies"`
+	Notifications []Notification `json:"notifications"`
+}
+
+// Query selects records, newest first. Camera is ignored when nil, Before is the cursor returned by previous page
+type Query struct {
+	From   time.Time
+	To     time.Time
+	Camera *int
+	Limit  int
+	Before uint64
+}
+
+type Page struct {
+	Records    []Record `json:"records"`
+	NextCursor uint64   `json:"nextCursor,omitempty"`
+}
+
+type recentRecord struct {
+	id          uint64
+	motionEvent string
+}
+
+var (
+	hMutex       sync.Mutex
+	db           *bolt.DB
+	subscription *events.Subscription
+	done         chan struct{}
+	listenGroup  sync.WaitGroup
+	maxAge       time.Duration
+
+	open   map[int]uint64
+	recent map[int][]recentRecord
+)
+
+func Init(conf config.History, targetDir string) error {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if db != nil {
+		return fmt.Errorf("History service already initialized")
+	}
+
+	var age time.Duration
+	if conf.MaxAge != "" {
+		var err error
+		if age, err = utils.ParseDuration(conf.MaxAge); err != nil || age <= 0 {
+			return fmt.Errorf("Invalid 'history.maxAge': %s", conf.MaxAge)
+		}
+	}
+
+	file := conf.File
+	if file == "" {
+		file = filepath.Join(targetDir, DefaultFile)
+	}
+
+	store, err := bolt.Open(file, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("Unable to open history database %s: %v", file, err)
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{recordsBucket, filesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		store.Close()
+		return fmt.Errorf("Unable to initialize history database %s: %v", file, err)
+	}
+
+	glg.Infof("Event history stored in: %s", file)
+
+	db = store
+	maxAge = age
+	open = make(map[int]uint64)
+	recent = make(map[int][]recentRecord)
+	done = make(chan struct{})
+
+	prune()
+
+	subscription = events.Subscribe(0, subscribedTypes)
+
+	listenGroup.Add(1)
+	go listen(subscription, done)
+
+	return nil
+}
+
+func Shutdown() {
+	hMutex.Lock()
+
+	glg.Info("Shuting down history service")
+
+	if db == nil {
+		hMutex.Unlock()
+		return
+	}
+
+	close(done)
+	events.Unsubscribe(subscription)
+
+	hMutex.Unlock()
+
+	listenGroup.Wait()
+
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if err := db.Close(); err != nil {
+		glg.Errorf("Unable to close history database: %v", err)
+	}
+
+	db = nil
+	subscription = nil
+}
+
+// listen stores every received event, it subscribes again (without losing events) if the bus drops it for being slow
+func listen(s *events.Subscription, done chan struct{}) {
+	defer listenGroup.Done()
+
+	var lastID uint64
+
+	for {
+		for e := range s.C {
+			lastID = e.ID
+
+			if err := apply(e); err != nil {
+				glg.Errorf("Unable to store event %d in history: %v", e.ID, err)
+			}
+		}
+
+		hMutex.Lock()
+		select {
+		case <-done:
+			hMutex.Unlock()
+			return
+		default:
+		}
+
+		s = events.Subscribe(lastID, subscribedTypes)
+		subscription = s
+		hMutex.Unlock()
+	}
+}
+
+func apply(e events.Event) error {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	motionEvent, _ := e.Data["event"].(string)
+
+	switch e.Type {
+	case events.TypeEventStart:
+		if id := open[e.Camera]; id != 0 {
+			//End never received (e.g. motion restarted)
+			if err := update(id, func(r *Record) { r.close(e.Time) }); err != nil {
+				return err
+			}
+		}
+
+		id, err := create(Record{Camera: e.Camera, MotionEvent: motionEvent, Start: e.Time, Pictures: []File{}, Movies: []File{}, Notifications: []Notification{}})
+		if err != nil {
+			return err
+		}
+
+		open[e.Camera] = id
+		recent[e.Camera] = append(recent[e.Camera], recentRecord{id: id, motionEvent: motionEvent})
+		if len(recent[e.Camera]) > recentPerCamera {
+			recent[e.Camera] = recent[e.Camera][1:]
+		}
+
+		prune()
+
+	case events.TypeEventEnd:
+		id := find(e.Camera, motionEvent)
+		if id == 0 {
+			return nil
+		}
+
+		if open[e.Camera] == id {
+			delete(open, e.Camera)
+		}
+
+		return update(id, func(r *Record) { r.close(e.Time) })
+
+	case events.TypePictureSaved, events.TypeMovieSaved:
+		id := find(e.Camera, motionEvent)
+		if id == 0 {
+			//e.g. snapshots
+			glg.Debugf("%s doesn't belong to any event", e.File)
+			return nil
+		}
+
+		err := update(id, func(r *Record) {
+			if e.Type == events.TypePictureSaved {
+				r.Pictures = append(r.Pictures, File{Name: e.File, Time: e.Time})
+			} else {
+				r.Movies = append(r.Movies, File{Name: e.File, Time: e.Time})
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(filesBucket).Put([]byte(e.File), itob(id))
+		})
+
+	case events.TypeNotifySent:
+		var id uint64
+		if e.File != "" {
+			id = fileRecord(e.File)
+		} else {
+			//Notification of event start, camera is unknown: latest open event
+			for _, openID := range open {
+				if openID > id {
+					id = openID
+				}
+			}
+		}
+
+		if id == 0 {
+			return nil
+		}
+
+		kind, _ := e.Data["kind"].(string)
+
+		return update(id, func(r *Record) {
+			r.Notifications = append(r.Notifications, Notification{Outcome: outcome(e), Kind: kind, File: e.File})
+		})
+
+	case events.TypeBackupFile:
+		id := fileRecord(e.File)
+		if id == 0 {
+			return nil
+		}
+
+		backup := outcome(e)
+
+		return update(id, func(r *Record) {
+			for _, files := range [][]File{r.Pictures, r.Movies} {
+				for i := range files {
+					if files[i].Name == e.File {
+						files[i].Backup = &backup
+					}
+				}
+			}
+		})
+	}
+
+	return nil
+}
+
+// List returns records matching q, newest first
+func List(q Query) (Page, error) {
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		return Page{}, fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return Page{}, fmt.Errorf("'to' must be after 'from'")
+	}
+
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if db == nil {
+		return Page{}, fmt.Errorf("History service is not ready")
+	}
+
+	page := Page{Records: []Record{}}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(recordsBucket).Cursor()
+
+		var k, v []byte
+		if q.Before > 0 {
+			if k, v = c.Seek(itob(q.Before)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Last()
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			//Records are sorted by start time too
+			if !q.From.IsZero() && r.Start.Before(q.From) {
+				break
+			}
+
+			if (!q.To.IsZero() && r.Start.After(q.To)) || (q.Camera != nil && r.Camera != *q.Camera) {
+				continue
+			}
+
+			page.Records = append(page.Records, r)
+
+			if len(page.Records) == q.Limit {
+				page.NextCursor = r.ID
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return page, err
+}
+
+func Get(id uint64) (Record, error) {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if db == nil {
+		return Record{}, fmt.Errorf("History service is not ready")
+	}
+
+	var r Record
+	var found bool
+
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(recordsBucket).Get(itob(id))
+		if v == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(v, &r)
+	})
+
+	if err == nil && !found {
+		err = fmt.Errorf("event %d not found", id)
+	}
+
+	return r, err
+}
+
+func (r *Record) close(end time.Time) {
+	r.End = &end
+	r.Duration = end.Sub(r.Start).Seconds()
+}
+
+func outcome(e events.Event) Outcome {
+	o := Outcome{Time: e.Time}
+	o.Success, _ = e.Data["success"].(bool)
+	o.Method, _ = e.Data["method"].(string)
+	o.Remote, _ = e.Data["remote"].(string)
+	o.Error, _ = e.Data["error"].(string)
+	return o
+}
+
+// find returns the event of camera with motionEvent number, the open one when motionEvent is empty. hMutex must be held
+func find(camera int, motionEvent string) uint64 {
+	if motionEvent == "" {
+		return open[camera]
+	}
+
+	list := recent[camera]
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i].motionEvent == motionEvent {
+			return list[i].id
+		}
+	}
+
+	return 0
+}
+
+func fileRecord(name string) uint64 {
+	var id uint64
+
+	db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(filesBucket).Get([]byte(name)); v != nil {
+			id = binary.BigEndian.Uint64(v)
+		}
+		return nil
+	})
+
+	return id
+}
+
+func create(r Record) (uint64, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket)
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		r.ID = id
+
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(itob(id), raw)
+	})
+
+	return r.ID, err
+}
+
+func update(id uint64, fn func(*Record)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(recordsBucket)
+
+		v := bucket.Get(itob(id))
+		if v == nil {
+			return fmt.Errorf("event %d not found", id)
+		}
+
+		var r Record
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+
+		fn(&r)
+
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(itob(id), raw)
+	})
+}
+
+// prune removes records older than maxAge, hMutex must be held
+func prune() {
+	if maxAge <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(recordsBucket)
+		files := tx.Bucket(filesBucket)
+
+		//Keys are collected first, deleting while iterating with a cursor skips elements
+		var expired [][]byte
+		c := records.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			if !r.Start.Before(cutoff) {
+				break
+			}
+
+			for _, f := range append(r.Pictures, r.Movies...) {
+				if err := files.Delete([]byte(f.Name)); err != nil {
+					return err
+				}
+			}
+
+			expired = append(expired, append([]byte{}, k...))
+		}
+
+		for _, k := range expired {
+			if err := records.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		removed = len(expired)
+
+		return nil
+	})
+
+	if err != nil {
+		glg.Errorf("Unable to prune history: %v", err)
+	} else if removed > 0 {
+		glg.Debugf("%d events removed from history", removed)
+	}
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
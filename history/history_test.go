@@ -0,0 +1,141 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+)
+
+// waitRecords polls history until fn is satisfied, events are stored asynchronously
+func waitRecords(t *testing.T, q Query, fn func([]Record) bool) []Record {
+	for i := 0; i < 100; i++ {
+		page, err := List(q)
+		require.NoError(t, err)
+
+		if fn(page.Records) {
+			return page.Records
+		}
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatal("history not updated")
+	return nil
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, Init(config.History{}, dir))
+	require.FileExists(t, dir+"/"+DefaultFile)
+	require.Error(t, Init(config.History{}, dir))
+
+	first := map[string]interface{}{"event": "1"}
+	second := map[string]interface{}{"event": "2"}
+
+	events.Publish(events.TypeEventStart, 1, "", first)
+	events.Publish(events.TypeNotifySent, 0, "", map[string]interface{}{"kind": "message", "method": "telegram", "success": true})
+	events.Publish(events.TypePictureSaved, 1, "01-01.jpg", first)
+	events.Publish(events.TypePictureSaved, 1, "01-02.jpg", first)
+	events.Publish(events.TypeNotifySent, 0, "01-01.jpg", map[string]interface{}{"kind": "photo", "success": false, "error": "timeout"})
+	events.Publish(events.TypeEventEnd, 1, "", first)
+	//Movies are saved after event end
+	events.Publish(events.TypeMovieSaved, 1, "01.mkv", first)
+
+	//Other camera and a picture not belonging to any event
+	events.Publish(events.TypeEventStart, 2, "", second)
+	events.Publish(events.TypePictureSaved, 3, "snapshot.jpg", nil)
+
+	records := waitRecords(t, Query{}, func(r []Record) bool { return len(r) == 2 })
+
+	require.Equal(t, 2, records[0].Camera)
+	require.Nil(t, records[0].End)
+
+	record := records[1]
+	require.Equal(t, 1, record.Camera)
+	require.Equal(t, "1", record.MotionEvent)
+	require.NotNil(t, record.End)
+	require.True(t, record.Duration >= 0)
+	require.Len(t, record.Pictures, 2)
+	require.Equal(t, "01-01.jpg", record.Pictures[0].Name)
+	require.Equal(t, "01.mkv", record.Movies[0].Name)
+	require.Len(t, record.Notifications, 2)
+	require.True(t, record.Notifications[0].Success)
+	require.Equal(t, "message", record.Notifications[0].Kind)
+	require.Equal(t, "timeout", record.Notifications[1].Error)
+
+	//Backup outcome is linked to the file
+	events.Publish(events.TypeBackupFile, 0, "01.mkv", map[string]interface{}{"method": "google", "success": true, "remote": "01.mkv.aes"})
+
+	records = waitRecords(t, Query{Camera: &record.Camera}, func(r []Record) bool {
+		return len(r) == 1 && r[0].Movies[0].Backup != nil
+	})
+	require.Equal(t, "01.mkv.aes", records[0].Movies[0].Backup.Remote)
+
+	//Get
+	r, err := Get(record.ID)
+	require.NoError(t, err)
+	require.Equal(t, record.ID, r.ID)
+
+	_, err = Get(1000)
+	require.Error(t, err)
+
+	//Pagination and time filters
+	page, err := List(Query{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 1)
+
+	page, err = List(Query{Limit: 1, Before: page.NextCursor})
+	require.NoError(t, err)
+	require.Equal(t, record.ID, page.Records[0].ID)
+
+	page, err = List(Query{From: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	require.Empty(t, page.Records)
+
+	_, err = List(Query{Limit: MaxListLimit + 1})
+	require.Error(t, err)
+
+	//Records survive restart
+	Shutdown()
+
+	require.NoError(t, Init(config.History{}, dir))
+	defer Shutdown()
+
+	page, err = List(Query{})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 2)
+}
+
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.Error(t, Init(config.History{MaxAge: "forever"}, dir))
+
+	require.NoError(t, Init(config.History{MaxAge: "1h"}, dir))
+	defer Shutdown()
+
+	hMutex.Lock()
+	_, err = create(Record{Camera: 1, Start: time.Now().Add(-2 * time.Hour), Pictures: []File{{Name: "old.jpg"}}})
+	require.NoError(t, err)
+	_, err = create(Record{Camera: 1, Start: time.Now()})
+	require.NoError(t, err)
+
+	prune()
+	hMutex.Unlock()
+
+	page, err := List(Query{})
+	require.NoError(t, err)
+	require.Len(t, page.Records, 1)
+	require.Equal(t, uint64(0), fileRecord("old.jpg"))
+}
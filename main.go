@@ -10,8 +10,19 @@ import (
 	"github.com/andreacioni/motionctrl/api"
 	"github.com/andreacioni/motionctrl/backup"
 	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/history"
+	"github.com/andreacioni/motionctrl/metrics"
 	"github.com/andreacioni/motionctrl/motion"
 	"github.com/andreacioni/motionctrl/notify"
+	"github.com/andreacioni/motionctrl/privacy"
+	"github.com/andreacioni/motionctrl/retention"
+	"github.com/andreacioni/motionctrl/session"
+	"github.com/andreacioni/motionctrl/share"
+	"github.com/andreacioni/motionctrl/storage"
+	"github.com/andreacioni/motionctrl/stream"
+	"github.com/andreacioni/motionctrl/thumbnail"
+	"github.com/andreacioni/motionctrl/timelapse"
+	"github.com/andreacioni/motionctrl/twofactor"
 	"github.com/andreacioni/motionctrl/version"
 )
 
@@ -20,6 +31,7 @@ var (
 	logLevel   string
 	autostart  bool
 	detection  bool
+	hooks      bool
 
 	mu sync.Mutex
 )
@@ -40,18 +52,60 @@ func main() {
 		glg.Fatalf("Error loading configuration: %v", err)
 	}
 
+	//Print motion hooks and exit
+	if hooks {
+		commands, err := api.HookCommands(config.GetConfig())
+		if err != nil {
+			glg.Fatalf("Error building motion hooks: %v", err)
+		}
+
+		for _, command := range commands {
+			fmt.Println(command)
+		}
+		return
+	}
+
 	//Initialize motion package
 	if err := motion.Init(config.GetConfig().MotionConfigFile, autostart, detection); err != nil {
 		glg.Fatalf("Error initializing motion package: %v", err)
 	}
 
-	//Initialize backup  (if enabled)
+	//Initialize backup and retention (if enabled), storage monitor, time-lapse, thumbnails and event history
 	if targetDir, err := motion.ConfigGet(motion.ConfigTargetDir); err == nil && targetDir != nil {
 		if err := backup.Init(config.GetBackupConfig(), targetDir.(string)); err != nil {
 			glg.Errorf("Error initializing backup package: %v", err)
 		}
+
+		if err := retention.Init(config.GetRetentionConfig(), targetDir.(string)); err != nil {
+			glg.Errorf("Error initializing retention package: %v", err)
+		}
+
+		if err := storage.Init(config.GetStorageConfig(), targetDir.(string)); err != nil {
+			glg.Errorf("Error initializing storage package: %v", err)
+		}
+
+		if err := timelapse.Init(config.GetTimelapseConfig(), targetDir.(string)); err != nil {
+			glg.Errorf("Error initializing time-lapse package: %v", err)
+		}
+
+		if err := thumbnail.Init(config.GetThumbnailConfig(), targetDir.(string)); err != nil {
+			glg.Errorf("Error initializing thumbnail package: %v", err)
+		}
+
+		if err := history.Init(config.GetHistoryConfig(), targetDir.(string)); err != nil {
+			glg.Errorf("Error initializing history package: %v", err)
+		}
 	} else {
-		glg.Errorf("Unable to build backup service without valid 'target_dir' configured")
+		glg.Errorf("Unable to build backup, retention, storage, time-lapse, thumbnail and history services without valid 'target_dir' configured")
+	}
+
+	//Privacy mode and share links are kept in 'target_dir' unless their 'file' is defined
+	targetDir, _ := motion.ConfigGet(motion.ConfigTargetDir)
+	stateDir, _ := targetDir.(string)
+
+	//Initialize privacy mode
+	if err := privacy.Init(config.GetPrivacyConfig(), stateDir); err != nil {
+		glg.Errorf("Error initializing privacy package: %v", err)
 	}
 
 	//Initialize notify  (if enabled)
@@ -59,6 +113,31 @@ func main() {
 		glg.Errorf("Error initializing notify package: %v", err)
 	}
 
+	//Initialize sessions of browser clients
+	if err := session.Init(config.GetSessionConfig()); err != nil {
+		glg.Errorf("Error initializing session package: %v", err)
+	}
+
+	//Initialize two-factor authentication (if enabled)
+	if err := twofactor.Init(config.GetTOTPConfig()); err != nil {
+		glg.Errorf("Error initializing twofactor package: %v", err)
+	}
+
+	//Initialize share links
+	if err := share.Init(config.GetShareConfig(), stateDir); err != nil {
+		glg.Errorf("Error initializing share package: %v", err)
+	}
+
+	//Metrics read on every scrape
+	metrics.Init(metrics.Sources{
+		MotionUp: func() bool {
+			started, err := motion.IsStarted()
+			return err == nil && started
+		},
+		StreamViewers: func() int { return stream.GetStats().Viewers },
+		TargetDir:     motion.TargetDirCount,
+	})
+
 	//Initialize REST api
 	if err := api.Init(config.GetConfig(), shutdownHook); err != nil {
 		glg.Errorf("Error initializing API package: %v", err)
@@ -66,10 +145,30 @@ func main() {
 }
 
 func shutdownHook() {
+	stream.Shutdown()
+
+	timelapse.Shutdown()
+
+	thumbnail.Shutdown()
+
+	storage.Shutdown()
+
 	notify.Shutdown()
 
+	session.Shutdown()
+
+	share.Shutdown()
+
+	twofactor.Shutdown()
+
+	privacy.Shutdown()
+
+	retention.Shutdown()
+
 	backup.Shutdown()
 
+	history.Shutdown()
+
 	motion.Shutdown()
 
 	config.Unload()
@@ -84,6 +183,7 @@ func parseArgs() {
 	flag.StringVar(&logLevel, "l", "WARN", "set log level")
 	flag.BoolVar(&autostart, "a", false, fmt.Sprintf("start motion right after %s", version.Name))
 	flag.BoolVar(&detection, "d", false, "when -a is set, starts with motion detection enabled")
+	flag.BoolVar(&hooks, "hooks", false, "print motion hook commands (on_event_start, ...) to put in motion configuration file and exit")
 
 	flag.Parse()
 }
@@ -0,0 +1,160 @@
+package mask
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// TypeDetection masks are areas where motion is not detected (mask_file)
+	TypeDetection = "detection"
+	// TypePrivacy masks are areas blacked out in pictures, movies and stream (mask_privacy)
+	TypePrivacy = "privacy"
+
+	// Masked pixels are black, the others white. Grey levels of uploaded detection masks reduce sensitivity
+	Masked   = 0x00
+	Unmasked = 0xFF
+)
+
+// Point is [x, y] in image coordinates, (0, 0) is the top left corner of the top left pixel
+type Point [2]float64
+
+type Rectangle struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Shapes are the masked areas, Invert masks everything else
+type Shapes struct {
+	Polygons   [][]Point   `json:"polygons"`
+	Rectangles []Rectangle `json:"rectangles"`
+	Invert     bool        `json:"invert"`
+}
+
+// overlay is the color of masked areas in previews
+var overlay = color.RGBA{0xFF, 0x00, 0x00, 0xFF}
+
+// Rasterize draws shapes on a width x height mask. A pixel is masked when its center is inside a shape (even-odd rule)
+func Rasterize(s Shapes, width, height int) (*image.Gray, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid mask size: %dx%d", width, height)
+	}
+
+	if len(s.Polygons) == 0 && len(s.Rectangles) == 0 {
+		return nil, fmt.Errorf("no polygons or rectangles")
+	}
+
+	inside, outside := uint8(Masked), uint8(Unmasked)
+	if s.Invert {
+		inside, outside = outside, inside
+	}
+
+	m := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range m.Pix {
+		m.Pix[i] = outside
+	}
+
+	for i, r := range s.Rectangles {
+		if r.Width <= 0 || r.Height <= 0 {
+			return nil, fmt.Errorf("rectangle %d: width and height must be greater than 0", i)
+		}
+
+		draw.Draw(m, image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height), image.NewUniform(color.Gray{inside}), image.Point{}, draw.Src)
+	}
+
+	for i, p := range s.Polygons {
+		if len(p) < 3 {
+			return nil, fmt.Errorf("polygon %d: at least 3 points are required", i)
+		}
+
+		fillPolygon(m, p, inside)
+	}
+
+	return m, nil
+}
+
+// FromImage converts an uploaded mask to grey levels, it is scaled to width x height if needed
+func FromImage(img image.Image, width, height int) *image.Gray {
+	m := image.NewGray(image.Rect(0, 0, width, height))
+
+	if img.Bounds().Dx() == width && img.Bounds().Dy() == height {
+		draw.Draw(m, m.Bounds(), img, img.Bounds().Min, draw.Src)
+	} else {
+		draw.NearestNeighbor.Scale(m, m.Bounds(), img, img.Bounds(), draw.Src, nil)
+	}
+
+	return m
+}
+
+// Preview draws frame (scaled to the size of m) with masked areas tinted, proportionally to their grey level
+func Preview(frame image.Image, m *image.Gray) *image.RGBA {
+	bounds := m.Bounds()
+	preview := image.NewRGBA(bounds)
+
+	if frame != nil {
+		draw.ApproxBiLinear.Scale(preview, bounds, frame, frame.Bounds(), draw.Src, nil)
+	} else {
+		draw.Draw(preview, bounds, image.NewUniform(color.Gray{0x80}), image.Point{}, draw.Src)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			//Half opacity on fully masked pixels
+			alpha := uint32(Unmasked-m.GrayAt(x, y).Y) / 2
+			if alpha == 0 {
+				continue
+			}
+
+			c := preview.RGBAAt(x, y)
+			preview.SetRGBA(x, y, color.RGBA{
+				blend(c.R, overlay.R, alpha),
+				blend(c.G, overlay.G, alpha),
+				blend(c.B, overlay.B, alpha),
+				0xFF,
+			})
+		}
+	}
+
+	return preview
+}
+
+// fillPolygon sets pixels whose center is inside polygon p to value, scanning rows
+func fillPolygon(m *image.Gray, p []Point, value uint8) {
+	bounds := m.Bounds()
+	crossings := make([]float64, 0, len(p))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		crossings = crossings[:0]
+
+		for i := range p {
+			a, b := p[i], p[(i+1)%len(p)]
+			if (a[1] <= cy) != (b[1] <= cy) {
+				crossings = append(crossings, a[0]+(cy-a[1])*(b[0]-a[0])/(b[1]-a[1]))
+			}
+		}
+
+		sort.Float64s(crossings)
+
+		//Pixels with crossings[i] <= x+0.5 < crossings[i+1]
+		for i := 0; i+1 < len(crossings); i += 2 {
+			from := int(math.Max(math.Ceil(crossings[i]-0.5), float64(bounds.Min.X)))
+			to := int(math.Min(math.Ceil(crossings[i+1]-0.5), float64(bounds.Max.X)))
+
+			for x := from; x < to; x++ {
+				m.SetGray(x, y, color.Gray{value})
+			}
+		}
+	}
+}
+
+func blend(from, to uint8, alpha uint32) uint8 {
+	return uint8((uint32(from)*(0xFF-alpha) + uint32(to)*alpha) / 0xFF)
+}
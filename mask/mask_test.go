@@ -0,0 +1,100 @@
+package mask
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rows renders m as strings, '#' is masked
+func rows(m *image.Gray) []string {
+	var ret []string
+	for y := 0; y < m.Bounds().Dy(); y++ {
+		row := ""
+		for x := 0; x < m.Bounds().Dx(); x++ {
+			if m.GrayAt(x, y).Y == Masked {
+				row += "#"
+			} else {
+				row += "."
+			}
+		}
+		ret = append(ret, row)
+	}
+	return ret
+}
+
+func TestRasterize(t *testing.T) {
+	m, err := Rasterize(Shapes{
+		Rectangles: []Rectangle{{X: 0, Y: 0, Width: 2, Height: 1}, {X: 7, Y: 3, Width: 5, Height: 5}},
+		Polygons:   [][]Point{{{2, 2}, {6, 2}, {4, 6}}},
+	}, 8, 6)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"##......",
+		"........",
+		"..####..",
+		"...##..#",
+		"...##..#",
+		".......#",
+	}, rows(m))
+
+	m, err = Rasterize(Shapes{Rectangles: []Rectangle{{X: 1, Y: 1, Width: 2, Height: 1}}, Invert: true}, 4, 3)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"####",
+		"#..#",
+		"####",
+	}, rows(m))
+
+	//Self intersecting polygons follow the even-odd rule, points out of the image are clipped
+	m, err = Rasterize(Shapes{Polygons: [][]Point{{{-2, 0}, {4, 4}, {4, 0}, {-2, 4}}}}, 4, 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"...#",
+		"..##",
+		"..##",
+		"...#",
+	}, rows(m))
+
+	_, err = Rasterize(Shapes{}, 4, 4)
+	require.Error(t, err)
+	_, err = Rasterize(Shapes{Polygons: [][]Point{{{0, 0}, {1, 1}}}}, 4, 4)
+	require.Error(t, err)
+	_, err = Rasterize(Shapes{Rectangles: []Rectangle{{Width: 0, Height: 1}}}, 4, 4)
+	require.Error(t, err)
+	_, err = Rasterize(Shapes{Rectangles: []Rectangle{{Width: 1, Height: 1}}}, 0, 4)
+	require.Error(t, err)
+}
+
+func TestFromImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.White)
+	img.Set(1, 0, color.Black)
+
+	m := FromImage(img, 2, 1)
+	require.Equal(t, []uint8{Unmasked, Masked}, m.Pix)
+
+	//Scaled to the camera resolution
+	m = FromImage(img, 4, 2)
+	require.Equal(t, []string{"..##", "..##"}, rows(m))
+}
+
+func TestPreview(t *testing.T) {
+	m, err := Rasterize(Shapes{Rectangles: []Rectangle{{X: 0, Y: 0, Width: 1, Height: 1}}}, 2, 1)
+	require.NoError(t, err)
+
+	frame := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range frame.Pix {
+		frame.Pix[i] = 0xFF
+	}
+
+	preview := Preview(frame, m)
+	require.Equal(t, m.Bounds(), preview.Bounds())
+	require.Equal(t, color.RGBA{0xFF, 0x80, 0x80, 0xFF}, preview.RGBAAt(0, 0))
+	require.Equal(t, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, preview.RGBAAt(1, 0))
+
+	//No frame available
+	require.Equal(t, color.RGBA{0x80, 0x80, 0x80, 0xFF}, Preview(nil, m).RGBAAt(1, 0))
+}
@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "motionctrl"
+
+// Sources are read on every scrape, they are provided by main to avoid import cycles with instrumented packages
+type Sources struct {
+	MotionUp      func() bool
+	StreamViewers func() int
+	TargetDir     func() (int, int64, error)
+}
+
+var (
+	motionRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "motion_restarts_total", Help: "Number of motion restarts.",
+	})
+
+	webControlDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "webcontrol_request_duration_seconds", Help: "Latency of requests to motion webcontrol.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"command"})
+
+	webControlErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "webcontrol_errors_total", Help: "Failed requests to motion webcontrol.",
+	}, []string{"command"})
+
+	streamBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "stream_bytes_total", Help: "Bytes of stream sent to viewers.",
+	})
+
+	motionEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "events_total", Help: "Motion events started.",
+	}, []string{"camera"})
+
+	pictures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "pictures_total", Help: "Pictures saved by motion.",
+	}, []string{"camera"})
+
+	notifySent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "notify_sent_total", Help: "Notifications sent.",
+	}, []string{"method"})
+
+	notifyFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "notify_failures_total", Help: "Notifications that couldn't be sent.",
+	}, []string{"method"})
+
+	backupRuns = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "backup_runs_total", Help: "Backup runs.",
+	})
+
+	backupFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "backup_failures_total", Help: "Backup runs ended with an error.",
+	})
+
+	backupFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "backup_files_total", Help: "Files of target_dir uploaded by backup.",
+	})
+
+	backupBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "backup_bytes_total", Help: "Bytes uploaded by backup (archived and encrypted, if enabled).",
+	})
+
+	backupLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace, Name: "backup_last_success_timestamp_seconds", Help: "Time of the last backup run ended without errors.",
+	})
+
+	targetDirSizeDesc  = prometheus.NewDesc(namespace+"_target_dir_size_bytes", "Overall size of files in target_dir.", nil, nil)
+	targetDirFilesDesc = prometheus.NewDesc(namespace+"_target_dir_files", "Number of files in target_dir.", nil, nil)
+)
+
+var (
+	mMutex   sync.Mutex
+	registry *prometheus.Registry
+)
+
+func init() {
+	registry = newRegistry(Sources{})
+}
+
+// Init registers gauges read from sources, it can be called once
+func Init(sources Sources) {
+	mMutex.Lock()
+	defer mMutex.Unlock()
+
+	registry = newRegistry(sources)
+}
+
+// Handler serves metrics in Prometheus text format
+func Handler() http.Handler {
+	mMutex.Lock()
+	defer mMutex.Unlock()
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func newRegistry(sources Sources) *prometheus.Registry {
+	r := prometheus.NewRegistry()
+
+	r.MustRegister(
+		motionRestarts, webControlDuration, webControlErrors, streamBytes, motionEvents, pictures,
+		notifySent, notifyFailures, backupRuns, backupFailures, backupFiles, backupBytes, backupLastSuccess,
+	)
+
+	if sources.MotionUp != nil {
+		r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "motion_up", Help: "1 if motion is running, 0 otherwise.",
+		}, func() float64 {
+			if sources.MotionUp() {
+				return 1
+			}
+			return 0
+		}))
+	}
+
+	if sources.StreamViewers != nil {
+		r.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "stream_viewers", Help: "Clients currently watching the stream.",
+		}, func() float64 {
+			return float64(sources.StreamViewers())
+		}))
+	}
+
+	if sources.TargetDir != nil {
+		r.MustRegister(targetDirCollector(sources.TargetDir))
+	}
+
+	return r
+}
+
+// targetDirCollector walks target_dir on every scrape
+type targetDirCollector func() (int, int64, error)
+
+func (c targetDirCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- targetDirSizeDesc
+	ch <- targetDirFilesDesc
+}
+
+func (c targetDirCollector) Collect(ch chan<- prometheus.Metric) {
+	count, size, err := c()
+	if err != nil {
+		glg.Warnf("Unable to collect target_dir metrics: %v", err)
+		ch <- prometheus.NewInvalidMetric(targetDirSizeDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(targetDirSizeDesc, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(targetDirFilesDesc, prometheus.GaugeValue, float64(count))
+}
+
+func MotionRestarted() {
+	motionRestarts.Inc()
+}
+
+// WebControlRequest records a request to motion webcontrol, command is the request path without camera and query
+func WebControlRequest(path string, duration time.Duration, err error) {
+	command := strings.SplitN(strings.TrimPrefix(path, "/"), "?", 2)[0]
+
+	webControlDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		webControlErrors.WithLabelValues(command).Inc()
+	}
+}
+
+func StreamSent(bytes int) {
+	streamBytes.Add(float64(bytes))
+}
+
+func EventStarted(camera int) {
+	motionEvents.WithLabelValues(strconv.Itoa(camera)).Inc()
+}
+
+func PictureSaved(camera int) {
+	pictures.WithLabelValues(strconv.Itoa(camera)).Inc()
+}
+
+func NotifySent(method string, err error) {
+	if err != nil {
+		notifyFailures.WithLabelValues(method).Inc()
+	} else {
+		notifySent.WithLabelValues(method).Inc()
+	}
+}
+
+// BackupUploaded records a successful upload of files of target_dir, bytes is the size of the uploaded file
+func BackupUploaded(files int, bytes int64) {
+	backupFiles.Add(float64(files))
+	backupBytes.Add(float64(bytes))
+}
+
+// BackupRun records the end of a backup run
+func BackupRun(err error) {
+	backupRuns.Inc()
+
+	if err != nil {
+		backupFailures.Inc()
+	} else {
+		backupLastSuccess.SetToCurrentTime()
+	}
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T) string {
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body, err := ioutil.ReadAll(w.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	Init(Sources{
+		MotionUp:      func() bool { return true },
+		StreamViewers: func() int { return 3 },
+		TargetDir:     func() (int, int64, error) { return 2, 2048, nil },
+	})
+
+	WebControlRequest("/detection/status", 20*time.Millisecond, nil)
+	WebControlRequest("/config/set?threshold=1500", 10*time.Millisecond, fmt.Errorf("timeout"))
+	EventStarted(1)
+	PictureSaved(1)
+	PictureSaved(1)
+	NotifySent("telegram", nil)
+	NotifySent("telegram", fmt.Errorf("unauthorized"))
+	BackupUploaded(2, 4096)
+	BackupRun(nil)
+	StreamSent(100)
+
+	body := scrape(t)
+
+	require.Contains(t, body, "motionctrl_motion_up 1")
+	require.Contains(t, body, "motionctrl_stream_viewers 3")
+	require.Contains(t, body, "motionctrl_stream_bytes_total 100")
+	require.Contains(t, body, "motionctrl_target_dir_files 2")
+	require.Contains(t, body, "motionctrl_target_dir_size_bytes 2048")
+	require.Contains(t, body, `motionctrl_webcontrol_request_duration_seconds_count{command="detection/status"} 1`)
+	require.Contains(t, body, `motionctrl_webcontrol_errors_total{command="config/set"} 1`)
+	require.Contains(t, body, `motionctrl_events_total{camera="1"} 1`)
+	require.Contains(t, body, `motionctrl_pictures_total{camera="1"} 2`)
+	require.Contains(t, body, `motionctrl_notify_sent_total{method="telegram"} 1`)
+	require.Contains(t, body, `motionctrl_notify_failures_total{method="telegram"} 1`)
+	require.Contains(t, body, "motionctrl_backup_files_total 2")
+	require.Contains(t, body, "motionctrl_backup_bytes_total 4096")
+	require.Contains(t, body, "motionctrl_backup_runs_total 1")
+	require.NotContains(t, body, "motionctrl_backup_last_success_timestamp_seconds 0\n")
+
+	//Target dir errors don't break the scrape
+	Init(Sources{TargetDir: func() (int, int64, error) { return 0, 0, fmt.Errorf("not found") }})
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.NotEqual(t, 0, w.Code)
+}
@@ -2,37 +2,65 @@ package motion
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/andreacioni/motionctrl/utils"
+	"github.com/kpango/glg"
 )
 
-func Snapshot() (string, error) {
+const (
+	snapshotPollInterval = 100 * time.Millisecond
+)
+
+// Snapshot asks motion to take a snapshot and waits, up to timeout, until the new picture
+// is completely written. Path of the generated file is returned
+func Snapshot(timeout time.Duration) (string, error) {
+	snapType, err := ConfigGet(ConfigPictureType)
+
+	if err != nil {
+		return "", fmt.Errorf("unable to get '%s': %v", ConfigPictureType, err)
+	}
 
-	snapExt, err := ConfigGet(ConfigPictureType)
+	lastSnap := filepath.Join(readOnlyConfig[ConfigTargetDir], "lastsnap."+snapshotExtension(snapType))
 
-	//TODO snapshot file not ready when function return. This cause some 404
-	snapFile, err := webControlGet("/action/snapshot", func(body string) (interface{}, error) {
+	//lastsnap is usually a symbolic link to the real snapshot file, remember where it points now
+	previous, _ := filepath.EvalSymlinks(lastSnap)
+	requested := time.Now()
+
+	_, err = webControlGet("/action/snapshot", func(body string) (interface{}, error) {
 		if !utils.RegexMustMatch(SnapshotDetectionRegex, body) {
-			return "", fmt.Errorf("unable to take snapshot (%s)", body)
+			return nil, fmt.Errorf("unable to take snapshot (%s)", body)
 		}
+		return nil, nil
+	})
 
-		if targetDir, err := ConfigGet(ConfigTargetDir); err == nil {
-			switch snapExt {
-			case "ppm":
-				return filepath.Join(targetDir.(string), "lastsnap.ppm"), nil
-			case "webp":
-				return filepath.Join(targetDir.(string), "lastsnap.webp"), nil
-			default:
-				return filepath.Join(targetDir.(string), "lastsnap.jpg"), nil
+	if err != nil {
+		return "", err
+	}
+
+	return waitSnapshot(lastSnap, previous, requested, timeout)
+}
+
+// RemoveSnapshot removes a snapshot file and the lastsnap link, if it points to it
+func RemoveSnapshot(snapFile string) error {
+	for _, ext := range []string{"jpg", "webp", "ppm"} {
+		lastSnap := filepath.Join(readOnlyConfig[ConfigTargetDir], "lastsnap."+ext)
+
+		if target, err := filepath.EvalSymlinks(lastSnap); err == nil && target == snapFile && lastSnap != snapFile {
+			if err := os.Remove(lastSnap); err != nil {
+				glg.Warnf("Unable to remove %s: %v", lastSnap, err)
 			}
-		} else {
-			return nil, err
 		}
+	}
 
-	})
+	if err := os.Remove(snapFile); err != nil {
+		return fmt.Errorf("Unable to remove %s: %v", snapFile, err)
+	}
 
-	return snapFile.(string), err
+	return nil
 }
 
 func MakeMovie() error {
@@ -45,3 +73,36 @@ func MakeMovie() error {
 
 	return err
 }
+
+func snapshotExtension(pictureType interface{}) string {
+	switch pictureType {
+	case "ppm":
+		return "ppm"
+	case "webp":
+		return "webp"
+	default:
+		return "jpg"
+	}
+}
+
+// waitSnapshot polls lastSnap until it points to a complete picture newer than the request
+func waitSnapshot(lastSnap string, previous string, requested time.Time, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if snapFile, err := filepath.EvalSymlinks(lastSnap); err == nil {
+			if info, err := os.Stat(snapFile); err == nil && (snapFile != previous || info.ModTime().After(requested)) {
+				if data, err := ioutil.ReadFile(snapFile); err == nil && utils.IsCompleteImage(data, filepath.Ext(snapFile)) {
+					glg.Debugf("Snapshot ready: %s", snapFile)
+					return snapFile, nil
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("snapshot not ready after %v", timeout)
+		}
+
+		time.Sleep(snapshotPollInterval)
+	}
+}
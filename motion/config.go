@@ -3,7 +3,9 @@ package motion
 import (
 	"bufio"
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -36,6 +38,10 @@ const (
 	ConfigTargetDir                = "target_dir"
 
 	ConfigPictureType = "picture_type"
+	ConfigWidth       = "width"
+	ConfigHeight      = "height"
+	ConfigMaskFile    = "mask_file"
+	ConfigMaskPrivacy = "mask_privacy"
 )
 
 var (
@@ -139,10 +145,11 @@ func ConfigCanSet(name string) bool {
 	return !b
 }
 
+// ConfigSet changes a parameter of the running camera, value is escaped (e.g. paths with spaces)
 func ConfigSet(name string, value string) error {
-	queryURL := fmt.Sprintf("/config/set?%s=%s", name, value)
+	queryURL := fmt.Sprintf("/config/set?%s=%s", url.QueryEscape(name), url.QueryEscape(value))
 	_, err := webControlGet(queryURL, func(body string) (interface{}, error) {
-		if !utils.RegexMustMatch(fmt.Sprintf(setConfigParserRegex, name, value), body) {
+		if !utils.RegexMustMatch(fmt.Sprintf(setConfigParserRegex, regexp.QuoteMeta(name), regexp.QuoteMeta(value)), body) {
 			return nil, fmt.Errorf("there was an error on setting '%s' parameter", name)
 		}
 
@@ -11,6 +11,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/metrics"
 	"github.com/andreacioni/motionctrl/utils"
 
 	"github.com/kpango/glg"
@@ -39,6 +41,8 @@ func Startup(motionDetectionStartup bool) error {
 			if err = startMotion(motionDetectionStartup); err != nil {
 				return err
 			}
+
+			events.Publish(events.TypeMotionStarted, 0, "", map[string]interface{}{"detection": motionDetectionStartup})
 		} else {
 			glg.Warn("motion is already started")
 		}
@@ -60,7 +64,9 @@ func Shutdown() error {
 
 	if started, err = checkStarted(); err == nil {
 		if started {
-			err = stopMotion()
+			if err = stopMotion(); err == nil {
+				events.Publish(events.TypeMotionStopped, 0, "", nil)
+			}
 		} else {
 			glg.Warn("motion is already stopped")
 		}
@@ -86,7 +92,10 @@ func Restart() error { //TODO started consistency isn't guaranteed
 			if err == nil {
 				err = stopMotion()
 				if err == nil {
-					err = startMotion(detection)
+					if err = startMotion(detection); err == nil {
+						events.Publish(events.TypeMotionRestarted, 0, "", map[string]interface{}{"detection": detection})
+						metrics.MotionRestarted()
+					}
 				}
 			}
 
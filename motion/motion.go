@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"net/http"
 	"os/exec"
+	"time"
 
 	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/metrics"
 	"github.com/andreacioni/motionctrl/version"
 	"github.com/kpango/glg"
 	"github.com/parnurzeal/gorequest"
@@ -46,6 +48,11 @@ func Init(configFile string, autostart bool, detection bool) error {
 	return nil
 }
 
+// ConfigFile is the path of motion configuration file
+func ConfigFile() string {
+	return motionConfigFile
+}
+
 func GetStreamBaseURL() string {
 	return fmt.Sprintf("http://%s:%s", config.BaseAddress, readOnlyConfig[ConfigStreamPort])
 }
@@ -71,6 +78,9 @@ func webControlGet(path string, callback func(string) (interface{}, error)) (int
 	var err error
 	var ret interface{}
 
+	start := time.Now()
+	defer func() { metrics.WebControlRequest(path, time.Since(start), err) }()
+
 	resp, body, errs := gorequest.New().Get(GetBaseURL() + "/0" + path).End()
 
 	if errs == nil {
@@ -3,7 +3,13 @@ package motion
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/andreacioni/motionctrl/utils"
 
@@ -186,3 +192,266 @@ func TestWaitLiveRegex(t *testing.T) {
 
 	require.True(t, utils.RegexMustMatch(waitLiveRegex, text))
 }
+
+func TestWaitSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lastSnap := filepath.Join(dir, "lastsnap.jpg")
+	oldSnap := filepath.Join(dir, "01-20180314152202-snapshot.jpg")
+	newSnap := filepath.Join(dir, "01-20180314152210-snapshot.jpg")
+
+	require.NoError(t, ioutil.WriteFile(oldSnap, []byte{0xFF, 0xD8, 0x00, 0x00, 0xFF, 0xD9}, 0666))
+	require.NoError(t, os.Symlink(oldSnap, lastSnap))
+
+	requested := time.Now()
+
+	//Old snapshot is never returned
+	_, err = waitSnapshot(lastSnap, oldSnap, requested, 200*time.Millisecond)
+	require.Error(t, err)
+
+	//New snapshot is returned only when complete
+	require.NoError(t, ioutil.WriteFile(newSnap, []byte{0xFF, 0xD8, 0x00, 0x00}, 0666))
+	require.NoError(t, os.Remove(lastSnap))
+	require.NoError(t, os.Symlink(newSnap, lastSnap))
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ioutil.WriteFile(newSnap, []byte{0xFF, 0xD8, 0x00, 0x00, 0xFF, 0xD9}, 0666)
+	}()
+
+	snapFile, err := waitSnapshot(lastSnap, oldSnap, requested, 2*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, newSnap, snapFile)
+}
+
+func TestSnapshotExtension(t *testing.T) {
+	require.Equal(t, "jpg", snapshotExtension("jpeg"))
+	require.Equal(t, "webp", snapshotExtension("webp"))
+	require.Equal(t, "ppm", snapshotExtension("ppm"))
+	require.Equal(t, "jpg", snapshotExtension(nil))
+}
+
+func TestListTargetDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targetdir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)
+	files := []string{"01-20180314150000-01.jpg", "2018/03/14/01-20180314150100-01.jpg", "2018/03/14/01-20180314150000.mkv", "2018/03/14/notes.txt", ".thumbnails/thumb.jpg"}
+
+	for i, name := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, 10*(i+1)), 0666))
+
+		mtime := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	require.NoError(t, os.Symlink(filepath.Join(dir, files[0]), filepath.Join(dir, "lastsnap.jpg")))
+
+	list := func(q TargetDirQuery) TargetDirPage {
+		require.NoError(t, q.Validate())
+		page, err := listTargetDir(dir, q)
+		require.NoError(t, err)
+		return page
+	}
+
+	//Subfolders included, hidden ones and symlinks excluded
+	page := list(TargetDirQuery{})
+	require.Equal(t, 4, page.Total)
+	require.Equal(t, int64(10+20+30+40), page.TotalSize)
+	require.Equal(t, files[0], page.Files[0].Name)
+	require.Equal(t, FileTypePicture, page.Files[0].Type)
+	require.Equal(t, "image/jpeg", page.Files[0].MimeType)
+	require.Equal(t, int64(10), page.Files[0].Size)
+
+	//Filters
+	require.Equal(t, 2, list(TargetDirQuery{Type: FileTypePicture}).Total)
+	require.Equal(t, 1, list(TargetDirQuery{Extensions: []string{"MKV"}}).Total)
+	require.Equal(t, 3, list(TargetDirQuery{Extensions: []string{"jpg", ".mkv"}}).Total)
+	require.Equal(t, 2, list(TargetDirQuery{Glob: "01-*-01.jpg"}).Total)
+	require.Equal(t, 2, list(TargetDirQuery{From: start.Add(time.Minute), To: start.Add(2 * time.Minute)}).Total)
+
+	//Sort
+	page = list(TargetDirQuery{Sort: SortBySize, Desc: true})
+	require.Equal(t, files[3], page.Files[0].Name)
+
+	//Offset
+	page = list(TargetDirQuery{Sort: SortByTime, Offset: 1, Limit: 2})
+	require.Equal(t, 4, page.Total)
+	require.Equal(t, []string{files[1], files[2]}, []string{page.Files[0].Name, page.Files[1].Name})
+	require.NotEmpty(t, page.NextCursor)
+
+	//Cursor
+	page = list(TargetDirQuery{Sort: SortByTime, Limit: 3})
+	require.Len(t, page.Files, 3)
+
+	page = list(TargetDirQuery{Sort: SortByTime, Limit: 3, Cursor: page.NextCursor})
+	require.Len(t, page.Files, 1)
+	require.Equal(t, files[3], page.Files[0].Name)
+	require.Equal(t, 3, page.Offset)
+	require.Empty(t, page.NextCursor)
+
+	//Invalid queries
+	require.Error(t, (&TargetDirQuery{Sort: "date"}).Validate())
+	require.Error(t, (&TargetDirQuery{Type: "audio"}).Validate())
+	require.Error(t, (&TargetDirQuery{Glob: "["}).Validate())
+	require.Error(t, (&TargetDirQuery{Limit: MaxListLimit + 1}).Validate())
+	require.Error(t, (&TargetDirQuery{Cursor: "invalid"}).Validate())
+	require.Error(t, (&TargetDirQuery{Sort: SortBySize, Cursor: encodeCursor(listCursor{Sort: SortByTime})}).Validate())
+}
+
+func TestResolveTargetDir(t *testing.T) {
+	base, err := ioutil.TempDir("", "resolve")
+	require.NoError(t, err)
+	defer os.RemoveAll(base)
+
+	root := filepath.Join(base, "target")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "2018", "03"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".thumbnails"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "01.jpg"), []byte{}, 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "2018", "03", "02.jpg"), []byte{}, 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, ".thumbnails", "thumb.jpg"), []byte{}, 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(base, "secret.txt"), []byte{}, 0666))
+
+	require.NoError(t, os.Symlink(filepath.Join(root, "01.jpg"), filepath.Join(root, "lastsnap.jpg")))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.txt"), filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(base, filepath.Join(root, "escape")))
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	require.NoError(t, err)
+
+	//Allowed
+	for name, expected := range map[string]string{
+		"01.jpg":         "01.jpg",
+		"/01.jpg":        "01.jpg",
+		"2018/03/02.jpg": filepath.Join("2018", "03", "02.jpg"),
+		"lastsnap.jpg":   "01.jpg",
+	} {
+		_, realPath, err := resolveTargetDir(root, name)
+		require.NoError(t, err, name)
+		require.Equal(t, filepath.Join(realRoot, expected), realPath, name)
+	}
+
+	//Traversal attempts
+	for _, name := range []string{
+		"",
+		"/",
+		"../secret.txt",
+		"2018/../../secret.txt",
+		"2018/03/../../../secret.txt",
+		"..",
+		"./01.jpg",
+		"2018//03/02.jpg",
+		"..\\secret.txt",
+		"01.jpg\x00",
+		".thumbnails/thumb.jpg",
+		"escape.txt",
+		"escape/secret.txt",
+	} {
+		_, _, err := resolveTargetDir(root, name)
+		require.Equal(t, ErrInvalidPath, err, name)
+	}
+
+	_, _, err = resolveTargetDir(root, "missing.jpg")
+	require.True(t, os.IsNotExist(err))
+
+	_, _, err = resolveTargetDir("", "01.jpg")
+	require.Error(t, err)
+}
+
+// trackReplies are webcontrol replies of motion 4.1 (text output) for a camera with generic tracking
+var trackReplies = map[string]string{
+	"/0/config/list":               "Camera 0\nthreshold = 1500\ntrack_type = 4\ntrack_auto = off\ntrack_minx = -100\ntrack_maxx = 100\ntrack_miny = -50\ntrack_maxy = 50\n",
+	"/0/track/status":              "Camera 0 Track auto disabled\nDone\n",
+	"/0/track/center":              "Camera 0 Track center\nDone\n",
+	"/0/track/set?x=10&y=-20":      "Camera 0 Track set absolute x=10 y=-20\nDone\n",
+	"/0/track/set?pan=-15&tilt=30": "Camera 0 Track pan=-15 tilt=30\nDone\n",
+	"/0/track/set?pan=0&tilt=0":    "Camera 0 Track set\nError\n",
+}
+
+func TestTrack(t *testing.T) {
+	replies := map[string]string{}
+	for k, v := range trackReplies {
+		replies[k] = v
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reply, ok := replies[r.URL.RequestURI()]; ok {
+			fmt.Fprint(w, reply)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	previous := readOnlyConfig
+	readOnlyConfig = map[string]string{ConfigWebControlPort: port}
+	defer func() { readOnlyConfig = previous }()
+
+	status, err := GetTrackStatus()
+	require.NoError(t, err)
+	require.Equal(t, TrackStatus{Auto: false, Type: 4, Limits: TrackLimits{MinX: -100, MaxX: 100, MinY: -50, MaxY: 50}}, status)
+
+	require.NoError(t, TrackCenter())
+	require.NoError(t, TrackSet(10, -20))
+	require.NoError(t, TrackMove(-15, 30))
+
+	//Errors reported by motion
+	require.Error(t, TrackMove(0, 0))
+
+	//Out of range requests aren't sent to motion
+	err = TrackSet(101, 0)
+	require.IsType(t, &TrackRangeError{}, err)
+	require.EqualError(t, err, "x must be between -100 and 100 (got 101)")
+	require.IsType(t, &TrackRangeError{}, TrackSet(0, -51))
+	require.IsType(t, &TrackRangeError{}, TrackMove(201, 0))
+	require.IsType(t, &TrackRangeError{}, TrackMove(0, -101))
+
+	//Cameras without tracking
+	replies["/0/config/list"] = "Camera 0\nthreshold = 1500\ntrack_type = 0\n"
+	_, err = GetTrackStatus()
+	require.Equal(t, ErrTrackingDisabled, err)
+	require.Equal(t, ErrTrackingDisabled, TrackCenter())
+}
+
+func TestTrackStatusRegex(t *testing.T) {
+	enabled, err := parseTrackStatus("Camera 1 Track auto enabled\nDone\n")
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	//motion before 4.1 called cameras threads
+	enabled, err = parseTrackStatus("Thread 0 Track auto disabled\nDone\n")
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	_, err = parseTrackStatus("Camera 0 Track status\nError\n")
+	require.Error(t, err)
+}
+
+func TestConfigSetEscaped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() == "/0/config/set?mask_file=%2Fetc%2Fmotion+%281%29%2Fmask.pgm" {
+			fmt.Fprintf(w, "Camera 0\nmask_file = %s\nDone\n", r.URL.Query().Get("mask_file"))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+
+	previous := readOnlyConfig
+	readOnlyConfig = map[string]string{ConfigWebControlPort: port}
+	defer func() { readOnlyConfig = previous }()
+
+	require.NoError(t, ConfigSet(ConfigMaskFile, "/etc/motion (1)/mask.pgm"))
+	require.Error(t, ConfigSet(ConfigMaskFile, "/etc/motion/mask.pgm"))
+}
@@ -1,49 +1,432 @@
 package motion
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/andreacioni/motionctrl/utils"
 )
 
+const (
+	SortByName = "name"
+	SortByTime = "time"
+	SortBySize = "size"
+
+	FileTypePicture = "picture"
+	FileTypeMovie   = "movie"
+	FileTypeOther   = "other"
+
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
 type TargetDirFile struct {
-	Name         string    `json:"name"`
-	CreationTime time.Time `json:"creationDate"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Type     string    `json:"type"`
+	MimeType string    `json:"mimeType"`
 }
 
-func TargetDirListFiles() ([]TargetDirFile, error) {
-	fileInfo, _, _, err := utils.ListFiles(readOnlyConfig[ConfigTargetDir], nil)
+// TargetDirQuery selects, sorts and paginates files in target_dir. Zero values mean no filter
+type TargetDirQuery struct {
+	From       time.Time
+	To         time.Time
+	Extensions []string
+	Type       string
+	Glob       string
+	Sort       string
+	Desc       bool
+	Offset     int
+	Limit      int
+	Cursor     string
+}
 
-	var files []TargetDirFile
+type TargetDirPage struct {
+	Files      []TargetDirFile `json:"files"`
+	Total      int             `json:"total"`
+	TotalSize  int64           `json:"totalSize"`
+	Offset     int             `json:"offset"`
+	NextCursor string          `json:"nextCursor,omitempty"`
+}
+
+// listCursor points to the last file of a page, next page starts right after it even if files were added or removed meanwhile
+type listCursor struct {
+	Sort    string    `json:"s"`
+	Desc    bool      `json:"d"`
+	Name    string    `json:"n"`
+	Size    int64     `json:"z"`
+	ModTime time.Time `json:"t"`
+}
+
+// Validate checks the query and fills default values
+func (q *TargetDirQuery) Validate() error {
+	if q.Sort == "" {
+		q.Sort = SortByName
+	}
+
+	if q.Sort != SortByName && q.Sort != SortByTime && q.Sort != SortBySize {
+		return fmt.Errorf("sort must be one of: %s, %s, %s", SortByName, SortByTime, SortBySize)
+	}
+
+	if q.Type != "" && q.Type != FileTypePicture && q.Type != FileTypeMovie && q.Type != FileTypeOther {
+		return fmt.Errorf("type must be one of: %s, %s, %s", FileTypePicture, FileTypeMovie, FileTypeOther)
+	}
+
+	if _, err := filepath.Match(q.Glob, ""); err != nil {
+		return fmt.Errorf("invalid glob: %s", q.Glob)
+	}
+
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return fmt.Errorf("'to' must be after 'from'")
+	}
+
+	if q.Offset < 0 {
+		return fmt.Errorf("offset must be positive")
+	}
+
+	if q.Offset > 0 && q.Cursor != "" {
+		return fmt.Errorf("offset and cursor can't be used together")
+	}
+
+	if q.Limit == 0 {
+		q.Limit = DefaultListLimit
+	}
+
+	if q.Limit < 0 || q.Limit > MaxListLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	}
+
+	for i, ext := range q.Extensions {
+		q.Extensions[i] = "." + strings.TrimPrefix(strings.ToLower(ext), ".")
+	}
+
+	if q.Cursor != "" {
+		if _, err := q.decodeCursor(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TargetDirList returns files in target_dir and its subfolders (hidden ones excluded) matching the query
+func TargetDirList(q TargetDirQuery) (TargetDirPage, error) {
+	if err := q.Validate(); err != nil {
+		return TargetDirPage{}, err
+	}
 
-	for _, f := range fileInfo {
-		files = append(files, TargetDirFile{Name: f.Name(), CreationTime: f.ModTime()})
+	return listTargetDir(readOnlyConfig[ConfigTargetDir], q)
+}
+
+// TargetDirSelect returns every file matching the query, sorted, offset and limit are ignored
+func TargetDirSelect(q TargetDirQuery) ([]TargetDirFile, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
 	}
 
-	return files, err
+	return selectTargetDir(readOnlyConfig[ConfigTargetDir], q)
 }
 
 func TargetDirSize() (int64, error) {
-	_, _, size, err := utils.ListFiles(readOnlyConfig[ConfigTargetDir], nil)
+	_, size, err := TargetDirCount()
+	return size, err
+}
+
+// TargetDirCount returns number and overall size of files in target directory
+func TargetDirCount() (int, int64, error) {
+	var count int
+	var size int64
+
+	err := WalkTargetDir(readOnlyConfig[ConfigTargetDir], func(file TargetDirFile) {
+		count++
+		size += file.Size
+	})
 
 	if err != nil {
-		return -1, fmt.Errorf("Unable to evaluate size of target directory: %v", err)
+		return -1, -1, fmt.Errorf("Unable to evaluate size of target directory: %v", err)
 	}
 
-	return size, err
+	return count, size, err
+}
+
+// listTargetDir expects a validated query
+func listTargetDir(root string, q TargetDirQuery) (TargetDirPage, error) {
+	page := TargetDirPage{Files: []TargetDirFile{}}
+
+	files, err := selectTargetDir(root, q)
+	if err != nil {
+		return page, err
+	}
+
+	for _, file := range files {
+		page.TotalSize += file.Size
+	}
+
+	page.Total = len(files)
+
+	start := q.Offset
+	if q.Cursor != "" {
+		cursor, _ := q.decodeCursor()
+		last := TargetDirFile{Name: cursor.Name, Size: cursor.Size, ModTime: cursor.ModTime}
+		start = sort.Search(len(files), func(i int) bool { return q.less(last, files[i]) })
+	}
+
+	if start > len(files) {
+		start = len(files)
+	}
+
+	end := start + q.Limit
+	if end > len(files) {
+		end = len(files)
+	}
+
+	page.Offset = start
+	page.Files = append(page.Files, files[start:end]...)
+
+	if end < len(files) && end > start {
+		last := files[end-1]
+		page.NextCursor = encodeCursor(listCursor{Sort: q.Sort, Desc: q.Desc, Name: last.Name, Size: last.Size, ModTime: last.ModTime})
+	}
+
+	return page, nil
+}
+
+func selectTargetDir(root string, q TargetDirQuery) ([]TargetDirFile, error) {
+	var files []TargetDirFile
+
+	err := WalkTargetDir(root, func(file TargetDirFile) {
+		if q.matches(file) {
+			files = append(files, file)
+		}
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list files in target directory: %v", err)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return q.less(files[i], files[j]) })
+
+	return files, nil
+}
+
+// WalkTargetDir calls fn for every regular file in root and its subfolders, hidden files and folders
+// (e.g. time-lapses, thumbnails and motionctrl state) are skipped. Services reading target_dir use it to see the same files
+func WalkTargetDir(root string, fn func(TargetDirFile)) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		mimeType := utils.MimeType(path)
+
+		fn(TargetDirFile{
+			Name:     filepath.ToSlash(rel),
+			Size:     info.Size(),
+			ModTime:  info.ModTime(),
+			Type:     FileType(mimeType),
+			MimeType: mimeType,
+		})
+
+		return nil
+	})
+}
+
+// FileType classifies a file of target_dir as picture, movie or other from its MIME type
+func FileType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return FileTypePicture
+	case strings.HasPrefix(mimeType, "video/"), mimeType == "application/x-shockwave-flash":
+		return FileTypeMovie
+	default:
+		return FileTypeOther
+	}
+}
+
+func (q TargetDirQuery) matches(file TargetDirFile) bool {
+	if (!q.From.IsZero() && file.ModTime.Before(q.From)) || (!q.To.IsZero() && file.ModTime.After(q.To)) {
+		return false
+	}
+
+	if q.Type != "" && file.Type != q.Type {
+		return false
+	}
+
+	if len(q.Extensions) > 0 {
+		found := false
+		for _, ext := range q.Extensions {
+			if strings.ToLower(filepath.Ext(file.Name)) == ext {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if q.Glob != "" {
+		if match, _ := filepath.Match(q.Glob, path.Base(file.Name)); !match {
+			return false
+		}
+	}
+
+	return true
+}
+
+// less orders files by the query sort field, name breaks ties so the order is always total
+func (q TargetDirQuery) less(a, b TargetDirFile) bool {
+	if q.Desc {
+		a, b = b, a
+	}
+
+	switch q.Sort {
+	case SortByTime:
+		if !a.ModTime.Equal(b.ModTime) {
+			return a.ModTime.Before(b.ModTime)
+		}
+	case SortBySize:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	}
+
+	return a.Name < b.Name
+}
+
+func encodeCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func (q TargetDirQuery) decodeCursor() (listCursor, error) {
+	var c listCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err == nil {
+		err = json.Unmarshal(raw, &c)
+	}
+
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return c, fmt.Errorf("cursor was created with a different sort order")
+	}
+
+	return c, nil
 }
 
+var (
+	// ErrInvalidPath is returned when a file name points outside of target_dir or to a hidden file
+	ErrInvalidPath = fmt.Errorf("invalid path")
+)
+
+// TargetDirGetFile returns the real path of filename, a slash separated path relative to target_dir
 func TargetDirGetFile(filename string) (string, error) {
-	return filepath.Join(readOnlyConfig[ConfigTargetDir], filename), nil
+	_, realPath, err := resolveTargetDir(readOnlyConfig[ConfigTargetDir], filename)
+	if err != nil {
+		return "", err
+	}
+
+	if info, err := os.Stat(realPath); err != nil {
+		return "", err
+	} else if info.IsDir() {
+		return "", ErrInvalidPath
+	}
+
+	return realPath, nil
 }
 
+// TargetDirRelPath returns path relative to target_dir, path is returned as is when outside of it
+func TargetDirRelPath(path string) string {
+	if rel, err := filepath.Rel(readOnlyConfig[ConfigTargetDir], path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return path
+}
+
+// TargetDirRemoveFile removes filename, a slash separated path relative to target_dir. Symbolic links are removed, not their target
 func TargetDirRemoveFile(filename string) error {
-	if err := os.Remove(filepath.Join(readOnlyConfig[ConfigTargetDir], filename)); err != nil {
+	path, _, err := resolveTargetDir(readOnlyConfig[ConfigTargetDir], filename)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(path); err != nil {
+		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("%s is a directory", filename)
+	}
+
+	if err := os.Remove(path); err != nil {
 		return fmt.Errorf("Unable to remove %s: %v", filename, err)
 	}
 
 	return nil
 }
+
+// resolveTargetDir returns path and real path (symbolic links evaluated) of name inside root.
+// Names containing '..' or hidden elements and files that resolve outside of root are rejected with ErrInvalidPath
+func resolveTargetDir(root, name string) (string, string, error) {
+	if root == "" {
+		return "", "", fmt.Errorf("target_dir is not configured")
+	}
+
+	name = strings.TrimPrefix(name, "/")
+
+	if name == "" || strings.ContainsAny(name, "\\\x00") {
+		return "", "", ErrInvalidPath
+	}
+
+	for _, element := range strings.Split(name, "/") {
+		if element == "" || element == "." || strings.HasPrefix(element, ".") {
+			return "", "", ErrInvalidPath
+		}
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", err
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(name))
+
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if rel, err := filepath.Rel(realRoot, realPath); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", ErrInvalidPath
+	}
+
+	return path, realPath, nil
+}
@@ -0,0 +1,177 @@
+package motion
+
+import (
+	"fmt"
+
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	TrackStatusRegex = "(?:Camera|Thread) [0-9]+ Track auto (enabled|disabled)"
+	TrackCenterRegex = "(?:Camera|Thread) [0-9]+ Track center\\s*\nDone"
+	TrackSetRegex    = "(?:Camera|Thread) [0-9]+ Track (?:set absolute|pan)[^\n]*\nDone"
+
+	ConfigTrackType = "track_type"
+	ConfigTrackMinX = "track_minx"
+	ConfigTrackMaxX = "track_maxx"
+	ConfigTrackMinY = "track_miny"
+	ConfigTrackMaxY = "track_maxy"
+)
+
+var ErrTrackingDisabled = fmt.Errorf("tracking not enabled ('%s' is 0)", ConfigTrackType)
+
+// TrackLimits are the range of absolute positions of the camera (track_minx..track_maxx, track_miny..track_maxy),
+// relative movements can't be wider than the range. Zero values mean no limit
+type TrackLimits struct {
+	MinX int `json:"minX"`
+	MaxX int `json:"maxX"`
+	MinY int `json:"minY"`
+	MaxY int `json:"maxY"`
+}
+
+type TrackStatus struct {
+	Auto   bool        `json:"auto"`
+	Type   int         `json:"type"`
+	Limits TrackLimits `json:"limits"`
+}
+
+// TrackRangeError is returned when a position or a movement is out of the limits of the camera
+type TrackRangeError struct {
+	Axis     string
+	Value    int
+	Min, Max int
+}
+
+func (e *TrackRangeError) Error() string {
+	return fmt.Sprintf("%s must be between %d and %d (got %d)", e.Axis, e.Min, e.Max, e.Value)
+}
+
+func GetTrackStatus() (TrackStatus, error) {
+	status, err := trackConfig()
+	if err != nil {
+		return TrackStatus{}, err
+	}
+
+	ret, err := webControlGet("/track/status", func(body string) (interface{}, error) {
+		return parseTrackStatus(body)
+	})
+
+	if err != nil {
+		return TrackStatus{}, err
+	}
+
+	status.Auto = ret.(bool)
+
+	return status, nil
+}
+
+// TrackCenter moves the camera to its home position
+func TrackCenter() error {
+	if _, err := trackConfig(); err != nil {
+		return err
+	}
+
+	_, err := webControlGet("/track/center", func(body string) (interface{}, error) {
+		if !utils.RegexMustMatch(TrackCenterRegex, body) {
+			return nil, fmt.Errorf("unable to center camera (%s)", body)
+		}
+		return nil, nil
+	})
+
+	return err
+}
+
+// TrackSet moves the camera to the absolute position x, y
+func TrackSet(x, y int) error {
+	status, err := trackConfig()
+	if err != nil {
+		return err
+	}
+
+	l := status.Limits
+	if err := checkTrackRange("x", x, l.MinX, l.MaxX); err != nil {
+		return err
+	}
+	if err := checkTrackRange("y", y, l.MinY, l.MaxY); err != nil {
+		return err
+	}
+
+	return trackSet(fmt.Sprintf("/track/set?x=%d&y=%d", x, y))
+}
+
+// TrackMove pans and tilts the camera by the given steps, relative to its position
+func TrackMove(pan, tilt int) error {
+	status, err := trackConfig()
+	if err != nil {
+		return err
+	}
+
+	l := status.Limits
+	if err := checkTrackRange("pan", pan, l.MinX-l.MaxX, l.MaxX-l.MinX); err != nil {
+		return err
+	}
+	if err := checkTrackRange("tilt", tilt, l.MinY-l.MaxY, l.MaxY-l.MinY); err != nil {
+		return err
+	}
+
+	return trackSet(fmt.Sprintf("/track/set?pan=%d&tilt=%d", pan, tilt))
+}
+
+func trackSet(path string) error {
+	_, err := webControlGet(path, func(body string) (interface{}, error) {
+		if !utils.RegexMustMatch(TrackSetRegex, body) {
+			return nil, fmt.Errorf("unable to move camera (%s)", body)
+		}
+		return nil, nil
+	})
+
+	return err
+}
+
+// trackConfig returns type and limits of tracking, ErrTrackingDisabled if the camera doesn't support it
+func trackConfig() (TrackStatus, error) {
+	conf, err := ConfigList()
+	if err != nil {
+		return TrackStatus{}, err
+	}
+
+	status := TrackStatus{
+		Type: intConfig(conf, ConfigTrackType),
+		Limits: TrackLimits{
+			MinX: intConfig(conf, ConfigTrackMinX),
+			MaxX: intConfig(conf, ConfigTrackMaxX),
+			MinY: intConfig(conf, ConfigTrackMinY),
+			MaxY: intConfig(conf, ConfigTrackMaxY),
+		},
+	}
+
+	if status.Type == 0 {
+		return TrackStatus{}, ErrTrackingDisabled
+	}
+
+	return status, nil
+}
+
+func parseTrackStatus(body string) (bool, error) {
+	switch status := utils.RegexFirstSubmatchString(TrackStatusRegex, body); status {
+	case "enabled":
+		return true, nil
+	case "disabled":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown track status string: %s", body)
+	}
+}
+
+// checkTrackRange accepts any value when min and max are equal (limits not configured)
+func checkTrackRange(axis string, value, min, max int) error {
+	if min != max && (value < min || value > max) {
+		return &TrackRangeError{Axis: axis, Value: value, Min: min, Max: max}
+	}
+	return nil
+}
+
+func intConfig(conf map[string]interface{}, name string) int {
+	value, _ := conf[name].(int)
+	return value
+}
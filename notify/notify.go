@@ -12,6 +12,10 @@ import (
 	"github.com/kpango/glg"
 
 	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/metrics"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/privacy"
 )
 
 type NotifyService interface {
@@ -98,10 +102,19 @@ func MotionDetectedStart() {
 	nMutex.Lock()
 	defer nMutex.Unlock()
 
+	if privacy.Enabled() {
+		glg.Debug("Privacy mode enabled, notify not sent")
+		return
+	}
+
 	if notifyService != nil {
 		if active {
 			resetPhotoSemaphore()
-			notifyService.Notify(notifyConfiguration.Message, "")
+			err := notifyService.Notify(notifyConfiguration.Message, "")
+			if err != nil {
+				glg.Errorf("Failed to send notify: %v", err)
+			}
+			publishOutcome("message", "", err)
 		} else {
 			glg.Warn("Notify service deactivated")
 		}
@@ -130,15 +143,22 @@ func PhotoSaved(filepath string) {
 	nMutex.Lock()
 	defer nMutex.Unlock()
 
+	if privacy.Enabled() {
+		glg.Debugf("Privacy mode enabled, picture not sent (%s)", filepath)
+		return
+	}
+
 	if notifyService != nil {
 		if active {
 			if photoLimitSemaphore != nil {
 				if photoLimitSemaphore.AcquireWithin(1, 10*time.Microsecond) { //TODO improve this
-					if err := notifyService.Notify("", filepath); err != nil {
+					err := notifyService.Notify("", filepath)
+					if err != nil {
 						glg.Errorf("Failed to send notify: %v", err)
 					} else {
 						glg.Debugf("Sent notify (image; %s)", filepath)
 					}
+					publishOutcome("photo", motion.TargetDirRelPath(filepath), err)
 				} else {
 					glg.Warnf("Photo limit reached, this one won't be sent")
 				}
@@ -155,6 +175,33 @@ func PhotoSaved(filepath string) {
 	}
 }
 
+// Alert sends message right away, even if notifications are deactivated: it is used for
+// problems that need attention (e.g. disk almost full) rather than for motion
+func Alert(message string) error {
+	nMutex.Lock()
+	defer nMutex.Unlock()
+
+	if notifyService == nil {
+		return fmt.Errorf("No notify service is available")
+	}
+
+	err := notifyService.Notify(message, "")
+	publishOutcome("alert", "", err)
+
+	return err
+}
+
+// publishOutcome lets event subscribers know if a notification has been delivered
+func publishOutcome(kind string, file string, err error) {
+	data := map[string]interface{}{"kind": kind, "method": notifyConfiguration.Method, "success": err == nil}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+
+	events.Publish(events.TypeNotifySent, 0, file, data)
+	metrics.NotifySent(notifyConfiguration.Method, err)
+}
+
 func IsReady() bool {
 	nMutex.Lock()
 	defer nMutex.Unlock()
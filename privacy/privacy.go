@@ -0,0 +1,391 @@
+package privacy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/robfig/cron"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	// DefaultFile is hidden, so it is skipped by backup and retention
+	DefaultFile = ".privacy.json"
+
+	// BySchedule is who changes the mode on 'privacy.on' and 'privacy.off'
+	BySchedule = "schedule"
+
+	placeholderWidth  = 640
+	placeholderHeight = 480
+)
+
+// placeholderColor fills the generated placeholder frame
+var placeholderColor = color.Gray{0x20}
+
+// State is persisted: privacy mode survives restarts
+type State struct {
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since,omitempty"`
+	By      string    `json:"by,omitempty"`
+	// DetectionPaused is true if detection has been paused by privacy mode, it is resumed when the mode is disabled
+	DetectionPaused bool `json:"detectionPaused"`
+}
+
+type Status struct {
+	State
+	PauseDetection bool       `json:"pauseDetection"`
+	On             string     `json:"on,omitempty"`
+	Off            string     `json:"off,omitempty"`
+	NextOn         *time.Time `json:"nextOn,omitempty"`
+	NextOff        *time.Time `json:"nextOff,omitempty"`
+}
+
+var (
+	pMutex       sync.Mutex
+	initialized  bool
+	state        State
+	stateFile    string
+	privacyConf  config.Privacy
+	onSchedule   cron.Schedule
+	offSchedule  cron.Schedule
+	cronSheduler *cron.Cron
+	subscription *events.Subscription
+	placeholder  []byte
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Init restores the last state, kept in 'privacy.file' or in target_dir, and schedules 'privacy.on' and 'privacy.off'
+func Init(conf config.Privacy, targetDir string) error {
+	pause, err := setup(conf, targetDir)
+	if err == nil && pause {
+		pauseDetection()
+	}
+
+	return err
+}
+
+// setup restores the state and returns true if detection must be paused, which is done without pMutex
+func setup(conf config.Privacy, targetDir string) (bool, error) {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	if initialized {
+		return false, fmt.Errorf("Privacy mode already initialized")
+	}
+
+	on, err := parseSchedule(conf.On, "privacy.on")
+	if err != nil {
+		return false, err
+	}
+
+	off, err := parseSchedule(conf.Off, "privacy.off")
+	if err != nil {
+		return false, err
+	}
+
+	var custom []byte
+	if conf.Placeholder != "" {
+		if custom, err = ioutil.ReadFile(conf.Placeholder); err != nil {
+			return false, fmt.Errorf("Unable to read 'privacy.placeholder': %v", err)
+		}
+
+		if !utils.IsCompleteImage(custom, ".jpg") {
+			return false, fmt.Errorf("'privacy.placeholder' must be a JPEG picture")
+		}
+	}
+
+	file := conf.File
+	if file == "" && targetDir != "" {
+		file = filepath.Join(targetDir, DefaultFile)
+	}
+
+	loaded := State{}
+	if file == "" {
+		glg.Warn("No 'privacy.file' or 'target_dir' defined, privacy mode won't survive restarts")
+	} else if data, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return false, fmt.Errorf("Unable to parse %s: %v", file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	scheduler := cron.New()
+	if on != nil {
+		scheduler.Schedule(on, cron.FuncJob(func() { scheduled(true) }))
+	}
+	if off != nil {
+		scheduler.Schedule(off, cron.FuncJob(func() { scheduled(false) }))
+	}
+	scheduler.Start()
+
+	initialized = true
+	state, stateFile, privacyConf = loaded, file, conf
+	onSchedule, offSchedule = on, off
+	cronSheduler = scheduler
+	placeholder = custom
+
+	//Detection is paused again when motion (re)starts
+	subscription = events.Subscribe(0, []events.Type{events.TypeMotionStarted, events.TypeMotionRestarted})
+	go listen(subscription)
+
+	if state.Enabled {
+		glg.Infof("Privacy mode enabled (since %s by %s)", state.Since, state.By)
+	}
+
+	return state.Enabled && privacyConf.PauseDetection && !state.DetectionPaused, nil
+}
+
+func Shutdown() {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	glg.Info("Shuting down privacy mode")
+
+	if cronSheduler != nil {
+		cronSheduler.Stop()
+		cronSheduler = nil
+	}
+
+	if subscription != nil {
+		events.Unsubscribe(subscription)
+		subscription = nil
+	}
+
+	initialized = false
+	state = State{}
+	placeholder = nil
+}
+
+// Enabled returns true if stream and snapshots must be replaced by the placeholder and notifications suppressed
+func Enabled() bool {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	return state.Enabled
+}
+
+func GetStatus() Status {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	status := Status{State: state, PauseDetection: privacyConf.PauseDetection, On: privacyConf.On, Off: privacyConf.Off}
+
+	t := now()
+	if onSchedule != nil {
+		next := onSchedule.Next(t)
+		status.NextOn = &next
+	}
+	if offSchedule != nil {
+		next := offSchedule.Next(t)
+		status.NextOff = &next
+	}
+
+	return status
+}
+
+// Set enables or disables privacy mode, by is who asked (user or BySchedule). Detection is paused and resumed if 'privacy.pauseDetection' is true
+func Set(enabled bool, by string) error {
+	pMutex.Lock()
+
+	if !initialized {
+		pMutex.Unlock()
+		return fmt.Errorf("Privacy mode not initialized")
+	}
+
+	if state.Enabled == enabled {
+		pMutex.Unlock()
+		return nil
+	}
+
+	state.Enabled, state.Since, state.By = enabled, now(), by
+
+	//motion is called once the state is changed and pMutex released: stream and snapshots don't wait for webcontrol
+	pause := enabled && privacyConf.PauseDetection && !state.DetectionPaused
+	resume := !enabled && state.DetectionPaused
+	state.DetectionPaused = false
+
+	err := save()
+	pMutex.Unlock()
+
+	glg.Infof("Privacy mode %s by %s", map[bool]string{true: "enabled", false: "disabled"}[enabled], by)
+
+	events.Publish(events.TypePrivacyMode, 0, "", map[string]interface{}{"enabled": enabled, "by": by})
+
+	if pause {
+		pauseDetection()
+	} else if resume {
+		resumeDetection()
+	}
+
+	return err
+}
+
+// KeepDetectionPaused returns true if privacy mode pauses detection: detection paused by someone else (e.g. the storage
+// monitor) must not be resumed, privacy mode resumes it when disabled
+func KeepDetectionPaused() bool {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	if !state.Enabled || !privacyConf.PauseDetection {
+		return false
+	}
+
+	if !state.DetectionPaused {
+		state.DetectionPaused = true
+		if err := save(); err != nil {
+			glg.Errorf("Unable to save privacy mode: %v", err)
+		}
+	}
+
+	return true
+}
+
+// Placeholder returns the JPEG frame served in place of stream and snapshots: 'privacy.placeholder'
+// or a plain frame with the resolution of the camera
+func Placeholder() []byte {
+	pMutex.Lock()
+	if placeholder != nil {
+		defer pMutex.Unlock()
+		return placeholder
+	}
+	pMutex.Unlock()
+
+	width, height := placeholderWidth, placeholderHeight
+	if w, err := motion.ConfigGet(motion.ConfigWidth); err == nil {
+		if h, err := motion.ConfigGet(motion.ConfigHeight); err == nil {
+			if w, ok := w.(int); ok && w > 0 {
+				if h, ok := h.(int); ok && h > 0 {
+					width, height = w, h
+				}
+			}
+		}
+	}
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(placeholderColor), image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		glg.Errorf("Unable to encode privacy placeholder: %v", err)
+	}
+
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	if initialized && placeholder == nil {
+		placeholder = buf.Bytes()
+	}
+
+	return buf.Bytes()
+}
+
+func scheduled(enabled bool) {
+	if err := Set(enabled, BySchedule); err != nil {
+		glg.Errorf("Unable to change privacy mode on schedule: %v", err)
+	}
+}
+
+// listen pauses detection when motion starts while privacy mode is enabled
+func listen(s *events.Subscription) {
+	for range s.C {
+		pMutex.Lock()
+		pause := state.Enabled && privacyConf.PauseDetection
+		if state.Enabled {
+			state.DetectionPaused = false
+			if err := save(); err != nil {
+				glg.Errorf("Unable to save privacy mode: %v", err)
+			}
+		}
+		pMutex.Unlock()
+
+		if pause {
+			pauseDetection()
+		}
+	}
+}
+
+// pauseDetection must be called without pMutex. Detection already paused by the user is left as it is
+func pauseDetection() {
+	if detecting, err := motion.IsMotionDetectionEnabled(); err != nil {
+		glg.Warnf("Unable to pause motion detection for privacy mode: %v", err)
+		return
+	} else if !detecting {
+		return
+	}
+
+	if err := motion.DisableMotionDetection(); err != nil {
+		glg.Warnf("Unable to pause motion detection for privacy mode: %v", err)
+		return
+	}
+
+	pMutex.Lock()
+	if !state.Enabled {
+		//Privacy mode disabled meanwhile: nobody else will resume detection
+		pMutex.Unlock()
+		resumeDetection()
+		return
+	}
+
+	state.DetectionPaused = true
+	if err := save(); err != nil {
+		glg.Errorf("Unable to save privacy mode: %v", err)
+	}
+	pMutex.Unlock()
+}
+
+// resumeDetection must be called without pMutex
+func resumeDetection() {
+	if err := motion.EnableMotionDetection(); err != nil {
+		glg.Warnf("Unable to resume motion detection after privacy mode: %v", err)
+	}
+}
+
+// save requires pMutex to be held
+func save() error {
+	if stateFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, stateFile)
+}
+
+func parseSchedule(spec, name string) (cron.Schedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	schedule, err := cron.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("Not a valid '%s'=%s: %v", name, spec, err)
+	}
+
+	return schedule, nil
+}
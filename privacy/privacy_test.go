@@ -0,0 +1,108 @@
+package privacy
+
+import (
+	"bytes"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+)
+
+func TestSetPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "privacy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fixed := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	require.Error(t, Set(true, "admin"))
+
+	require.NoError(t, Init(config.Privacy{}, dir))
+	require.Error(t, Init(config.Privacy{}, dir))
+	require.False(t, Enabled())
+
+	s := events.Subscribe(0, []events.Type{events.TypePrivacyMode})
+	defer events.Unsubscribe(s)
+
+	require.NoError(t, Set(true, "admin"))
+	require.True(t, Enabled())
+
+	e := <-s.C
+	require.Equal(t, true, e.Data["enabled"])
+	require.Equal(t, "admin", e.Data["by"])
+
+	//Setting the same mode again does nothing
+	require.NoError(t, Set(true, BySchedule))
+	require.Equal(t, "admin", GetStatus().By)
+
+	Shutdown()
+	require.False(t, Enabled())
+
+	//Restored from target_dir
+	require.NoError(t, Init(config.Privacy{}, dir))
+	status := GetStatus()
+	require.True(t, status.Enabled)
+	require.Equal(t, "admin", status.By)
+	require.True(t, fixed.Equal(status.Since))
+
+	require.NoError(t, Set(false, "admin"))
+	Shutdown()
+
+	require.NoError(t, Init(config.Privacy{File: filepath.Join(dir, DefaultFile)}, ""))
+	require.False(t, Enabled())
+	Shutdown()
+}
+
+func TestSchedule(t *testing.T) {
+	fixed := time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)
+	now = func() time.Time { return fixed }
+	defer func() { now = time.Now }()
+
+	require.Error(t, Init(config.Privacy{On: "not a schedule"}, ""))
+
+	require.NoError(t, Init(config.Privacy{On: "0 0 22 * * *", Off: "0 30 6 * * *"}, ""))
+	defer Shutdown()
+
+	status := GetStatus()
+	require.Equal(t, time.Date(2018, 3, 14, 22, 0, 0, 0, time.Local), *status.NextOn)
+	require.Equal(t, time.Date(2018, 3, 15, 6, 30, 0, 0, time.Local), *status.NextOff)
+
+	scheduled(true)
+	require.True(t, Enabled())
+	require.Equal(t, BySchedule, GetStatus().By)
+}
+
+func TestPlaceholder(t *testing.T) {
+	require.NoError(t, Init(config.Privacy{}, ""))
+
+	img, err := jpeg.Decode(bytes.NewReader(Placeholder()))
+	require.NoError(t, err)
+	require.Equal(t, placeholderWidth, img.Bounds().Dx())
+	require.Equal(t, placeholderHeight, img.Bounds().Dy())
+
+	Shutdown()
+
+	dir, err := ioutil.TempDir("", "privacy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "placeholder.jpg")
+	require.NoError(t, ioutil.WriteFile(file, []byte("not a picture"), 0644))
+	require.Error(t, Init(config.Privacy{Placeholder: file}, ""))
+
+	data := []byte{0xFF, 0xD8, 0x00, 0xFF, 0xD9}
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+	require.NoError(t, Init(config.Privacy{Placeholder: file}, ""))
+	defer Shutdown()
+
+	require.Equal(t, data, Placeholder())
+}
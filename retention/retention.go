@@ -0,0 +1,356 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/robfig/cron"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/thumbnail"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	RuleMaxAge          = "maxAge"
+	RuleMaxSize         = "maxSize"
+	RuleMaxPicturesSize = "maxPicturesSize"
+	RuleMaxMoviesSize   = "maxMoviesSize"
+	RuleMinFreeSpace    = "minFreeSpace"
+
+	DefaultWhen = "@every 10m"
+
+	// files modified recently may still be written by motion
+	minFileAge = 30 * time.Second
+)
+
+// Removal is a file removed (or that would be removed) and the rule that selected it
+type Removal struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Rule    string    `json:"rule"`
+}
+
+type Report struct {
+	Time      time.Time `json:"time"`
+	DryRun    bool      `json:"dryRun"`
+	Files     []Removal `json:"files"`
+	Count     int       `json:"count"`
+	FreedSize int64     `json:"freedSize"`
+	Errors    []string  `json:"errors,omitempty"`
+}
+
+type Status struct {
+	Active     bool             `json:"active"`
+	Config     config.Retention `json:"config"`
+	LastReport *Report          `json:"lastReport,omitempty"`
+}
+
+// rules are the parsed retention configuration, zero values are disabled rules
+type rules struct {
+	maxAge          time.Duration
+	maxSize         int64
+	maxPicturesSize int64
+	maxMoviesSize   int64
+	minFreeSpace    int64
+	protect         []string
+}
+
+type file struct {
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+	kind    string
+}
+
+var (
+	rMutex          sync.Mutex
+	runMutex        sync.Mutex
+	retentionConfig config.Retention
+	activeRules     *rules
+	targetDirectory string
+	cronSheduler    *cron.Cron
+	lastReport      *Report
+)
+
+func Init(conf config.Retention, targetDir string) error {
+	rMutex.Lock()
+	defer rMutex.Unlock()
+
+	if activeRules != nil {
+		return fmt.Errorf("Retention service already initialized")
+	}
+
+	if conf.IsEmpty() {
+		glg.Warn("No retention config found")
+		return nil
+	}
+
+	r, err := parseRules(conf)
+	if err != nil {
+		return err
+	}
+
+	when := conf.When
+	if when == "" {
+		when = DefaultWhen
+	}
+
+	cronSheduler = cron.New()
+	if err := cronSheduler.AddFunc(when, scheduledRun); err != nil {
+		cronSheduler = nil
+		return fmt.Errorf("Not a valid 'retention.when'=%s: %v", when, err)
+	}
+
+	cronSheduler.Start()
+
+	glg.Infof("Retention service is running on: %s, rules: %+v", when, conf)
+
+	retentionConfig = conf
+	activeRules = r
+	targetDirectory = targetDir
+
+	return nil
+}
+
+func Shutdown() {
+	rMutex.Lock()
+	defer rMutex.Unlock()
+
+	glg.Info("Shuting down retention service")
+
+	if cronSheduler != nil {
+		cronSheduler.Stop()
+		cronSheduler = nil
+	}
+
+	retentionConfig = config.Retention{}
+	activeRules = nil
+	targetDirectory = ""
+	lastReport = nil
+}
+
+func GetStatus() Status {
+	rMutex.Lock()
+	defer rMutex.Unlock()
+
+	return Status{Active: activeRules != nil, Config: retentionConfig, LastReport: lastReport}
+}
+
+// Preview returns the files that would be removed now, nothing is removed
+func Preview() (Report, error) {
+	return run(true)
+}
+
+// RunNow applies retention rules immediately
+func RunNow() (Report, error) {
+	return run(false)
+}
+
+func scheduledRun() {
+	if report, err := run(false); err != nil {
+		glg.Errorf("Retention failed: %v", err)
+	} else if report.Count > 0 {
+		glg.Infof("Retention removed %d files (%d bytes)", report.Count, report.FreedSize)
+	}
+}
+
+func run(dryRun bool) (Report, error) {
+	rMutex.Lock()
+	r, root := activeRules, targetDirectory
+	rMutex.Unlock()
+
+	if r == nil {
+		return Report{}, fmt.Errorf("Retention service is not active")
+	}
+
+	runMutex.Lock()
+	defer runMutex.Unlock()
+
+	files, err := listFiles(root)
+	if err != nil {
+		return Report{}, fmt.Errorf("Unable to list files in target directory: %v", err)
+	}
+
+	var free int64 = -1
+	if r.minFreeSpace > 0 {
+		_, available, err := utils.DiskUsage(root)
+		if err != nil {
+			return Report{}, fmt.Errorf("Unable to evaluate free space: %v", err)
+		}
+		free = int64(available)
+	}
+
+	report := Report{Time: time.Now(), DryRun: dryRun, Files: []Removal{}}
+
+	for _, removal := range plan(files, *r, report.Time, free) {
+		if !dryRun {
+			path := filepath.Join(root, filepath.FromSlash(removal.Name))
+
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+
+			thumbnail.Invalidate(path)
+
+			glg.Debugf("Retention removed %s (rule: %s)", removal.Name, removal.Rule)
+		}
+
+		report.Files = append(report.Files, removal)
+		report.Count++
+		report.FreedSize += removal.Size
+	}
+
+	if !dryRun {
+		rMutex.Lock()
+		lastReport = &report
+		rMutex.Unlock()
+	}
+
+	return report, nil
+}
+
+func parseRules(conf config.Retention) (*rules, error) {
+	r := &rules{protect: conf.Protect}
+	var err error
+
+	if conf.MaxAge != "" {
+		if r.maxAge, err = utils.ParseDuration(conf.MaxAge); err != nil || r.maxAge <= 0 {
+			return nil, fmt.Errorf("Invalid 'retention.maxAge': %s", conf.MaxAge)
+		}
+	}
+
+	for _, size := range []struct {
+		name  string
+		text  string
+		value *int64
+	}{
+		{RuleMaxSize, conf.MaxSize, &r.maxSize},
+		{RuleMaxPicturesSize, conf.MaxPicturesSize, &r.maxPicturesSize},
+		{RuleMaxMoviesSize, conf.MaxMoviesSize, &r.maxMoviesSize},
+		{RuleMinFreeSpace, conf.MinFreeSpace, &r.minFreeSpace},
+	} {
+		if size.text != "" {
+			if *size.value, err = utils.FromTextSize(size.text); err != nil {
+				return nil, fmt.Errorf("Invalid 'retention.%s' (%s): %v", size.name, size.text, err)
+			}
+		}
+	}
+
+	for _, pattern := range r.protect {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid 'retention.protect' pattern: %s", pattern)
+		}
+	}
+
+	return r, nil
+}
+
+// listFiles returns regular files in root and its subfolders, hidden files and folders excluded
+func listFiles(root string) ([]file, error) {
+	var files []file
+
+	err := motion.WalkTargetDir(root, func(f motion.TargetDirFile) {
+		files = append(files, file{path: filepath.Join(root, filepath.FromSlash(f.Name)), name: f.Name, size: f.Size, modTime: f.ModTime, kind: f.Type})
+	})
+
+	return files, err
+}
+
+func (r rules) protected(f file, now time.Time) bool {
+	if f.modTime.Add(minFileAge).After(now) {
+		return true
+	}
+
+	for _, pattern := range r.protect {
+		if match, _ := filepath.Match(pattern, f.name); match {
+			return true
+		}
+		if match, _ := filepath.Match(pattern, filepath.Base(f.path)); match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// plan selects files to remove, oldest unprotected first, so that every rule is satisfied.
+// free is the available space on disk, it is ignored when negative
+func plan(files []file, r rules, now time.Time, free int64) []Removal {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].modTime.Equal(files[j].modTime) {
+			return files[i].name < files[j].name
+		}
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	removed := make([]bool, len(files))
+	var removals []Removal
+
+	// sizes of remaining files (protected ones included) by kind, "" is the overall size
+	remaining := map[string]int64{}
+	for _, f := range files {
+		remaining[f.kind] += f.size
+		remaining[""] += f.size
+	}
+
+	var freed int64
+
+	remove := func(i int, rule string) {
+		f := files[i]
+		removed[i] = true
+		remaining[f.kind] -= f.size
+		remaining[""] -= f.size
+		freed += f.size
+		removals = append(removals, Removal{Name: f.name, Size: f.size, ModTime: f.modTime, Rule: rule})
+	}
+
+	// removeOldest removes unprotected files of kind ("" for any) while exceeded returns true
+	removeOldest := func(kind, rule string, exceeded func() bool) {
+		for i, f := range files {
+			if !exceeded() {
+				return
+			}
+			if !removed[i] && (kind == "" || f.kind == kind) && !r.protected(f, now) {
+				remove(i, rule)
+			}
+		}
+	}
+
+	if r.maxAge > 0 {
+		for i, f := range files {
+			if f.modTime.Before(now.Add(-r.maxAge)) && !r.protected(f, now) {
+				remove(i, RuleMaxAge)
+			}
+		}
+	}
+
+	for _, limit := range []struct {
+		kind  string
+		rule  string
+		value int64
+	}{
+		{motion.FileTypePicture, RuleMaxPicturesSize, r.maxPicturesSize},
+		{motion.FileTypeMovie, RuleMaxMoviesSize, r.maxMoviesSize},
+		{"", RuleMaxSize, r.maxSize},
+	} {
+		if limit.value > 0 {
+			removeOldest(limit.kind, limit.rule, func() bool { return remaining[limit.kind] > limit.value })
+		}
+	}
+
+	if r.minFreeSpace > 0 && free >= 0 {
+		removeOldest("", RuleMinFreeSpace, func() bool { return free+freed < r.minFreeSpace })
+	}
+
+	return removals
+}
@@ -0,0 +1,122 @@
+package retention
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/motion"
+)
+
+var now = time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)
+
+func testFiles() []file {
+	return []file{
+		{name: "04.jpg", size: 100, modTime: now.Add(-1 * time.Hour), kind: motion.FileTypePicture},
+		{name: "01.jpg", size: 100, modTime: now.Add(-4 * time.Hour), kind: motion.FileTypePicture},
+		{name: "02.mkv", size: 1000, modTime: now.Add(-3 * time.Hour), kind: motion.FileTypeMovie},
+		{name: "2018/03.jpg", size: 100, modTime: now.Add(-2 * time.Hour), kind: motion.FileTypePicture},
+		{name: "05.jpg", size: 100, modTime: now.Add(-time.Second), kind: motion.FileTypePicture},
+	}
+}
+
+func names(removals []Removal) []string {
+	list := []string{}
+	for _, r := range removals {
+		list = append(list, r.Name)
+	}
+	return list
+}
+
+func TestPlanMaxAge(t *testing.T) {
+	removals := plan(testFiles(), rules{maxAge: 150 * time.Minute}, now, -1)
+	require.Equal(t, []string{"01.jpg", "02.mkv"}, names(removals))
+	require.Equal(t, RuleMaxAge, removals[0].Rule)
+}
+
+func TestPlanMaxSize(t *testing.T) {
+	//Oldest first, recent files are never removed
+	require.Equal(t, []string{"01.jpg", "02.mkv"}, names(plan(testFiles(), rules{maxSize: 400}, now, -1)))
+	require.Equal(t, []string{"01.jpg", "02.mkv", "2018/03.jpg", "04.jpg"}, names(plan(testFiles(), rules{maxSize: 1}, now, -1)))
+
+	//Per type limits
+	require.Equal(t, []string{"01.jpg", "2018/03.jpg"}, names(plan(testFiles(), rules{maxPicturesSize: 200}, now, -1)))
+	require.Equal(t, []string{"02.mkv"}, names(plan(testFiles(), rules{maxMoviesSize: 500}, now, -1)))
+	require.Empty(t, plan(testFiles(), rules{maxMoviesSize: 1000}, now, -1))
+}
+
+func TestPlanMinFreeSpace(t *testing.T) {
+	require.Equal(t, []string{"01.jpg", "02.mkv"}, names(plan(testFiles(), rules{minFreeSpace: 1500}, now, 500)))
+	require.Empty(t, plan(testFiles(), rules{minFreeSpace: 1500}, now, 1500))
+	require.Empty(t, plan(testFiles(), rules{minFreeSpace: 1500}, now, -1))
+}
+
+func TestPlanProtect(t *testing.T) {
+	removals := plan(testFiles(), rules{maxSize: 1, protect: []string{"*.mkv", "2018/*"}}, now, -1)
+	require.Equal(t, []string{"01.jpg", "04.jpg"}, names(removals))
+}
+
+func TestParseRules(t *testing.T) {
+	r, err := parseRules(config.Retention{MaxAge: "7d", MaxSize: "1GB", MinFreeSpace: "500MB"})
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, r.maxAge)
+	require.Equal(t, int64(1000000000), r.maxSize)
+	require.Equal(t, int64(500000000), r.minFreeSpace)
+
+	_, err = parseRules(config.Retention{MaxAge: "-1h"})
+	require.Error(t, err)
+
+	_, err = parseRules(config.Retention{MaxMoviesSize: "a lot"})
+	require.Error(t, err)
+
+	_, err = parseRules(config.Retention{Protect: []string{"["}})
+	require.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"01.jpg", "sub/02.jpg", ".thumbnails/03.jpg", ".privacy.json"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("picture"), 0666))
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "new.jpg"), []byte("picture"), 0666))
+
+	_, err = Preview()
+	require.Error(t, err)
+
+	require.NoError(t, Init(config.Retention{MaxAge: "1d"}, dir))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Retention{MaxAge: "1d"}, dir))
+
+	report, err := Preview()
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, 2, report.Count)
+	require.FileExists(t, filepath.Join(dir, "01.jpg"))
+	require.Nil(t, GetStatus().LastReport)
+
+	report, err = RunNow()
+	require.NoError(t, err)
+	require.Equal(t, []string{"01.jpg", "sub/02.jpg"}, names(report.Files))
+	require.Equal(t, int64(14), report.FreedSize)
+	require.NoFileExists(t, filepath.Join(dir, "01.jpg"))
+	require.FileExists(t, filepath.Join(dir, "new.jpg"))
+	require.FileExists(t, filepath.Join(dir, ".thumbnails", "03.jpg"))
+	require.FileExists(t, filepath.Join(dir, ".privacy.json"))
+
+	status := GetStatus()
+	require.True(t, status.Active)
+	require.Equal(t, 2, status.LastReport.Count)
+}
@@ -0,0 +1,255 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	DefaultIdleTimeout = "2h"
+	DefaultMaxAge      = "7d"
+
+	// CookieName of the session token
+	CookieName = "motionctrl_session"
+)
+
+// Session of a browser client, the token is known only by the client: sessions are looked up by its hash
+type Session struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Expires    time.Time `json:"expires"`
+	RemoteAddr string    `json:"remoteAddr"`
+	UserAgent  string    `json:"userAgent"`
+	// SecondFactor is true when the user gave a TOTP or recovery code during this session
+	SecondFactor bool `json:"secondFactor"`
+}
+
+var (
+	sMutex      sync.Mutex
+	sessions    map[string]*Session
+	idleTimeout time.Duration
+	maxAge      time.Duration
+)
+
+// now is replaced in tests
+var now = time.Now
+
+func Init(conf config.Session) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if sessions != nil {
+		return fmt.Errorf("Sessions already initialized")
+	}
+
+	idle, err := parseDuration(conf.IdleTimeout, DefaultIdleTimeout, "session.idleTimeout")
+	if err != nil {
+		return err
+	}
+
+	max, err := parseDuration(conf.MaxAge, DefaultMaxAge, "session.maxAge")
+	if err != nil {
+		return err
+	}
+
+	if idle > max {
+		return fmt.Errorf("'session.idleTimeout' (%s) can't be greater than 'session.maxAge' (%s)", idle, max)
+	}
+
+	idleTimeout, maxAge = idle, max
+	sessions = make(map[string]*Session)
+
+	glg.Infof("Sessions expire after %s of inactivity or %s after login", idle, max)
+
+	return nil
+}
+
+// Shutdown drops every session, clients have to log in again
+func Shutdown() {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	glg.Info("Shuting down sessions")
+
+	sessions = nil
+}
+
+// Create starts a session for user, the returned token must be sent back by the client
+func Create(user, remoteAddr, userAgent string, secondFactor bool) (Session, string, error) {
+	token, err := random(32)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	id, err := random(9)
+	if err != nil {
+		return Session{}, "", err
+	}
+
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if sessions == nil {
+		return Session{}, "", fmt.Errorf("Sessions not initialized")
+	}
+
+	t := now()
+	s := &Session{
+		ID:           id,
+		User:         user,
+		Created:      t,
+		LastSeen:     t,
+		Expires:      t.Add(maxAge),
+		RemoteAddr:   remoteAddr,
+		UserAgent:    userAgent,
+		SecondFactor: secondFactor,
+	}
+
+	sessions[hash(token)] = s
+
+	glg.Infof("Session %s started for %s from %s", s.ID, user, remoteAddr)
+
+	return *s, token, nil
+}
+
+// Validate returns the session of token, if it isn't expired. Its idle timeout is restarted
+func Validate(token string) (Session, bool) {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if sessions == nil || token == "" {
+		return Session{}, false
+	}
+
+	key := hash(token)
+	s, ok := sessions[key]
+	if !ok {
+		return Session{}, false
+	}
+
+	t := now()
+	if expired(s, t) {
+		delete(sessions, key)
+		glg.Infof("Session %s of %s expired", s.ID, s.User)
+		return Session{}, false
+	}
+
+	s.LastSeen = t
+
+	return *s, true
+}
+
+// SetSecondFactor marks the session with id as verified by a second factor
+func SetSecondFactor(id string) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	for _, s := range sessions {
+		if s.ID == id {
+			s.SecondFactor = true
+			glg.Infof("Session %s of %s verified by second factor", s.ID, s.User)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("session %s not found", id)
+}
+
+// Delete ends the session of token (logout)
+func Delete(token string) {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if s, ok := sessions[hash(token)]; ok {
+		delete(sessions, hash(token))
+		glg.Infof("Session %s of %s ended", s.ID, s.User)
+	}
+}
+
+// Revoke ends the session with id
+func Revoke(id string) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	for key, s := range sessions {
+		if s.ID == id {
+			delete(sessions, key)
+			glg.Infof("Session %s of %s revoked", s.ID, s.User)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("session %s not found", id)
+}
+
+// List returns active sessions, most recently used first
+func List() []Session {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	t := now()
+	list := []Session{}
+
+	for key, s := range sessions {
+		if expired(s, t) {
+			delete(sessions, key)
+			continue
+		}
+		list = append(list, *s)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+
+	return list
+}
+
+// MaxAge is the absolute timeout of sessions
+func MaxAge() time.Duration {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	return maxAge
+}
+
+func expired(s *Session, t time.Time) bool {
+	return !t.Before(s.Expires) || t.Sub(s.LastSeen) >= idleTimeout
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func random(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func parseDuration(value, def, name string) (time.Duration, error) {
+	if value == "" {
+		value = def
+	}
+
+	d, err := utils.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid '%s': %s", name, value)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func TestInit(t *testing.T) {
+	require.Error(t, Init(config.Session{IdleTimeout: "never"}))
+	require.Error(t, Init(config.Session{IdleTimeout: "2d", MaxAge: "1d"}))
+
+	_, _, err := Create("user", "127.0.0.1", "test", false)
+	require.Error(t, err)
+
+	require.NoError(t, Init(config.Session{}))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Session{}))
+	require.Equal(t, 7*24*time.Hour, MaxAge())
+}
+
+func TestSessions(t *testing.T) {
+	current := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	require.NoError(t, Init(config.Session{IdleTimeout: "1h", MaxAge: "3h"}))
+	defer Shutdown()
+
+	s, token, err := Create("user", "192.168.1.2", "Firefox", false)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	require.NotEqual(t, token, s.ID)
+	require.Equal(t, current.Add(3*time.Hour), s.Expires)
+
+	_, ok := Validate("wrong")
+	require.False(t, ok)
+
+	//Idle timeout is restarted by every request
+	for i := 0; i < 5; i++ {
+		current = current.Add(30 * time.Minute)
+		validated, ok := Validate(token)
+		require.True(t, ok, "after %d requests", i)
+		require.Equal(t, s.ID, validated.ID)
+	}
+
+	//Absolute timeout
+	current = current.Add(30 * time.Minute)
+	_, ok = Validate(token)
+	require.False(t, ok)
+	require.Empty(t, List())
+
+	//Idle timeout
+	_, token, err = Create("user", "192.168.1.2", "Firefox", false)
+	require.NoError(t, err)
+	current = current.Add(time.Hour)
+	_, ok = Validate(token)
+	require.False(t, ok)
+
+	//Logout and revoke
+	_, first, err := Create("user", "192.168.1.2", "Firefox", false)
+	require.NoError(t, err)
+	current = current.Add(time.Minute)
+	second, _, err := Create("phone", "192.168.1.3", "Safari", false)
+	require.NoError(t, err)
+
+	list := List()
+	require.Len(t, list, 2)
+	require.Equal(t, second.ID, list[0].ID)
+
+	//Second factor given after login
+	require.False(t, second.SecondFactor)
+	require.Error(t, SetSecondFactor("missing"))
+	require.NoError(t, SetSecondFactor(second.ID))
+	require.True(t, List()[0].SecondFactor)
+
+	require.Error(t, Revoke("missing"))
+	require.NoError(t, Revoke(second.ID))
+
+	Delete(first)
+	_, ok = Validate(first)
+	require.False(t, ok)
+	require.Empty(t, List())
+}
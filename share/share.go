@@ -0,0 +1,428 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	DefaultDuration    = "1h"
+	DefaultMaxDuration = "7d"
+
+	// DefaultFile is hidden, so it is never served, backed up or removed by retention
+	DefaultFile = ".share.json"
+
+	// query parameters of share links
+	ParamID        = "share"
+	ParamExpires   = "exp"
+	ParamSignature = "sig"
+
+	// partialTarget prefixes the only targets whose partial requests can skip the use count: files of target_dir
+	partialTarget = "/targetdir/get/"
+
+	// partialWindow is how long after a counted use the same client can send partial requests (e.g. seek a movie)
+	partialWindow = 10 * time.Minute
+)
+
+var (
+	ErrNotFound = fmt.Errorf("share link not found or revoked")
+	ErrExpired  = fmt.Errorf("share link expired")
+	ErrUsedUp   = fmt.Errorf("share link has no uses left")
+	ErrInvalid  = fmt.Errorf("invalid share link")
+	ErrIP       = fmt.Errorf("share link can't be used from this address")
+)
+
+// Link grants access to target (path relative to /api, e.g. /camera/stream) with query without authentication
+type Link struct {
+	ID        string    `json:"id"`
+	Target    string    `json:"target"`
+	Query     string    `json:"query,omitempty"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	Expires   time.Time `json:"expires"`
+	// MaxUses is the number of requests allowed, 0 means unlimited
+	MaxUses int `json:"maxUses"`
+	Uses    int `json:"uses"`
+	// IP is the address (or CIDR) allowed to use the link, empty means any
+	IP string `json:"ip,omitempty"`
+
+	signature string
+	network   *net.IPNet
+	// lastUse and lastClient are the time and address of the last counted use
+	lastUse    time.Time
+	lastClient net.IP
+}
+
+// stored is the content of the links file, the secret is kept only when 'share.secret' isn't defined
+type stored struct {
+	Secret string  `json:"secret,omitempty"`
+	Links  []*Link `json:"links"`
+}
+
+var (
+	sMutex      sync.Mutex
+	links       map[string]*Link
+	secret      []byte
+	maxDuration time.Duration
+	linksFile   string
+	keepSecret  bool
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Init restores the links kept in 'share.file' or in target_dir, so that they survive restarts
+func Init(conf config.Share, targetDir string) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if links != nil {
+		return fmt.Errorf("Share links already initialized")
+	}
+
+	max := conf.MaxDuration
+	if max == "" {
+		max = DefaultMaxDuration
+	}
+
+	d, err := utils.ParseDuration(max)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("Invalid 'share.maxDuration': %s", max)
+	}
+
+	if conf.Secret != "" && len(conf.Secret) < 16 {
+		return fmt.Errorf("'share.secret' must be at least 16 characters long")
+	}
+
+	file := conf.File
+	if file == "" && targetDir != "" {
+		file = filepath.Join(targetDir, DefaultFile)
+	}
+
+	var s stored
+	if file == "" {
+		glg.Warn("No 'share.file' or 'target_dir' defined, share links won't survive restarts")
+	} else if data, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return fmt.Errorf("Unable to parse %s: %v", file, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	key := []byte(conf.Secret)
+	if len(key) == 0 {
+		if key, err = base64.StdEncoding.DecodeString(s.Secret); err != nil || len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return err
+			}
+			glg.Info("No 'share.secret' defined, share links are signed with a random one")
+		}
+	}
+
+	secret, maxDuration = key, d
+	linksFile, keepSecret = file, conf.Secret == ""
+	links = make(map[string]*Link)
+
+	//Links are signed again: a link signed with a previous secret doesn't match its signature anymore
+	t := now()
+	for _, l := range s.Links {
+		if l == nil || l.ID == "" || !t.Before(l.Expires) {
+			continue
+		}
+
+		if l.IP != "" {
+			if l.network, err = parseNetwork(l.IP); err != nil {
+				glg.Warnf("Share link %s dropped: %v", l.ID, err)
+				continue
+			}
+		}
+
+		l.signature = sign(l)
+		links[l.ID] = l
+	}
+
+	if len(links) > 0 {
+		glg.Infof("%d share links restored from %s", len(links), file)
+	}
+
+	if err := save(); err != nil {
+		glg.Warnf("Unable to save share links to %s: %v", file, err)
+	}
+
+	return nil
+}
+
+func Shutdown() {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	glg.Info("Shuting down share links")
+
+	links = nil
+	secret = nil
+	linksFile = ""
+}
+
+// Create mints a link to target with query valid for duration, maxUses and ip are optional (0 and "")
+func Create(target string, query url.Values, duration time.Duration, maxUses int, ip, createdBy string) (Link, error) {
+	if !strings.HasPrefix(target, "/") {
+		return Link{}, fmt.Errorf("invalid target: %s", target)
+	}
+
+	if maxUses < 0 {
+		return Link{}, fmt.Errorf("'uses' must be greater or equal to 0")
+	}
+
+	var network *net.IPNet
+	var err error
+	if ip != "" {
+		if network, err = parseNetwork(ip); err != nil {
+			return Link{}, err
+		}
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return Link{}, err
+	}
+
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if links == nil {
+		return Link{}, fmt.Errorf("Share links not initialized")
+	}
+
+	if duration <= 0 || duration > maxDuration {
+		return Link{}, fmt.Errorf("duration must be between 1s and %s", maxDuration)
+	}
+
+	t := now()
+	l := &Link{
+		ID:        id,
+		Target:    target,
+		Query:     encode(query),
+		Created:   t,
+		CreatedBy: createdBy,
+		Expires:   t.Add(duration).Truncate(time.Second),
+		MaxUses:   maxUses,
+		IP:        ip,
+		network:   network,
+	}
+	l.signature = sign(l)
+
+	links[id] = l
+
+	if err := save(); err != nil {
+		delete(links, id)
+		return Link{}, fmt.Errorf("Unable to save share links: %v", err)
+	}
+
+	glg.Infof("Share link %s to %s created by %s (expires: %s, uses: %d, ip: %s)", id, target, createdBy, l.Expires, maxUses, ip)
+
+	return *l, nil
+}
+
+// Query returns the query of link target with share parameters
+func Query(l Link) string {
+	q, _ := url.ParseQuery(l.Query)
+	q.Set(ParamID, l.ID)
+	q.Set(ParamExpires, strconv.FormatInt(l.Expires.Unix(), 10))
+	q.Set(ParamSignature, l.signature)
+
+	return q.Encode()
+}
+
+// Use checks that the request of clientIP to target is allowed by the link in query (id, expiry and signature),
+// a use is counted if it is. Partial requests of a file (e.g. a player seeking a movie) aren't counted when they come
+// from the client of the last counted use, within partialWindow. Stream and snapshot requests are always counted
+func Use(target string, query url.Values, clientIP net.IP, partial bool) (Link, error) {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	l, ok := links[query.Get(ParamID)]
+	if !ok {
+		return Link{}, ErrNotFound
+	}
+
+	//Signature covers target, expiry, uses and address: a link can't be reused for other files or extended
+	if query.Get(ParamExpires) != strconv.FormatInt(l.Expires.Unix(), 10) || l.Target != target || l.Query != encode(query) ||
+		!hmac.Equal([]byte(query.Get(ParamSignature)), []byte(sign(l))) {
+		return Link{}, ErrInvalid
+	}
+
+	if !now().Before(l.Expires) {
+		return Link{}, ErrExpired
+	}
+
+	if l.network != nil && (clientIP == nil || !l.network.Contains(clientIP)) {
+		return Link{}, ErrIP
+	}
+
+	t := now()
+	if partial && strings.HasPrefix(l.Target, partialTarget) && clientIP != nil && clientIP.Equal(l.lastClient) && t.Sub(l.lastUse) < partialWindow {
+		return *l, nil
+	}
+
+	if l.MaxUses > 0 && l.Uses >= l.MaxUses {
+		return Link{}, ErrUsedUp
+	}
+
+	l.Uses++
+	l.lastUse, l.lastClient = t, clientIP
+
+	if err := save(); err != nil {
+		glg.Errorf("Unable to save share links: %v", err)
+	}
+
+	return *l, nil
+}
+
+// Active returns true if link exists and isn't expired, long requests (e.g. stream) end when it returns false
+func Active(id string) bool {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	l, ok := links[id]
+	return ok && now().Before(l.Expires)
+}
+
+// List returns links not yet expired, newest first. Expired links are removed
+func List() []Link {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	t := now()
+	list := []Link{}
+	expired := false
+
+	for id, l := range links {
+		if !t.Before(l.Expires) {
+			delete(links, id)
+			expired = true
+			continue
+		}
+		list = append(list, *l)
+	}
+
+	if expired {
+		if err := save(); err != nil {
+			glg.Errorf("Unable to save share links: %v", err)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+
+	return list
+}
+
+func Revoke(id string) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if _, ok := links[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(links, id)
+	glg.Infof("Share link %s revoked", id)
+
+	return save()
+}
+
+// save requires sMutex to be held, the file is replaced atomically
+func save() error {
+	if linksFile == "" {
+		return nil
+	}
+
+	s := stored{Links: []*Link{}}
+	if keepSecret {
+		s.Secret = base64.StdEncoding.EncodeToString(secret)
+	}
+	for _, l := range links {
+		s.Links = append(s.Links, l)
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := linksFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, linksFile)
+}
+
+// encode returns query without share parameters, sorted by key
+func encode(query url.Values) string {
+	q := url.Values{}
+	for k, v := range query {
+		if k != ParamID && k != ParamExpires && k != ParamSignature {
+			q[k] = v
+		}
+	}
+
+	return q.Encode()
+}
+
+// sign requires sMutex to be held
+func sign(l *Link) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d\n%d\n%s", l.ID, l.Target, l.Query, l.Expires.Unix(), l.MaxUses, l.IP)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseNetwork(ip string) (*net.IPNet, error) {
+	if !strings.Contains(ip, "/") {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", ip)
+		}
+
+		bits := 128
+		if parsed.To4() != nil {
+			bits = 32
+		}
+		ip = fmt.Sprintf("%s/%d", ip, bits)
+	}
+
+	_, network, err := net.ParseCIDR(ip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	return network, nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
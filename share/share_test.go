@@ -0,0 +1,178 @@
+package share
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func TestInit(t *testing.T) {
+	require.Error(t, Init(config.Share{MaxDuration: "forever"}, ""))
+	require.Error(t, Init(config.Share{Secret: "short"}, ""))
+
+	_, err := Create("/camera/stream", nil, time.Hour, 0, "", "user")
+	require.Error(t, err)
+
+	require.NoError(t, Init(config.Share{Secret: "0123456789abcdef"}, ""))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Share{}, ""))
+}
+
+func TestLinks(t *testing.T) {
+	current := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	require.NoError(t, Init(config.Share{Secret: "0123456789abcdef", MaxDuration: "1d"}, ""))
+	defer Shutdown()
+
+	_, err := Create("/camera/stream", nil, 2*24*time.Hour, 0, "", "user")
+	require.Error(t, err)
+	_, err = Create("/camera/stream", nil, time.Hour, -1, "", "user")
+	require.Error(t, err)
+	_, err = Create("/camera/stream", nil, time.Hour, 0, "neighbour", "user")
+	require.Error(t, err)
+
+	snapshot, err := Create("/camera/snapshot", url.Values{"save": {"false"}}, 2*time.Hour, 2, "", "user")
+	require.NoError(t, err)
+	require.Equal(t, "save=false", snapshot.Query)
+
+	query, err := url.ParseQuery(Query(snapshot))
+	require.NoError(t, err)
+	require.Equal(t, "false", query.Get("save"))
+
+	ip := net.ParseIP("192.168.1.2")
+
+	//Target, query, expiry and signature must match
+	_, err = Use("/camera/stream", query, ip, false)
+	require.Equal(t, ErrInvalid, err)
+
+	tampered, _ := url.ParseQuery(query.Encode())
+	tampered.Set("save", "true")
+	_, err = Use("/camera/snapshot", tampered, ip, false)
+	require.Equal(t, ErrInvalid, err)
+
+	tampered, _ = url.ParseQuery(query.Encode())
+	tampered.Set(ParamExpires, "4102444800")
+	_, err = Use("/camera/snapshot", tampered, ip, false)
+	require.Equal(t, ErrInvalid, err)
+
+	tampered, _ = url.ParseQuery(query.Encode())
+	tampered.Set(ParamSignature, "forged")
+	_, err = Use("/camera/snapshot", tampered, ip, false)
+	require.Equal(t, ErrInvalid, err)
+
+	//Use count
+	for i := 1; i <= 2; i++ {
+		link, err := Use("/camera/snapshot", query, ip, false)
+		require.NoError(t, err)
+		require.Equal(t, i, link.Uses)
+	}
+	_, err = Use("/camera/snapshot", query, ip, false)
+	require.Equal(t, ErrUsedUp, err)
+
+	//IP binding, partial requests of a movie already opened don't count
+	movie, err := Create("/targetdir/get/2018/03/14/01-20180314150000.mp4", nil, 24*time.Hour, 1, "203.0.113.0/24", "user")
+	require.NoError(t, err)
+	query, _ = url.ParseQuery(Query(movie))
+
+	_, err = Use(movie.Target, query, ip, false)
+	require.Equal(t, ErrIP, err)
+	_, err = Use(movie.Target, query, net.ParseIP("203.0.113.7"), false)
+	require.NoError(t, err)
+	_, err = Use(movie.Target, query, net.ParseIP("203.0.113.7"), true)
+	require.NoError(t, err)
+	_, err = Use(movie.Target, query, net.ParseIP("203.0.113.7"), false)
+	require.Equal(t, ErrUsedUp, err)
+
+	//Only the same client, shortly after its counted use
+	_, err = Use(movie.Target, query, net.ParseIP("203.0.113.8"), true)
+	require.Equal(t, ErrUsedUp, err)
+
+	current = current.Add(partialWindow)
+	_, err = Use(movie.Target, query, net.ParseIP("203.0.113.7"), true)
+	require.Equal(t, ErrUsedUp, err)
+	current = current.Add(-partialWindow)
+
+	//Partial requests of stream and snapshots are always counted
+	once, err := Create("/camera/stream", nil, time.Hour, 1, "", "user")
+	require.NoError(t, err)
+	query, _ = url.ParseQuery(Query(once))
+
+	_, err = Use(once.Target, query, ip, false)
+	require.NoError(t, err)
+	_, err = Use(once.Target, query, ip, true)
+	require.Equal(t, ErrUsedUp, err)
+	require.NoError(t, Revoke(once.ID))
+
+	//Expiry
+	stream, err := Create("/camera/stream", nil, 2*time.Hour, 0, "", "user")
+	require.NoError(t, err)
+	require.True(t, Active(stream.ID))
+	require.Len(t, List(), 3)
+
+	current = current.Add(3 * time.Hour)
+	require.False(t, Active(stream.ID))
+	query, _ = url.ParseQuery(Query(stream))
+	_, err = Use("/camera/stream", query, ip, false)
+	require.Equal(t, ErrExpired, err)
+
+	list := List()
+	require.Len(t, list, 1)
+	require.Equal(t, movie.ID, list[0].ID)
+
+	//Revoke
+	require.NoError(t, Revoke(movie.ID))
+	require.Equal(t, ErrNotFound, Revoke(movie.ID))
+	require.False(t, Active(movie.ID))
+	require.Empty(t, List())
+}
+
+func TestPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "share")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, Init(config.Share{}, dir))
+
+	link, err := Create("/targetdir/get/01.mp4", nil, time.Hour, 3, "203.0.113.0/24", "user")
+	require.NoError(t, err)
+	query, _ := url.ParseQuery(Query(link))
+
+	ip := net.ParseIP("203.0.113.7")
+	_, err = Use(link.Target, query, ip, false)
+	require.NoError(t, err)
+
+	Shutdown()
+
+	info, err := os.Stat(filepath.Join(dir, DefaultFile))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	//The random secret is kept with the links, so they are still valid after a restart
+	require.NoError(t, Init(config.Share{}, dir))
+
+	restored, err := Use(link.Target, query, ip, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored.Uses)
+	_, err = Use(link.Target, query, net.ParseIP("192.168.1.2"), false)
+	require.Equal(t, ErrIP, err)
+
+	Shutdown()
+
+	//Links signed with another secret are no longer valid
+	require.NoError(t, Init(config.Share{Secret: "0123456789abcdef"}, dir))
+	defer Shutdown()
+
+	_, err = Use(link.Target, query, ip, false)
+	require.Equal(t, ErrInvalid, err)
+}
@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/backup"
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/events"
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/notify"
+	"github.com/andreacioni/motionctrl/privacy"
+	"github.com/andreacioni/motionctrl/retention"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	LevelNormal   = "normal"
+	LevelWarning  = "warning"
+	LevelCritical = "critical"
+
+	ActionBackup    = "backup"
+	ActionRetention = "retention"
+	ActionPause     = "pause"
+
+	VolumeTargetDir = "target_dir"
+	// VolumeTemp holds archives built by backup before upload
+	VolumeTemp = "temp"
+
+	DefaultInterval = "1m"
+	DefaultWarning  = 85
+	DefaultCritical = 95
+
+	// usage must drop this many points below a threshold to leave its level, avoids flapping alerts
+	hysteresis = 2
+)
+
+type Volume struct {
+	Name        string  `json:"name"`
+	Path        string  `json:"path"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	Used        uint64  `json:"used"`
+	UsedPercent float64 `json:"usedPercent"`
+	Level       string  `json:"level"`
+	Error       string  `json:"error,omitempty"`
+
+	device uint64
+}
+
+type ActionReport struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type Status struct {
+	Active          bool          `json:"active"`
+	Level           string        `json:"level"`
+	Warning         int           `json:"warning"`
+	Critical        int           `json:"critical"`
+	Action          string        `json:"action,omitempty"`
+	Volumes         []Volume      `json:"volumes"`
+	LastCheck       time.Time     `json:"lastCheck"`
+	LastAction      *ActionReport `json:"lastAction,omitempty"`
+	DetectionPaused bool          `json:"detectionPaused"`
+}
+
+// actions taken when a volume becomes critical, replaced in tests
+var actions = map[string]func() error{
+	ActionBackup: backup.RunNow,
+	ActionRetention: func() error {
+		report, err := retention.RunNow()
+		if err == nil {
+			glg.Infof("Retention removed %d files (%d bytes) to free disk space", report.Count, report.FreedSize)
+		}
+		return err
+	},
+	ActionPause: pauseDetection,
+}
+
+// resumeDetection is called when usage is back to normal after detection has been paused, replaced in tests
+var resumeDetection = motion.EnableMotionDetection
+
+var (
+	sMutex     sync.Mutex
+	volumes    []Volume
+	warning    int
+	critical   int
+	action     string
+	levels     map[string]string
+	lastCheck  time.Time
+	lastAction *ActionReport
+	paused     bool
+	quit       chan struct{}
+)
+
+func Init(conf config.Storage, targetDir string) error {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	if levels != nil {
+		return fmt.Errorf("Storage monitor already initialized")
+	}
+
+	interval := conf.Interval
+	if interval == "" {
+		interval = DefaultInterval
+	}
+
+	every, err := utils.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		return fmt.Errorf("Invalid 'storage.interval': %s", interval)
+	}
+
+	w, c := conf.Warning, conf.Critical
+	if w == 0 {
+		w = DefaultWarning
+	}
+	if c == 0 {
+		c = DefaultCritical
+	}
+
+	if w <= 0 || c > 100 || w > c {
+		return fmt.Errorf("Invalid storage thresholds, 0 < warning (%d) <= critical (%d) <= 100 is required", w, c)
+	}
+
+	if conf.Action != "" && actions[conf.Action] == nil {
+		return fmt.Errorf("Invalid 'storage.action': %s (available: %s, %s, %s)", conf.Action, ActionBackup, ActionRetention, ActionPause)
+	}
+
+	volumes = []Volume{{Name: VolumeTargetDir, Path: targetDir}, {Name: VolumeTemp, Path: os.TempDir()}}
+	warning, critical, action = w, c, conf.Action
+	levels = make(map[string]string)
+	lastCheck = time.Time{}
+	lastAction = nil
+	paused = false
+
+	quit = make(chan struct{})
+	go monitor(quit, every)
+
+	glg.Infof("Storage monitor is running every %s (warning: %d%%, critical: %d%%, action: %s)", interval, w, c, conf.Action)
+
+	return nil
+}
+
+func Shutdown() {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	glg.Info("Shuting down storage monitor")
+
+	if levels == nil {
+		return
+	}
+
+	close(quit)
+
+	volumes = nil
+	levels = nil
+}
+
+// Check evaluates disk usage now, sending alerts and taking the configured action if a level changed
+func Check() (Status, error) {
+	sMutex.Lock()
+
+	if levels == nil {
+		sMutex.Unlock()
+		return Status{}, fmt.Errorf("Storage monitor is not running")
+	}
+
+	var alerts []string
+	var run, resume bool
+
+	//Volumes on the same device (e.g. target_dir and temp) are alerted once
+	alerted := map[uint64]bool{}
+
+	for i := range volumes {
+		v := &volumes[i]
+
+		//Keep last known level when usage can't be evaluated
+		if measure(v); v.Error != "" {
+			continue
+		}
+
+		previous := levels[v.Name]
+		if previous == "" {
+			previous = LevelNormal
+		}
+
+		v.Level = levelOf(v.UsedPercent, previous, warning, critical)
+		levels[v.Name] = v.Level
+
+		if v.Level == previous || alerted[v.device] {
+			continue
+		}
+		alerted[v.device] = true
+
+		glg.Warnf("Disk usage of %s (%s) is %.1f%%, level changed: %s -> %s", v.Name, v.Path, v.UsedPercent, previous, v.Level)
+
+		events.Publish(events.TypeStorageLevel, 0, "", map[string]interface{}{
+			"volume": v.Name, "path": v.Path, "level": v.Level, "previous": previous, "usedPercent": v.UsedPercent, "free": v.Free,
+		})
+
+		if rank(v.Level) > rank(previous) {
+			alerts = append(alerts, fmt.Sprintf("Disk usage of %s is %.1f%% (%dMB free): %s level reached", v.Name, v.UsedPercent, v.Free/1000000, v.Level))
+			run = run || (v.Level == LevelCritical && action != "")
+		} else if v.Level == LevelNormal {
+			alerts = append(alerts, fmt.Sprintf("Disk usage of %s is back to normal (%.1f%%)", v.Name, v.UsedPercent))
+		}
+	}
+
+	lastCheck = time.Now()
+
+	//Detection paused by the monitor is resumed only when every volume is back to normal
+	if paused && overallLevel() == LevelNormal {
+		paused = false
+		resume = true
+	}
+
+	a := action
+	sMutex.Unlock()
+
+	for _, message := range alerts {
+		if err := notify.Alert(message); err != nil {
+			glg.Warnf("Unable to send storage alert: %v", err)
+		}
+	}
+
+	if resume && privacy.KeepDetectionPaused() {
+		glg.Info("Disk usage back to normal, motion detection stays paused by privacy mode")
+	} else if resume {
+		if err := resumeDetection(); err != nil {
+			glg.Errorf("Unable to resume motion detection: %v", err)
+		} else {
+			glg.Info("Disk usage back to normal, motion detection resumed")
+		}
+	}
+
+	if run {
+		takeAction(a)
+	}
+
+	return GetStatus(), nil
+}
+
+func GetStatus() Status {
+	sMutex.Lock()
+	defer sMutex.Unlock()
+
+	s := Status{
+		Active:          levels != nil,
+		Level:           overallLevel(),
+		Warning:         warning,
+		Critical:        critical,
+		Action:          action,
+		Volumes:         append([]Volume{}, volumes...),
+		LastCheck:       lastCheck,
+		LastAction:      lastAction,
+		DetectionPaused: paused,
+	}
+
+	return s
+}
+
+func monitor(quit chan struct{}, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	check := func() {
+		if _, err := Check(); err != nil {
+			glg.Errorf("Storage check failed: %v", err)
+		}
+	}
+
+	check()
+
+	for {
+		select {
+		case <-ticker.C:
+			check()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func measure(v *Volume) {
+	v.Error = ""
+
+	total, free, err := utils.DiskUsage(v.Path)
+	if err == nil {
+		v.device, err = utils.DeviceID(v.Path)
+	}
+
+	if err != nil {
+		v.Error = err.Error()
+		return
+	}
+
+	v.Total, v.Free = total, free
+	v.Used, v.UsedPercent = 0, 0
+
+	if total > 0 {
+		v.Used = total - free
+		v.UsedPercent = float64(v.Used) * 100 / float64(total)
+	}
+}
+
+// levelOf returns the level of usage (percentage), thresholds are lowered by hysteresis while leaving previous level
+func levelOf(usage float64, previous string, warning, critical int) string {
+	w, c := float64(warning), float64(critical)
+
+	switch previous {
+	case LevelCritical:
+		c -= hysteresis
+		w -= hysteresis
+	case LevelWarning:
+		w -= hysteresis
+	}
+
+	switch {
+	case usage >= c:
+		return LevelCritical
+	case usage >= w:
+		return LevelWarning
+	default:
+		return LevelNormal
+	}
+}
+
+func rank(level string) int {
+	switch level {
+	case LevelCritical:
+		return 2
+	case LevelWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// overallLevel is the worst level among volumes, requires sMutex to be held
+func overallLevel() string {
+	level := LevelNormal
+	for _, l := range levels {
+		if rank(l) > rank(level) {
+			level = l
+		}
+	}
+	return level
+}
+
+func takeAction(a string) {
+	glg.Warnf("Disk usage is critical, taking action: %s", a)
+
+	err := actions[a]()
+
+	report := &ActionReport{Action: a, Time: time.Now()}
+	if err != nil {
+		report.Error = err.Error()
+		glg.Errorf("Storage action %s failed: %v", a, err)
+	}
+
+	sMutex.Lock()
+	lastAction = report
+	sMutex.Unlock()
+}
+
+// pauseDetection disables motion detection, it will be resumed by Check only if it was enabled before
+func pauseDetection() error {
+	if started, err := motion.IsStarted(); err != nil || !started {
+		return fmt.Errorf("motion is not running")
+	}
+
+	enabled, err := motion.IsMotionDetectionEnabled()
+	if err != nil || !enabled {
+		return err
+	}
+
+	if err := motion.DisableMotionDetection(); err != nil {
+		return err
+	}
+
+	sMutex.Lock()
+	paused = true
+	sMutex.Unlock()
+
+	return nil
+}
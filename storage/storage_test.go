@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/privacy"
+)
+
+func TestLevelOf(t *testing.T) {
+	require.Equal(t, LevelNormal, levelOf(50, LevelNormal, 85, 95))
+	require.Equal(t, LevelWarning, levelOf(85, LevelNormal, 85, 95))
+	require.Equal(t, LevelCritical, levelOf(99, LevelNormal, 85, 95))
+
+	//Hysteresis
+	require.Equal(t, LevelWarning, levelOf(84, LevelWarning, 85, 95))
+	require.Equal(t, LevelNormal, levelOf(82.9, LevelWarning, 85, 95))
+	require.Equal(t, LevelCritical, levelOf(93.5, LevelCritical, 85, 95))
+	require.Equal(t, LevelWarning, levelOf(92, LevelCritical, 85, 95))
+	require.Equal(t, LevelNormal, levelOf(80, LevelCritical, 85, 95))
+}
+
+func TestInit(t *testing.T) {
+	require.Error(t, Init(config.Storage{Interval: "never"}, os.TempDir()))
+	require.Error(t, Init(config.Storage{Warning: 90, Critical: 80}, os.TempDir()))
+	require.Error(t, Init(config.Storage{Critical: 101}, os.TempDir()))
+	require.Error(t, Init(config.Storage{Action: "format"}, os.TempDir()))
+
+	_, err := Check()
+	require.Error(t, err)
+	require.False(t, GetStatus().Active)
+
+	require.NoError(t, Init(config.Storage{Interval: "1h"}, os.TempDir()))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Storage{}, os.TempDir()))
+
+	status := GetStatus()
+	require.True(t, status.Active)
+	require.Equal(t, DefaultWarning, status.Warning)
+	require.Equal(t, DefaultCritical, status.Critical)
+	require.Len(t, status.Volumes, 2)
+}
+
+func TestCheck(t *testing.T) {
+	taken := 0
+	actions[ActionRetention] = func() error { taken++; return nil }
+
+	resumed := 0
+	resumeDetection = func() error { resumed++; return nil }
+
+	dir, err := os.Getwd()
+	require.NoError(t, err)
+
+	//Every volume in use is above 1%
+	require.NoError(t, Init(config.Storage{Interval: "1h", Warning: 1, Critical: 1, Action: ActionRetention}, dir))
+	defer Shutdown()
+
+	//First check is run by monitor right after Init
+	for i := 0; i < 500 && GetStatus().LastAction == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := GetStatus()
+	require.Equal(t, LevelCritical, status.Level)
+	require.Equal(t, VolumeTargetDir, status.Volumes[0].Name)
+	require.True(t, status.Volumes[0].Total > 0)
+	require.Equal(t, 1, taken)
+	require.NotNil(t, status.LastAction)
+	require.Equal(t, ActionRetention, status.LastAction.Action)
+
+	//Action is taken only when critical level is reached
+	_, err = Check()
+	require.NoError(t, err)
+	require.Equal(t, 1, taken)
+
+	//Paused detection is resumed when back to normal
+	sMutex.Lock()
+	warning, critical, paused = 100, 100, true
+	sMutex.Unlock()
+
+	status, err = Check()
+	require.NoError(t, err)
+	require.Equal(t, LevelNormal, status.Level)
+	require.False(t, status.DetectionPaused)
+	require.Equal(t, 1, resumed)
+
+	//Privacy mode keeps detection paused, it resumes detection when disabled
+	stateDir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	require.NoError(t, privacy.Init(config.Privacy{PauseDetection: true}, stateDir))
+	defer privacy.Shutdown()
+	require.NoError(t, privacy.Set(true, "admin"))
+
+	sMutex.Lock()
+	paused = true
+	sMutex.Unlock()
+
+	status, err = Check()
+	require.NoError(t, err)
+	require.False(t, status.DetectionPaused)
+	require.Equal(t, 1, resumed)
+	require.True(t, privacy.GetStatus().DetectionPaused)
+}
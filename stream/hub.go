@@ -0,0 +1,322 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/motion"
+)
+
+const (
+	// time to wait before closing the upstream connection when the last viewer leaves
+	upstreamLinger = 5 * time.Second
+	// time to wait before reconnecting to motion after a failure
+	upstreamRetry = time.Second
+	// number of frames used to evaluate upstream frame rate
+	fpsWindow = 30
+)
+
+type Frame struct {
+	Data []byte
+	Time time.Time
+	Seq  uint64
+}
+
+// Client receives frames on C. Only the most recent frame is kept: a slow client
+// drops frames instead of stalling the others. C is closed on Shutdown.
+type Client struct {
+	C chan *Frame
+
+	// internal clients (e.g. transcoders) are not counted as viewers
+	internal   bool
+	transcoder *transcoder
+}
+
+type Stats struct {
+	Viewers           int            `json:"viewers"`
+	UpstreamConnected bool           `json:"upstreamConnected"`
+	UpstreamFPS       float64        `json:"upstreamFps"`
+	Frames            uint64         `json:"frames"`
+	DroppedFrames     uint64         `json:"droppedFrames"`
+	Profiles          map[string]int `json:"profiles"`
+}
+
+var (
+	// upstreamURL is a variable to let tests point the hub to a fake motion stream
+	upstreamURL = motion.GetStreamBaseURL
+
+	hMutex      sync.Mutex
+	clients     = map[*Client]bool{}
+	latest      *Frame
+	frameTimes  []time.Time
+	frames      uint64
+	dropped     uint64
+	connected   bool
+	stopping    *time.Timer
+	stopWorker  context.CancelFunc
+	workerGroup sync.WaitGroup
+)
+
+// Subscribe registers a new viewer, connecting to motion's stream if needed
+func Subscribe() *Client {
+	return subscribe(false)
+}
+
+func subscribe(internal bool) *Client {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	c := &Client{C: make(chan *Frame, 1), internal: internal}
+	clients[c] = true
+
+	if stopping != nil {
+		stopping.Stop()
+		stopping = nil
+	}
+
+	if stopWorker == nil {
+		startWorker()
+	}
+
+	glg.Debugf("New stream client (clients: %d)", len(clients))
+
+	return c
+}
+
+// Unsubscribe removes a viewer. Upstream connection is closed shortly after the last viewer leaves
+func Unsubscribe(c *Client) {
+	if c.transcoder != nil {
+		c.transcoder.remove(c)
+		return
+	}
+
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if !clients[c] {
+		return
+	}
+
+	delete(clients, c)
+
+	glg.Debugf("Stream client left (clients: %d)", len(clients))
+
+	if len(clients) == 0 && stopWorker != nil && stopping == nil {
+		stopping = time.AfterFunc(upstreamLinger, func() {
+			hMutex.Lock()
+			defer hMutex.Unlock()
+
+			if len(clients) == 0 && stopping != nil {
+				stopping = nil
+				stopUpstream()
+			}
+		})
+	}
+}
+
+// Latest returns the last frame received from motion, nil if none
+func Latest() *Frame {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	return latest
+}
+
+// WaitFrame returns the most recent frame, connecting to motion's stream if nobody is watching it
+func WaitFrame(timeout time.Duration) (*Frame, error) {
+	hMutex.Lock()
+	frame := latest
+	if !connected {
+		frame = nil
+	}
+	hMutex.Unlock()
+
+	if frame != nil {
+		return frame, nil
+	}
+
+	c := subscribe(true)
+	defer Unsubscribe(c)
+
+	select {
+	case frame, ok := <-c.C:
+		if !ok {
+			return nil, fmt.Errorf("stream hub is shutting down")
+		}
+		return frame, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no frame received from motion in %v", timeout)
+	}
+}
+
+func GetStats() Stats {
+	profiles := profileViewers()
+
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	stats := Stats{UpstreamConnected: connected, Frames: frames, DroppedFrames: dropped, Profiles: profiles}
+
+	for c := range clients {
+		if !c.internal {
+			stats.Viewers++
+		}
+	}
+
+	for _, n := range profiles {
+		stats.Viewers += n
+	}
+
+	if n := len(frameTimes); n > 1 && connected {
+		if elapsed := frameTimes[n-1].Sub(frameTimes[0]).Seconds(); elapsed > 0 {
+			stats.UpstreamFPS = float64(n-1) / elapsed
+		}
+	}
+
+	return stats
+}
+
+// Shutdown disconnects every viewer and closes the upstream connection
+func Shutdown() {
+	hMutex.Lock()
+
+	glg.Info("Shutting down stream hub")
+
+	if stopping != nil {
+		stopping.Stop()
+		stopping = nil
+	}
+
+	for c := range clients {
+		delete(clients, c)
+		close(c.C)
+	}
+
+	stopUpstream()
+
+	hMutex.Unlock()
+
+	workerGroup.Wait()
+}
+
+func startWorker() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopWorker = cancel
+
+	workerGroup.Add(1)
+	go func() {
+		defer workerGroup.Done()
+		upstreamWorker(ctx)
+	}()
+}
+
+func stopUpstream() {
+	if stopWorker != nil {
+		glg.Debug("Closing upstream stream connection")
+		stopWorker()
+		stopWorker = nil
+	}
+
+	connected = false
+	latest = nil
+	frameTimes = nil
+}
+
+func upstreamWorker(ctx context.Context) {
+	for {
+		if err := readUpstream(ctx); err != nil && ctx.Err() == nil {
+			glg.Warnf("Stream upstream failed: %v", err)
+		}
+
+		hMutex.Lock()
+		connected = false
+		hMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(upstreamRetry):
+		}
+	}
+}
+
+func readUpstream(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, upstreamURL(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with code: %d", resp.StatusCode)
+	}
+
+	reader, err := newMJPEGReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	glg.Infof("Connected to motion stream: %s", upstreamURL())
+
+	for {
+		data, err := reader.NextFrame()
+		if err != nil {
+			return err
+		}
+
+		broadcast(ctx, data)
+	}
+}
+
+func broadcast(ctx context.Context, data []byte) {
+	hMutex.Lock()
+	defer hMutex.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	frames++
+	connected = true
+	latest = &Frame{Data: data, Time: time.Now(), Seq: frames}
+
+	frameTimes = append(frameTimes, latest.Time)
+	if len(frameTimes) > fpsWindow {
+		frameTimes = frameTimes[len(frameTimes)-fpsWindow:]
+	}
+
+	for c := range clients {
+		if push(c, latest) {
+			dropped++
+		}
+	}
+}
+
+// push sends frame to the client without blocking, returns true if a previous frame was dropped.
+// Callers must serialize pushes to the same client.
+func push(c *Client, frame *Frame) bool {
+	select {
+	case c.C <- frame:
+		return false
+	default:
+		// client is still busy with the previous frame, replace it with the newest one
+		drop := false
+		select {
+		case <-c.C:
+			drop = true
+		default:
+		}
+		c.C <- frame
+		return drop
+	}
+}
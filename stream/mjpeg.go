@@ -0,0 +1,128 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Boundary used when writing multipart streams to clients
+	Boundary = "motionctrl"
+
+	maxFrameSize   = 16 << 20
+	maxSkippedLine = 64
+)
+
+var jpegEOI = []byte{0xFF, 0xD9}
+
+// mjpegReader splits a multipart/x-mixed-replace body into JPEG frames. Content-Length
+// header is used when available so that a frame is returned as soon as it is received,
+// without waiting for the next boundary.
+type mjpegReader struct {
+	r        *bufio.Reader
+	boundary string
+}
+
+func newMJPEGReader(r io.Reader, contentType string) (*mjpegReader, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %s: %v", contentType, err)
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, fmt.Errorf("not a multipart stream: %s", contentType)
+	}
+
+	return &mjpegReader{r: bufio.NewReaderSize(r, 64*1024), boundary: strings.TrimPrefix(params["boundary"], "--")}, nil
+}
+
+func (m *mjpegReader) NextFrame() ([]byte, error) {
+	if err := m.skipToBoundary(); err != nil {
+		return nil, err
+	}
+
+	header, err := textproto.NewReader(m.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	if cl := header.Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(cl))
+		if err != nil || n <= 0 || n > maxFrameSize {
+			return nil, fmt.Errorf("invalid frame length: %s", cl)
+		}
+
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(m.r, frame); err != nil {
+			return nil, err
+		}
+
+		return frame, nil
+	}
+
+	return m.readUntilEOI()
+}
+
+func (m *mjpegReader) skipToBoundary() error {
+	for i := 0; i < maxSkippedLine; i++ {
+		line, err := m.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if strings.TrimSpace(line) == "--"+m.boundary {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("boundary %s not found", m.boundary)
+}
+
+func (m *mjpegReader) readUntilEOI() ([]byte, error) {
+	var frame bytes.Buffer
+
+	for frame.Len() < maxFrameSize {
+		chunk, err := m.r.ReadSlice(jpegEOI[1])
+		frame.Write(chunk)
+
+		if err == nil {
+			if bytes.HasSuffix(frame.Bytes(), jpegEOI) {
+				return frame.Bytes(), nil
+			}
+		} else if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("frame exceeds %d bytes", maxFrameSize)
+}
+
+// WriteFrame writes a single frame as part of a multipart/x-mixed-replace response
+func WriteFrame(w io.Writer, frame []byte) (int, error) {
+	n, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", Boundary, len(frame))
+	if err != nil {
+		return n, err
+	}
+
+	m, err := w.Write(frame)
+	n += m
+	if err != nil {
+		return n, err
+	}
+
+	m, err = io.WriteString(w, "\r\n")
+
+	return n + m, err
+}
+
+// ContentType returns the content type of the multipart streams written with WriteFrame
+func ContentType() string {
+	return "multipart/x-mixed-replace; boundary=" + Boundary
+}
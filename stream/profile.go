@@ -0,0 +1,204 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	MaxFPS = 30
+)
+
+// Profile describes how frames are delivered to a client. Zero values mean
+// "as received from motion"
+type Profile struct {
+	FPS     float64
+	Width   int
+	Quality int
+}
+
+// transcoder re-encodes frames received from the hub once for every client sharing the same profile
+type transcoder struct {
+	profile Profile
+	source  *Client
+	clients map[*Client]bool
+	done    chan struct{}
+}
+
+var (
+	pMutex      sync.Mutex
+	transcoders = map[Profile]*transcoder{}
+)
+
+func (p Profile) String() string {
+	return fmt.Sprintf("fps=%g,width=%d,quality=%d", p.FPS, p.Width, p.Quality)
+}
+
+func (p Profile) Validate() error {
+	if p.FPS < 0 || p.FPS > MaxFPS {
+		return fmt.Errorf("'fps' must be between 0 and %d", MaxFPS)
+	}
+
+	if p.Width != 0 && (p.Width < utils.MinImageWidth || p.Width > utils.MaxImageWidth) {
+		return fmt.Errorf("'width' must be between %d and %d", utils.MinImageWidth, utils.MaxImageWidth)
+	}
+
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("'quality' must be between 1 and 100")
+	}
+
+	return nil
+}
+
+// Transform re-encodes a JPEG frame to match profile width and quality
+func (p Profile) Transform(data []byte) ([]byte, error) {
+	if p.Width == 0 && p.Quality == 0 {
+		return data, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode frame: %v", err)
+	}
+
+	quality := p.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, utils.ScaleToWidth(img, p.Width), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("unable to encode frame: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SubscribeProfile registers a new viewer that receives frames adapted to profile.
+// Viewers asking for the same profile share the work needed to produce their frames
+func SubscribeProfile(p Profile) *Client {
+	if p == (Profile{}) {
+		return Subscribe()
+	}
+
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	t := transcoders[p]
+	if t == nil {
+		glg.Debugf("Starting stream transcoder for profile: %s", p)
+
+		t = &transcoder{profile: p, source: subscribe(true), clients: map[*Client]bool{}, done: make(chan struct{})}
+		transcoders[p] = t
+
+		go t.run()
+	}
+
+	c := &Client{C: make(chan *Frame, 1), transcoder: t}
+	t.clients[c] = true
+
+	return c
+}
+
+func profileViewers() map[string]int {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	viewers := make(map[string]int)
+	for p, t := range transcoders {
+		viewers[p.String()] = len(t.clients)
+	}
+
+	return viewers
+}
+
+func (t *transcoder) remove(c *Client) {
+	pMutex.Lock()
+
+	if !t.clients[c] {
+		pMutex.Unlock()
+		return
+	}
+
+	delete(t.clients, c)
+
+	if len(t.clients) > 0 {
+		pMutex.Unlock()
+		return
+	}
+
+	glg.Debugf("Stopping stream transcoder for profile: %s", t.profile)
+
+	delete(transcoders, t.profile)
+	close(t.done)
+
+	pMutex.Unlock()
+
+	Unsubscribe(t.source)
+}
+
+func (t *transcoder) run() {
+	var last time.Time
+	var interval time.Duration
+
+	if t.profile.FPS > 0 {
+		// 10% tolerance to absorb jitter on frames coming from motion
+		interval = time.Duration(float64(time.Second) / t.profile.FPS * 0.9)
+	}
+
+	for {
+		select {
+		case frame, ok := <-t.source.C:
+			if !ok {
+				t.close()
+				return
+			}
+
+			if interval > 0 && frame.Time.Sub(last) < interval {
+				continue
+			}
+			last = frame.Time
+
+			data, err := t.profile.Transform(frame.Data)
+			if err != nil {
+				glg.Warnf("Dropping frame %d: %v", frame.Seq, err)
+				continue
+			}
+
+			t.broadcast(&Frame{Data: data, Time: frame.Time, Seq: frame.Seq})
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *transcoder) broadcast(frame *Frame) {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	for c := range t.clients {
+		push(c, frame)
+	}
+}
+
+// close disconnects every client, called when the hub is shutting down
+func (t *transcoder) close() {
+	pMutex.Lock()
+	defer pMutex.Unlock()
+
+	for c := range t.clients {
+		delete(t.clients, c)
+		close(c.C)
+	}
+
+	if transcoders[t.profile] == t {
+		delete(transcoders, t.profile)
+	}
+}
@@ -0,0 +1,195 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testFrame = []byte{0xFF, 0xD8, 0x01, 0x02, 0xFF, 0x00, 0xFF, 0xD9}
+
+func TestReaderContentLength(t *testing.T) {
+	var body bytes.Buffer
+
+	WriteFrame(&body, testFrame)
+	WriteFrame(&body, testFrame)
+
+	reader, err := newMJPEGReader(&body, ContentType())
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		frame, err := reader.NextFrame()
+		require.NoError(t, err)
+		require.Equal(t, testFrame, frame)
+	}
+
+	_, err = reader.NextFrame()
+	require.Error(t, err)
+}
+
+func TestReaderWithoutContentLength(t *testing.T) {
+	body := bytes.NewBufferString("--BoundaryString\r\nContent-type: image/jpeg\r\n\r\n")
+	body.Write(testFrame)
+	body.WriteString("\r\n--BoundaryString\r\nContent-type: image/jpeg\r\n\r\n")
+	body.Write(testFrame)
+
+	reader, err := newMJPEGReader(body, "multipart/x-mixed-replace; boundary=--BoundaryString")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		frame, err := reader.NextFrame()
+		require.NoError(t, err)
+		require.Equal(t, testFrame, frame)
+	}
+}
+
+func TestReaderInvalidContentType(t *testing.T) {
+	_, err := newMJPEGReader(&bytes.Buffer{}, "image/jpeg")
+	require.Error(t, err)
+}
+
+func fakeMotionStream(frame []byte, connections *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*connections++
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=BoundaryString")
+		for {
+			fmt.Fprintf(w, "--BoundaryString\r\nContent-type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			w.Write(frame)
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+}
+
+func testJPEG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	return buf.Bytes()
+}
+
+func TestHub(t *testing.T) {
+	connections := 0
+
+	server := fakeMotionStream(testFrame, &connections)
+	defer server.Close()
+
+	upstreamURL = func() string { return server.URL }
+
+	c1 := Subscribe()
+	c2 := Subscribe()
+
+	require.Equal(t, testFrame, (<-c1.C).Data)
+	require.Equal(t, testFrame, (<-c2.C).Data)
+
+	Unsubscribe(c2)
+
+	<-c1.C
+	<-c1.C
+
+	stats := GetStats()
+	require.Equal(t, 1, stats.Viewers)
+	require.True(t, stats.UpstreamConnected)
+	require.True(t, stats.UpstreamFPS > 0)
+	require.NotNil(t, Latest())
+	require.Equal(t, 1, connections)
+
+	Shutdown()
+
+	_, ok := <-c1.C
+	require.False(t, ok)
+	require.Equal(t, 0, GetStats().Viewers)
+}
+
+func TestProfileValidate(t *testing.T) {
+	require.NoError(t, Profile{}.Validate())
+	require.NoError(t, Profile{FPS: 2, Width: 640, Quality: 60}.Validate())
+	require.Error(t, Profile{FPS: -1}.Validate())
+	require.Error(t, Profile{Width: 10}.Validate())
+	require.Error(t, Profile{Quality: 101}.Validate())
+}
+
+func TestProfileTransform(t *testing.T) {
+	frame := testJPEG(t, 640, 480)
+
+	same, err := Profile{FPS: 1}.Transform(frame)
+	require.NoError(t, err)
+	require.Equal(t, frame, same)
+
+	scaled, err := Profile{Width: 320, Quality: 50}.Transform(frame)
+	require.NoError(t, err)
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(scaled))
+	require.NoError(t, err)
+	require.Equal(t, 320, config.Width)
+	require.Equal(t, 240, config.Height)
+
+	_, err = Profile{Width: 320}.Transform(testFrame)
+	require.Error(t, err)
+}
+
+func TestSharedProfile(t *testing.T) {
+	connections := 0
+
+	server := fakeMotionStream(testJPEG(t, 640, 480), &connections)
+	defer server.Close()
+
+	upstreamURL = func() string { return server.URL }
+
+	profile := Profile{Width: 160}
+
+	c1 := SubscribeProfile(profile)
+	c2 := SubscribeProfile(profile)
+	c3 := Subscribe()
+
+	for _, c := range []*Client{c1, c2} {
+		config, err := jpeg.DecodeConfig(bytes.NewReader((<-c.C).Data))
+		require.NoError(t, err)
+		require.Equal(t, 160, config.Width)
+	}
+
+	stats := GetStats()
+	require.Equal(t, 3, stats.Viewers)
+	require.Equal(t, 2, stats.Profiles[profile.String()])
+	require.Len(t, transcoders, 1)
+
+	Unsubscribe(c1)
+	Unsubscribe(c2)
+
+	require.Len(t, transcoders, 0)
+	require.Equal(t, 1, GetStats().Viewers)
+
+	Unsubscribe(c3)
+	Shutdown()
+
+	require.Equal(t, 1, connections)
+}
+
+func TestWaitFrame(t *testing.T) {
+	connections := 0
+
+	server := fakeMotionStream(testFrame, &connections)
+	defer server.Close()
+
+	upstreamURL = func() string { return server.URL }
+
+	frame, err := WaitFrame(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, testFrame, frame.Data)
+	require.Equal(t, 0, GetStats().Viewers)
+
+	Shutdown()
+}
@@ -0,0 +1,398 @@
+package thumbnail
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	// registered to let image.Decode read gif pictures
+	_ "image/gif"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	// registered to let image.Decode read webp pictures
+	_ "golang.org/x/image/webp"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	// DefaultFolder is hidden, so it is skipped by backup and target_dir listing
+	DefaultFolder       = ".thumbnails"
+	DefaultMaxCacheSize = "50MB"
+	DefaultSize         = 160
+
+	MaxSize = 1024
+
+	indexFile     = "index.json"
+	jpegQuality   = 80
+	sweepInterval = 10 * time.Minute
+)
+
+var (
+	// ErrUnsupported is returned when the source file is not a picture
+	ErrUnsupported = fmt.Errorf("unsupported file type")
+)
+
+// entry is a cached thumbnail
+type entry struct {
+	File   string `json:"file"`
+	Source string `json:"source"`
+	Size   int64  `json:"size"`
+
+	element *list.Element
+}
+
+var (
+	tMutex       sync.Mutex
+	cacheFolder  string
+	maxCacheSize int64
+	cacheSize    int64
+	entries      map[string]*entry
+	lru          *list.List
+	quit         chan struct{}
+)
+
+func Init(conf config.Thumbnail, targetDir string) error {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if entries != nil {
+		return fmt.Errorf("Thumbnail cache already initialized")
+	}
+
+	maxSize := conf.MaxCacheSize
+	if maxSize == "" {
+		maxSize = DefaultMaxCacheSize
+	}
+
+	size, err := utils.FromTextSize(maxSize)
+	if err != nil {
+		return fmt.Errorf("Invalid thumbnail 'maxCacheSize' (%s): %v", maxSize, err)
+	}
+
+	folder := filepath.Join(targetDir, DefaultFolder)
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return fmt.Errorf("Unable to create thumbnail folder %s: %v", folder, err)
+	}
+
+	cacheFolder = folder
+	maxCacheSize = size
+	cacheSize = 0
+	entries = make(map[string]*entry)
+	lru = list.New()
+
+	loadIndex()
+
+	quit = make(chan struct{})
+	go sweeper(quit)
+
+	glg.Infof("Thumbnails cached in %s (%d entries, max size: %s)", folder, len(entries), maxSize)
+
+	return nil
+}
+
+func Shutdown() {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	glg.Info("Shuting down thumbnail cache")
+
+	if entries == nil {
+		return
+	}
+
+	close(quit)
+
+	if err := saveIndex(); err != nil {
+		glg.Errorf("Unable to save thumbnail index: %v", err)
+	}
+
+	entries = nil
+	lru = nil
+}
+
+// Get returns the path of the thumbnail of source, at most size pixels wide or high, building it when not cached
+func Get(source string, size int) (string, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", filepath.Base(source))
+	}
+
+	if !isPicture(source) {
+		return "", ErrUnsupported
+	}
+
+	//Source modification time and size are part of the key, changed files get a new thumbnail
+	file := cacheFileName(source, size, info)
+
+	tMutex.Lock()
+	if entries == nil {
+		tMutex.Unlock()
+		return "", fmt.Errorf("Thumbnail cache is not ready")
+	}
+
+	if e := entries[file]; e != nil {
+		lru.MoveToFront(e.element)
+		tMutex.Unlock()
+		return filepath.Join(cacheFolder, file), nil
+	}
+	folder := cacheFolder
+	tMutex.Unlock()
+
+	written, err := generate(source, filepath.Join(folder, file), size)
+	if err != nil {
+		return "", err
+	}
+
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if entries == nil {
+		return "", fmt.Errorf("Thumbnail cache is not ready")
+	}
+
+	//Drop stale thumbnails of the same source and size
+	prefix := sourcePrefix(source, size)
+	for name, e := range entries {
+		if name != file && strings.HasPrefix(name, prefix) {
+			removeEntry(e)
+		}
+	}
+
+	if entries[file] == nil {
+		add(&entry{File: file, Source: source, Size: written})
+	}
+
+	evict()
+
+	return filepath.Join(cacheFolder, file), nil
+}
+
+// Invalidate removes every thumbnail of source
+func Invalidate(source string) {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	for _, e := range entries {
+		if e.Source == source {
+			removeEntry(e)
+		}
+	}
+}
+
+// Sweep removes thumbnails whose source file has been removed or changed (e.g. by backup)
+func Sweep() int {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	removed := 0
+	for _, e := range entries {
+		info, err := os.Stat(e.Source)
+		if err != nil || !strings.HasSuffix(e.File, sourceVersion(info)) {
+			removeEntry(e)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+func sweeper(quit chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := Sweep(); removed > 0 {
+				glg.Debugf("%d stale thumbnails removed", removed)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// CacheSize returns number and overall size of cached thumbnails
+func CacheSize() (int, int64) {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	return len(entries), cacheSize
+}
+
+func isPicture(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".webp", ".ppm", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+func sourcePrefix(source string, size int) string {
+	sum := sha1.Sum([]byte(source))
+	prefix := hex.EncodeToString(sum[:10]) + "_"
+
+	if size > 0 {
+		prefix += fmt.Sprintf("%d_", size)
+	}
+
+	return prefix
+}
+
+func sourceVersion(info os.FileInfo) string {
+	return fmt.Sprintf("_%x_%x.jpg", info.ModTime().UnixNano(), info.Size())
+}
+
+func cacheFileName(source string, size int, info os.FileInfo) string {
+	return strings.TrimSuffix(sourcePrefix(source, size), "_") + sourceVersion(info)
+}
+
+func generate(source, dest string, size int) (int64, error) {
+	f, err := os.Open(source)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	//Size is checked before decoding: a small header can ask for gigabytes of pixels
+	conf, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode %s: %v", filepath.Base(source), err)
+	}
+
+	if conf.Height > 0 && conf.Width > utils.MaxImagePixels/conf.Height {
+		return 0, fmt.Errorf("%s is too large: %dx%d", filepath.Base(source), conf.Width, conf.Height)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode %s: %v", filepath.Base(source), err)
+	}
+
+	thumb := utils.ScaleToFit(img, size)
+
+	//Write to a temporary file first so a partial thumbnail is never served
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".tmp")
+	if err != nil {
+		return 0, err
+	}
+
+	if err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: jpegQuality}); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), dest)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
+// add, removeEntry and evict require tMutex to be held
+func add(e *entry) {
+	e.element = lru.PushFront(e)
+	entries[e.File] = e
+	cacheSize += e.Size
+}
+
+func removeEntry(e *entry) {
+	lru.Remove(e.element)
+	delete(entries, e.File)
+	cacheSize -= e.Size
+
+	if err := os.Remove(filepath.Join(cacheFolder, e.File)); err != nil && !os.IsNotExist(err) {
+		glg.Warnf("Unable to remove thumbnail %s: %v", e.File, err)
+	}
+}
+
+func evict() {
+	for cacheSize > maxCacheSize && lru.Len() > 0 {
+		removeEntry(lru.Back().Value.(*entry))
+	}
+}
+
+// loadIndex restores cached entries (most recently used first), unknown files in cache folder are removed
+func loadIndex() {
+	var saved []entry
+
+	if raw, err := ioutil.ReadFile(filepath.Join(cacheFolder, indexFile)); err == nil {
+		if err := json.Unmarshal(raw, &saved); err != nil {
+			glg.Warnf("Invalid thumbnail index, cache will be rebuilt: %v", err)
+			saved = nil
+		}
+	}
+
+	for i := range saved {
+		e := saved[i]
+		info, err := os.Stat(filepath.Join(cacheFolder, e.File))
+		if err != nil || entries[e.File] != nil || filepath.Base(e.File) != e.File {
+			continue
+		}
+
+		e.Size = info.Size()
+		e.element = lru.PushBack(&e)
+		entries[e.File] = &e
+		cacheSize += e.Size
+	}
+
+	files, err := ioutil.ReadDir(cacheFolder)
+	if err != nil {
+		glg.Warnf("Unable to read thumbnail folder: %v", err)
+		return
+	}
+
+	for _, f := range files {
+		if f.Name() != indexFile && entries[f.Name()] == nil {
+			os.Remove(filepath.Join(cacheFolder, f.Name()))
+		}
+	}
+
+	evict()
+}
+
+func saveIndex() error {
+	saved := make([]entry, 0, lru.Len())
+	for el := lru.Front(); el != nil; el = el.Next() {
+		saved = append(saved, *el.Value.(*entry))
+	}
+
+	raw, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(cacheFolder, indexFile), raw, 0644)
+}
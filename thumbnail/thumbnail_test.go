@@ -0,0 +1,158 @@
+package thumbnail
+
+import (
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func writePicture(t *testing.T, path string, width, height int) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, jpeg.Encode(f, image.NewGray(image.Rect(0, 0, width, height)), nil))
+	require.NoError(t, f.Close())
+}
+
+func thumbnailSize(t *testing.T, path string) (int, int) {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	config, err := jpeg.DecodeConfig(f)
+	require.NoError(t, err)
+
+	return config.Width, config.Height
+}
+
+func TestThumbnail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbnail")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, Init(config.Thumbnail{}, dir))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Thumbnail{}, dir))
+
+	source := filepath.Join(dir, "01.jpg")
+	writePicture(t, source, 640, 480)
+
+	thumb, err := Get(source, 160)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, DefaultFolder), filepath.Dir(thumb))
+
+	width, height := thumbnailSize(t, thumb)
+	require.Equal(t, 160, width)
+	require.Equal(t, 120, height)
+
+	cached, err := Get(source, 160)
+	require.NoError(t, err)
+	require.Equal(t, thumb, cached)
+
+	//Portrait pictures fit in size too
+	portrait := filepath.Join(dir, "02.jpg")
+	writePicture(t, portrait, 300, 600)
+
+	thumb2, err := Get(portrait, 100)
+	require.NoError(t, err)
+
+	width, height = thumbnailSize(t, thumb2)
+	require.Equal(t, 50, width)
+	require.Equal(t, 100, height)
+
+	//Changed source gets a new thumbnail, stale one is removed
+	writePicture(t, source, 320, 320)
+	mtime := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(source, mtime, mtime))
+
+	changed, err := Get(source, 160)
+	require.NoError(t, err)
+	require.NotEqual(t, thumb, changed)
+	require.NoFileExists(t, thumb)
+
+	n, _ := CacheSize()
+	require.Equal(t, 2, n)
+
+	//Invalidate
+	Invalidate(source)
+	require.NoFileExists(t, changed)
+
+	//Sweep
+	require.NoError(t, os.Remove(portrait))
+	require.Equal(t, 1, Sweep())
+	require.NoFileExists(t, thumb2)
+
+	n, size := CacheSize()
+	require.Equal(t, 0, n)
+	require.Equal(t, int64(0), size)
+
+	//Unsupported sources
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "movie.mkv"), []byte{}, 0666))
+	_, err = Get(filepath.Join(dir, "movie.mkv"), 160)
+	require.Equal(t, ErrUnsupported, err)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.jpg"), []byte{0xFF, 0xD8}, 0666))
+	_, err = Get(filepath.Join(dir, "broken.jpg"), 160)
+	require.Error(t, err)
+
+	//Size is checked before decoding
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "huge.ppm"), []byte("P6 200000 200000 255 "), 0666))
+	_, err = Get(filepath.Join(dir, "huge.ppm"), 160)
+	require.Error(t, err)
+
+	huge := append([]byte("GIF89a"), 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 0x00, 0x00)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "huge.gif"), huge, 0666))
+	_, err = Get(filepath.Join(dir, "huge.gif"), 160)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too large")
+}
+
+func TestEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "thumbnail")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, Init(config.Thumbnail{MaxCacheSize: "1KB"}, dir))
+
+	var thumbs []string
+	for _, name := range []string{"01.jpg", "02.jpg", "03.jpg", "04.jpg"} {
+		writePicture(t, filepath.Join(dir, name), 64, 64)
+
+		thumb, err := Get(filepath.Join(dir, name), 64)
+		require.NoError(t, err)
+		thumbs = append(thumbs, thumb)
+	}
+
+	_, size := CacheSize()
+	require.True(t, size <= 1000)
+
+	//Least recently used are evicted first
+	require.NoFileExists(t, thumbs[0])
+	require.FileExists(t, thumbs[3])
+
+	//Index survives restart
+	n, _ := CacheSize()
+	Shutdown()
+
+	require.Error(t, Init(config.Thumbnail{MaxCacheSize: "big"}, dir))
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, DefaultFolder, "unknown.jpg"), []byte{}, 0666))
+	require.NoError(t, Init(config.Thumbnail{MaxCacheSize: "1KB"}, dir))
+	defer Shutdown()
+
+	restored, _ := CacheSize()
+	require.Equal(t, n, restored)
+	require.NoFileExists(t, filepath.Join(dir, DefaultFolder, "unknown.jpg"))
+
+	thumb, err := Get(filepath.Join(dir, "04.jpg"), 64)
+	require.NoError(t, err)
+	require.Equal(t, thumbs[3], thumb)
+}
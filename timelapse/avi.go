@@ -0,0 +1,178 @@
+package timelapse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	aviHeaderSize = 224
+
+	// offsets of the header fields that are known only when all frames have been written
+	aviRiffSizeOffset       = 4
+	aviTotalFramesOffset    = 48
+	aviMainBufferSizeOffset = 60
+	aviStreamLengthOffset   = 140
+	aviStreamBufferOffset   = 144
+	aviMoviSizeOffset       = 216
+
+	aviFlagHasIndex = 0x10
+	aviFlagKeyFrame = 0x10
+
+	aviDefaultQuality uint32 = 0xFFFFFFFF
+)
+
+// aviMaxSize is the largest file whose sizes and offsets fit the 32 bits fields of RIFF, replaced in tests
+var aviMaxSize uint64 = math.MaxUint32
+
+type aviIndexEntry struct {
+	offset uint32
+	size   uint32
+}
+
+// aviWriter writes a Motion-JPEG AVI file, every frame must be a JPEG picture of width x height pixels
+type aviWriter struct {
+	w        io.WriteSeeker
+	index    []aviIndexEntry
+	position uint32
+	maxFrame uint32
+}
+
+func newAVIWriter(w io.WriteSeeker, width, height, fps int) (*aviWriter, error) {
+	header := []interface{}{
+		[]byte("RIFF"), uint32(0), []byte("AVI "),
+		[]byte("LIST"), uint32(192), []byte("hdrl"),
+		// main header
+		[]byte("avih"), uint32(56),
+		uint32(1000000 / fps), uint32(0), uint32(0), uint32(aviFlagHasIndex),
+		uint32(0), uint32(0), uint32(1), uint32(0),
+		uint32(width), uint32(height), [4]uint32{},
+		// stream header
+		[]byte("LIST"), uint32(116), []byte("strl"),
+		[]byte("strh"), uint32(56),
+		[]byte("vids"), []byte("MJPG"), uint32(0), uint16(0), uint16(0),
+		uint32(0), uint32(1), uint32(fps), uint32(0),
+		uint32(0), uint32(0), aviDefaultQuality, uint32(0),
+		[4]uint16{0, 0, uint16(width), uint16(height)},
+		// stream format (BITMAPINFOHEADER)
+		[]byte("strf"), uint32(40),
+		uint32(40), int32(width), int32(height), uint16(1), uint16(24),
+		[]byte("MJPG"), uint32(width * height * 3), int32(0), int32(0), uint32(0), uint32(0),
+		// frames
+		[]byte("LIST"), uint32(0), []byte("movi"),
+	}
+
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
+	// 'movi' fourcc is the reference for chunk offsets in index
+	return &aviWriter{w: w, position: 4}, nil
+}
+
+func (a *aviWriter) WriteFrame(frame []byte) error {
+	//Header, frames and index, this one included, must fit: offsets would wrap silently
+	movi := uint64(a.position) + 8 + uint64(len(frame)) + uint64(len(frame)%2)
+	if aviHeaderSize-4+movi+8+16*uint64(len(a.index)+1) > aviMaxSize {
+		return fmt.Errorf("AVI file would be larger than %d bytes, reduce 'maxFrames' or 'width'", aviMaxSize)
+	}
+
+	size := uint32(len(frame))
+
+	if err := a.writeChunk("00dc", frame); err != nil {
+		return err
+	}
+
+	a.index = append(a.index, aviIndexEntry{offset: a.position, size: size})
+	a.position += 8 + size + size%2
+
+	if size > a.maxFrame {
+		a.maxFrame = size
+	}
+
+	return nil
+}
+
+// Close writes the index and fixes header fields, underlying writer is not closed
+func (a *aviWriter) Close() error {
+	index := make([]byte, 0, 16*len(a.index))
+
+	for _, entry := range a.index {
+		index = append(index, "00dc"...)
+		index = appendUint32(index, aviFlagKeyFrame)
+		index = appendUint32(index, entry.offset)
+		index = appendUint32(index, entry.size)
+	}
+
+	if err := a.writeChunk("idx1", index); err != nil {
+		return err
+	}
+
+	fileSize, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	frames := uint32(len(a.index))
+
+	fields := []struct {
+		offset int64
+		value  uint32
+	}{
+		{aviRiffSizeOffset, uint32(fileSize - 8)},
+		{aviTotalFramesOffset, frames},
+		{aviMainBufferSizeOffset, a.maxFrame},
+		{aviStreamLengthOffset, frames},
+		{aviStreamBufferOffset, a.maxFrame},
+		{aviMoviSizeOffset, a.position},
+	}
+
+	for _, f := range fields {
+		if _, err := a.w.Seek(f.offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := binary.Write(a.w, binary.LittleEndian, f.value); err != nil {
+			return err
+		}
+	}
+
+	_, err = a.w.Seek(fileSize, io.SeekStart)
+
+	return err
+}
+
+func (a *aviWriter) writeChunk(fourcc string, data []byte) error {
+	if len(fourcc) != 4 {
+		return fmt.Errorf("invalid fourcc: %s", fourcc)
+	}
+
+	header := appendUint32([]byte(fourcc), uint32(len(data)))
+
+	if _, err := a.w.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := a.w.Write(data); err != nil {
+		return err
+	}
+
+	// chunks are word aligned
+	if len(data)%2 == 1 {
+		if _, err := a.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
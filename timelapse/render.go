@@ -0,0 +1,244 @@
+package timelapse
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	// registered to let image.Decode read webp pictures
+	_ "golang.org/x/image/webp"
+
+	"github.com/andreacioni/motionctrl/motion"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+const (
+	aviQuality = 85
+
+	// gifHeaderSize is the size of signature and logical screen descriptor
+	gifHeaderSize = 13
+	gifTrailer    = 0x3B
+)
+
+// gifLoopForever is the NETSCAPE2.0 application extension with loop count 0
+var gifLoopForever = []byte{0x21, 0xFF, 0x0B, 'N', 'E', 'T', 'S', 'C', 'A', 'P', 'E', '2', '.', '0', 0x03, 0x01, 0x00, 0x00, 0x00}
+
+type picture struct {
+	path    string
+	modTime int64
+}
+
+// selectPictures walks target directory, hidden folders excluded, and returns pictures matching request sorted by time
+func selectPictures(root string, req Request) ([]string, error) {
+	var pictures []picture
+
+	err := motion.WalkTargetDir(root, func(file motion.TargetDirFile) {
+		if match, _ := filepath.Match(req.Glob, path.Base(file.Name)); !match {
+			return
+		}
+
+		if (!req.From.IsZero() && file.ModTime.Before(req.From)) || (!req.To.IsZero() && file.ModTime.After(req.To)) {
+			return
+		}
+
+		pictures = append(pictures, picture{path: filepath.Join(root, filepath.FromSlash(file.Name)), modTime: file.ModTime.UnixNano()})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pictures, func(i, j int) bool {
+		if pictures[i].modTime == pictures[j].modTime {
+			return pictures[i].path < pictures[j].path
+		}
+		return pictures[i].modTime < pictures[j].modTime
+	})
+
+	var selected []string
+	for i := 0; i < len(pictures); i += req.Every {
+		selected = append(selected, pictures[i].path)
+	}
+
+	return subsample(selected, req.MaxFrames), nil
+}
+
+// subsample evenly picks max elements from list
+func subsample(list []string, max int) []string {
+	if max <= 0 || len(list) <= max {
+		return list
+	}
+
+	sampled := make([]string, max)
+	for i := range sampled {
+		sampled[i] = list[i*len(list)/max]
+	}
+
+	return sampled
+}
+
+func render(job *Job, filePath string) error {
+	req := job.Request
+
+	pictures, err := selectPictures(targetDirectory, req)
+	if err != nil {
+		return fmt.Errorf("unable to select pictures: %v", err)
+	}
+
+	if len(pictures) == 0 {
+		return fmt.Errorf("no picture matches the request")
+	}
+
+	job.setProgress(0, len(pictures))
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var encoder frameEncoder
+	if req.Format == FormatAVI {
+		encoder = &aviEncoder{file: out, fps: req.FPS}
+	} else {
+		encoder = &gifEncoder{file: out, delay: 100 / req.FPS}
+	}
+
+	var bounds image.Rectangle
+	frames := 0
+
+	for i, p := range pictures {
+		if job.canceled() {
+			return fmt.Errorf("canceled")
+		}
+
+		img, err := decodePicture(p)
+		if err != nil {
+			//A single unreadable picture (e.g. still being written) doesn't ruin the whole time-lapse
+			job.setProgress(i+1, len(pictures))
+			continue
+		}
+
+		img = utils.ScaleToWidth(img, req.Width)
+
+		//Every frame must have the size of the first one
+		if frames == 0 {
+			bounds = img.Bounds()
+		} else if img.Bounds().Size() != bounds.Size() {
+			img = utils.Scale(img, bounds.Dx(), bounds.Dy())
+		}
+
+		if err := encoder.Add(img); err != nil {
+			return err
+		}
+
+		frames++
+		job.setProgress(i+1, len(pictures))
+	}
+
+	if frames == 0 {
+		return fmt.Errorf("none of the %d selected pictures could be decoded", len(pictures))
+	}
+
+	return encoder.Close()
+}
+
+func decodePicture(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+
+	return img, err
+}
+
+type frameEncoder interface {
+	Add(image.Image) error
+	Close() error
+}
+
+// gifEncoder writes every frame to file as soon as it is added, so only the current frame is kept in memory
+type gifEncoder struct {
+	file   *os.File
+	delay  int
+	frames int
+	buf    bytes.Buffer
+}
+
+func (g *gifEncoder) Add(img image.Image) error {
+	paletted := image.NewPaletted(img.Bounds(), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, img.Bounds(), img, img.Bounds().Min)
+
+	//A GIF with a single frame has no global color table: its image block is valid in any GIF with the same screen size
+	g.buf.Reset()
+	if err := gif.EncodeAll(&g.buf, &gif.GIF{Image: []*image.Paletted{paletted}, Delay: []int{g.delay}}); err != nil {
+		return err
+	}
+
+	data := g.buf.Bytes()
+	if len(data) <= gifHeaderSize || data[len(data)-1] != gifTrailer {
+		return fmt.Errorf("unexpected GIF frame")
+	}
+
+	if g.frames == 0 {
+		if _, err := g.file.Write(data[:gifHeaderSize]); err != nil {
+			return err
+		}
+
+		if _, err := g.file.Write(gifLoopForever); err != nil {
+			return err
+		}
+	}
+
+	if _, err := g.file.Write(data[gifHeaderSize : len(data)-1]); err != nil {
+		return err
+	}
+
+	g.frames++
+
+	return nil
+}
+
+func (g *gifEncoder) Close() error {
+	_, err := g.file.Write([]byte{gifTrailer})
+	return err
+}
+
+type aviEncoder struct {
+	file *os.File
+	fps  int
+	avi  *aviWriter
+	buf  bytes.Buffer
+}
+
+func (a *aviEncoder) Add(img image.Image) error {
+	var err error
+
+	if a.avi == nil {
+		if a.avi, err = newAVIWriter(a.file, img.Bounds().Dx(), img.Bounds().Dy(), a.fps); err != nil {
+			return err
+		}
+	}
+
+	a.buf.Reset()
+	if err = jpeg.Encode(&a.buf, img, &jpeg.Options{Quality: aviQuality}); err != nil {
+		return err
+	}
+
+	return a.avi.WriteFrame(a.buf.Bytes())
+}
+
+func (a *aviEncoder) Close() error {
+	return a.avi.Close()
+}
@@ -0,0 +1,450 @@
+package timelapse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+
+	"github.com/andreacioni/motionctrl/config"
+	"github.com/andreacioni/motionctrl/utils"
+)
+
+type State string
+
+const (
+	StateQueued   State = "QUEUED"
+	StateRunning  State = "RUNNING"
+	StateDone     State = "DONE"
+	StateFailed   State = "FAILED"
+	StateCanceled State = "CANCELED"
+)
+
+const (
+	FormatGIF = "gif"
+	FormatAVI = "avi"
+
+	DefaultFolder = ".timelapse"
+	DefaultGlob   = "*.jpg"
+	DefaultFPS    = 10
+	DefaultWidth  = 640
+
+	MaxFPS       = 60
+	MaxGIFFrames = 500
+
+	queueSize = 16
+
+	// partialSuffix is appended to files being rendered, they are renamed when complete
+	partialSuffix = ".part"
+)
+
+// outputFileRegex matches rendered time-lapses, their metadata is kept in a JSON file with the same name
+var outputFileRegex = regexp.MustCompile(`^timelapse_([0-9a-f]+)\.(gif|avi)$`)
+
+// Request describes which pictures are used and how the time-lapse is rendered
+type Request struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	Glob      string    `json:"glob"`
+	Every     int       `json:"every"`
+	MaxFrames int       `json:"maxFrames"`
+	Format    string    `json:"format"`
+	FPS       int       `json:"fps"`
+	Width     int       `json:"width"`
+}
+
+type Job struct {
+	ID       string    `json:"id"`
+	Request  Request   `json:"request"`
+	State    State     `json:"state"`
+	Progress int       `json:"progress"`
+	Frames   int       `json:"frames"`
+	Total    int       `json:"totalFrames"`
+	File     string    `json:"file,omitempty"`
+	Size     int64     `json:"size,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+
+	cancel chan struct{}
+}
+
+var (
+	jMutex sync.Mutex
+	jobs   map[string]*Job
+
+	queue           chan *Job
+	quit            chan struct{}
+	workerGroup     sync.WaitGroup
+	outputFolder    string
+	targetDirectory string
+)
+
+func Init(conf config.Timelapse, targetDir string) error {
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	if queue != nil {
+		return fmt.Errorf("Time-lapse service already initialized")
+	}
+
+	folder := conf.Folder
+	if folder == "" {
+		folder = filepath.Join(targetDir, DefaultFolder)
+	}
+
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		return fmt.Errorf("Unable to create time-lapse folder %s: %v", folder, err)
+	}
+
+	glg.Infof("Time-lapse files will be stored in: %s", folder)
+
+	outputFolder = folder
+	targetDirectory = targetDir
+	jobs = loadJobs(folder)
+	queue = make(chan *Job, queueSize)
+	quit = make(chan struct{})
+
+	workerGroup.Add(1)
+	go worker(queue, quit)
+
+	return nil
+}
+
+func Shutdown() {
+	jMutex.Lock()
+
+	glg.Info("Shuting down time-lapse service")
+
+	if queue == nil {
+		jMutex.Unlock()
+		return
+	}
+
+	close(quit)
+	for _, job := range jobs {
+		job.stop()
+	}
+
+	queue = nil
+	jobs = nil
+
+	jMutex.Unlock()
+
+	workerGroup.Wait()
+}
+
+// Create validates the request and queues a new job
+func Create(req Request) (Job, error) {
+	if err := normalize(&req); err != nil {
+		return Job{}, err
+	}
+
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	if queue == nil {
+		return Job{}, fmt.Errorf("Time-lapse service is not ready")
+	}
+
+	id, err := utils.RandomID(8)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := &Job{ID: id, Request: req, State: StateQueued, Created: time.Now(), cancel: make(chan struct{})}
+
+	select {
+	case queue <- job:
+		jobs[id] = job
+	default:
+		return Job{}, fmt.Errorf("too many time-lapse jobs queued")
+	}
+
+	glg.Infof("Time-lapse job %s queued: %+v", id, req)
+
+	return *job, nil
+}
+
+func List() []Job {
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	list := []Job{}
+	for _, job := range jobs {
+		list = append(list, *job)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+
+	return list
+}
+
+func Get(id string) (Job, error) {
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	if job := jobs[id]; job != nil {
+		return *job, nil
+	}
+
+	return Job{}, fmt.Errorf("time-lapse job %s not found", id)
+}
+
+// OutputFile returns the path of the rendered time-lapse
+func OutputFile(id string) (string, error) {
+	job, err := Get(id)
+
+	if err != nil {
+		return "", err
+	}
+
+	if job.State != StateDone {
+		return "", fmt.Errorf("time-lapse job %s is %s", id, job.State)
+	}
+
+	return filepath.Join(outputFolder, job.File), nil
+}
+
+// Remove cancels the job, if still running, and deletes its output
+func Remove(id string) error {
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	job := jobs[id]
+	if job == nil {
+		return fmt.Errorf("time-lapse job %s not found", id)
+	}
+
+	job.stop()
+	delete(jobs, id)
+
+	if job.File != "" {
+		if err := os.Remove(filepath.Join(outputFolder, job.File)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Unable to remove %s: %v", job.File, err)
+		}
+
+		if err := os.Remove(metadataFile(filepath.Join(outputFolder, job.File))); err != nil && !os.IsNotExist(err) {
+			glg.Warnf("Unable to remove metadata of time-lapse %s: %v", id, err)
+		}
+	}
+
+	return nil
+}
+
+func normalize(req *Request) error {
+	if req.Glob == "" {
+		req.Glob = DefaultGlob
+	}
+
+	if _, err := filepath.Match(req.Glob, ""); err != nil {
+		return fmt.Errorf("invalid glob: %s", req.Glob)
+	}
+
+	if !req.From.IsZero() && !req.To.IsZero() && req.To.Before(req.From) {
+		return fmt.Errorf("'to' must be after 'from'")
+	}
+
+	if req.Every < 0 || req.MaxFrames < 0 {
+		return fmt.Errorf("'every' and 'maxFrames' must be positive")
+	}
+
+	if req.Every == 0 {
+		req.Every = 1
+	}
+
+	if req.Format == "" {
+		req.Format = FormatGIF
+	}
+
+	if req.Format != FormatGIF && req.Format != FormatAVI {
+		return fmt.Errorf("format must be '%s' or '%s'", FormatGIF, FormatAVI)
+	}
+
+	if req.Format == FormatGIF && (req.MaxFrames == 0 || req.MaxFrames > MaxGIFFrames) {
+		req.MaxFrames = MaxGIFFrames
+	}
+
+	if req.FPS == 0 {
+		req.FPS = DefaultFPS
+	}
+
+	if req.FPS < 0 || req.FPS > MaxFPS {
+		return fmt.Errorf("'fps' must be between 1 and %d", MaxFPS)
+	}
+
+	if req.Width == 0 {
+		req.Width = DefaultWidth
+	}
+
+	if req.Width < utils.MinImageWidth || req.Width > utils.MaxImageWidth {
+		return fmt.Errorf("'width' must be between %d and %d", utils.MinImageWidth, utils.MaxImageWidth)
+	}
+
+	return nil
+}
+
+func worker(queue chan *Job, quit chan struct{}) {
+	defer workerGroup.Done()
+
+	for {
+		select {
+		case job := <-queue:
+			run(job)
+		case <-quit:
+			return
+		}
+	}
+}
+
+func run(job *Job) {
+	jMutex.Lock()
+	if job.State != StateQueued {
+		jMutex.Unlock()
+		return
+	}
+	job.State = StateRunning
+	job.Started = time.Now()
+	req := job.Request
+	jMutex.Unlock()
+
+	glg.Infof("Rendering time-lapse %s", job.ID)
+
+	fileName := fmt.Sprintf("timelapse_%s.%s", job.ID, req.Format)
+	filePath := filepath.Join(outputFolder, fileName)
+
+	err := render(job, filePath+partialSuffix)
+	if err == nil {
+		err = os.Rename(filePath+partialSuffix, filePath)
+	}
+
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	job.Finished = time.Now()
+
+	switch {
+	case job.State == StateCanceled:
+		//Remove may have run after the rename, before job.File was set
+		os.Remove(filePath + partialSuffix)
+		os.Remove(filePath)
+	case err != nil:
+		glg.Errorf("Time-lapse %s failed: %v", job.ID, err)
+		job.State = StateFailed
+		job.Error = err.Error()
+		os.Remove(filePath + partialSuffix)
+	default:
+		glg.Infof("Time-lapse %s ready: %s", job.ID, filePath)
+		job.State = StateDone
+		job.Progress = 100
+		job.File = fileName
+		if info, err := os.Stat(filePath); err == nil {
+			job.Size = info.Size()
+		}
+
+		if err := saveMetadata(job); err != nil {
+			glg.Warnf("Unable to save metadata of time-lapse %s: %v", job.ID, err)
+		}
+	}
+}
+
+// loadJobs restores the jobs rendered before a restart, from the files in folder. Files of interrupted renders are removed
+func loadJobs(folder string) map[string]*Job {
+	loaded := make(map[string]*Job)
+
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		glg.Warnf("Unable to list time-lapse folder %s: %v", folder, err)
+		return loaded
+	}
+
+	for _, info := range files {
+		if strings.HasSuffix(info.Name(), partialSuffix) {
+			os.Remove(filepath.Join(folder, info.Name()))
+			continue
+		}
+
+		match := outputFileRegex.FindStringSubmatch(info.Name())
+		if match == nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		//Files rendered by older versions have no metadata
+		job := &Job{
+			ID:       match[1],
+			Request:  Request{Format: match[2]},
+			Created:  info.ModTime(),
+			Finished: info.ModTime(),
+		}
+
+		if data, err := ioutil.ReadFile(metadataFile(filepath.Join(folder, info.Name()))); err == nil {
+			if err := json.Unmarshal(data, job); err != nil {
+				glg.Warnf("Invalid metadata of time-lapse %s: %v", info.Name(), err)
+			}
+		}
+
+		job.ID, job.File, job.Size = match[1], info.Name(), info.Size()
+		job.State, job.Progress, job.Error = StateDone, 100, ""
+		job.cancel = make(chan struct{})
+
+		loaded[job.ID] = job
+	}
+
+	if len(loaded) > 0 {
+		glg.Infof("%d time-lapse files restored", len(loaded))
+	}
+
+	return loaded
+}
+
+// saveMetadata writes the job next to its file, jMutex must be held
+func saveMetadata(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(metadataFile(filepath.Join(outputFolder, job.File)), data, 0644)
+}
+
+// metadataFile is the JSON file that describes the time-lapse in file
+func metadataFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+}
+
+// stop cancels a queued or running job, jMutex must be held
+func (job *Job) stop() {
+	if job.State == StateQueued || job.State == StateRunning {
+		job.State = StateCanceled
+		close(job.cancel)
+	}
+}
+
+func (job *Job) canceled() bool {
+	select {
+	case <-job.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (job *Job) setProgress(frames, total int) {
+	jMutex.Lock()
+	defer jMutex.Unlock()
+
+	job.Frames = frames
+	job.Total = total
+	if total > 0 {
+		job.Progress = frames * 100 / total
+	}
+}
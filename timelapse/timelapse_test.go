@@ -0,0 +1,214 @@
+package timelapse
+
+import (
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func writePictures(t *testing.T, dir string, n int, start time.Time) {
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, start.Add(time.Duration(i)*time.Minute).Format("20060102150405")+"-01.jpg")
+
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		require.NoError(t, jpeg.Encode(f, image.NewGray(image.Rect(0, 0, 64, 48)), nil))
+		require.NoError(t, f.Close())
+
+		mtime := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+}
+
+func TestSubsample(t *testing.T) {
+	list := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+
+	require.Equal(t, list, subsample(list, 0))
+	require.Equal(t, list, subsample(list, 20))
+	require.Equal(t, []string{"0", "2", "4", "6", "8"}, subsample(list, 5))
+}
+
+func TestSelectPictures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timelapse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2018, 3, 14, 15, 0, 0, 0, time.Local)
+	writePictures(t, dir, 10, start)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".hidden"), 0755))
+	writePictures(t, filepath.Join(dir, ".hidden"), 2, start)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "movie.mkv"), []byte{}, 0666))
+
+	all, err := selectPictures(dir, Request{Glob: "*.jpg", Every: 1})
+	require.NoError(t, err)
+	require.Len(t, all, 10)
+
+	ranged, err := selectPictures(dir, Request{Glob: "*.jpg", Every: 1, From: start.Add(2 * time.Minute), To: start.Add(5 * time.Minute)})
+	require.NoError(t, err)
+	require.Len(t, ranged, 4)
+	require.Equal(t, all[2], ranged[0])
+
+	every, err := selectPictures(dir, Request{Glob: "*.jpg", Every: 3})
+	require.NoError(t, err)
+	require.Equal(t, []string{all[0], all[3], all[6], all[9]}, every)
+}
+
+func TestNormalize(t *testing.T) {
+	req := Request{}
+	require.NoError(t, normalize(&req))
+	require.Equal(t, DefaultGlob, req.Glob)
+	require.Equal(t, FormatGIF, req.Format)
+	require.Equal(t, MaxGIFFrames, req.MaxFrames)
+
+	require.Error(t, normalize(&Request{Format: "mp4"}))
+	require.Error(t, normalize(&Request{Glob: "["}))
+	require.Error(t, normalize(&Request{FPS: 100}))
+	require.Error(t, normalize(&Request{Width: 1}))
+	require.Error(t, normalize(&Request{From: time.Now(), To: time.Now().Add(-time.Hour)}))
+}
+
+func waitJob(t *testing.T, id string) Job {
+	for i := 0; i < 100; i++ {
+		job, err := Get(id)
+		require.NoError(t, err)
+
+		if job.State != StateQueued && job.State != StateRunning {
+			return job
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatal("time-lapse job not completed")
+	return Job{}
+}
+
+func TestJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timelapse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writePictures(t, dir, 5, time.Now().Add(-time.Hour))
+
+	require.NoError(t, Init(config.Timelapse{}, dir))
+	defer Shutdown()
+
+	require.Error(t, Init(config.Timelapse{}, dir))
+
+	//GIF
+	job, err := Create(Request{Format: FormatGIF, Width: 32})
+	require.NoError(t, err)
+
+	job = waitJob(t, job.ID)
+	require.Equal(t, StateDone, job.State, job.Error)
+	require.Equal(t, 100, job.Progress)
+	require.Equal(t, 5, job.Frames)
+
+	file, err := OutputFile(job.ID)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, DefaultFolder), filepath.Dir(file))
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	anim, err := gif.DecodeAll(f)
+	f.Close()
+	require.NoError(t, err)
+	require.Len(t, anim.Image, 5)
+	require.Equal(t, 0, anim.LoopCount)
+	require.Equal(t, 32, anim.Image[0].Bounds().Dx())
+
+	//AVI
+	job, err = Create(Request{Format: FormatAVI, FPS: 5})
+	require.NoError(t, err)
+
+	job = waitJob(t, job.ID)
+	require.Equal(t, StateDone, job.State, job.Error)
+
+	file, err = OutputFile(job.ID)
+	require.NoError(t, err)
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.Equal(t, "RIFF", string(data[0:4]))
+	require.Equal(t, uint32(len(data)-8), binary.LittleEndian.Uint32(data[aviRiffSizeOffset:]))
+	require.Equal(t, uint32(5), binary.LittleEndian.Uint32(data[aviTotalFramesOffset:]))
+	require.Equal(t, uint32(64), binary.LittleEndian.Uint32(data[64:]))
+	require.Equal(t, "movi", string(data[aviHeaderSize-4:aviHeaderSize]))
+	require.Equal(t, "00dc", string(data[aviHeaderSize:aviHeaderSize+4]))
+	require.Equal(t, "idx1", string(data[aviHeaderSize-4+int(binary.LittleEndian.Uint32(data[aviMoviSizeOffset:])):][:4]))
+
+	require.Len(t, List(), 2)
+
+	//No pictures
+	job, err = Create(Request{Glob: "*.webp"})
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, waitJob(t, job.ID).State)
+
+	_, err = OutputFile(job.ID)
+	require.Error(t, err)
+
+	//Remove
+	require.NoError(t, Remove(job.ID))
+	require.Error(t, Remove(job.ID))
+	require.Len(t, List(), 2)
+
+	//Rendered files are restored after a restart, interrupted renders are removed
+	Shutdown()
+
+	partial := filepath.Join(dir, DefaultFolder, "timelapse_0123.gif"+partialSuffix)
+	require.NoError(t, ioutil.WriteFile(partial, []byte("GIF"), 0644))
+
+	require.NoError(t, Init(config.Timelapse{}, dir))
+
+	restored := List()
+	require.Len(t, restored, 2)
+	require.Equal(t, StateDone, restored[0].State)
+	require.Equal(t, 32, restored[0].Request.Width)
+	require.Equal(t, FormatAVI, restored[1].Request.Format)
+
+	_, err = os.Stat(partial)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = OutputFile(restored[1].ID)
+	require.NoError(t, err)
+	require.NoError(t, Remove(restored[1].ID))
+
+	Shutdown()
+	require.NoError(t, Init(config.Timelapse{}, dir))
+	require.Len(t, List(), 1)
+}
+
+func TestAVIMaxSize(t *testing.T) {
+	f, err := ioutil.TempFile("", "timelapse")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	previous := aviMaxSize
+	aviMaxSize = aviHeaderSize + 2*(8+100) + 8 + 2*16
+	defer func() { aviMaxSize = previous }()
+
+	avi, err := newAVIWriter(f, 64, 48, 10)
+	require.NoError(t, err)
+
+	frame := make([]byte, 100)
+	require.NoError(t, avi.WriteFrame(frame))
+	require.NoError(t, avi.WriteFrame(frame))
+	require.Error(t, avi.WriteFrame(frame))
+	require.NoError(t, avi.Close())
+
+	info, err := f.Stat()
+	require.NoError(t, err)
+	require.Equal(t, int64(aviMaxSize), info.Size())
+}
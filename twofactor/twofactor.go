@@ -0,0 +1,433 @@
+package twofactor
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+const (
+	// RequireLogin asks enrolled users the code at login, every session is verified
+	RequireLogin = "login"
+	// RequireRoutes asks enrolled users the code only before using one of the protected routes
+	RequireRoutes = "routes"
+
+	DefaultIssuer = "motionctrl"
+	RecoveryCodes = 10
+
+	qrSize      = 256
+	period      = 30
+	skew        = 1
+	maxFailures = 5
+	lockout     = 5 * time.Minute
+)
+
+// DefaultRoutes are protected when 'totp.routes' isn't defined, they are relative to /api. A trailing * matches any suffix
+var DefaultRoutes = []string{
+	"/control/*", "/config/*", "/mask/*", "/detection/stop", "/targetdir/remove/*", "/timelapse/remove/*", "/backup/launch",
+	"/retention/run", "/auth/sessions/revoke/*", "/share/*", "/privacy/disable", "/notify/deactivate", "/totp/disable",
+}
+
+var (
+	ErrDisabled    = fmt.Errorf("two-factor authentication not enabled, 'totp.file' must be defined")
+	ErrEnrolled    = fmt.Errorf("two-factor authentication already enabled for this user")
+	ErrNotEnrolled = fmt.Errorf("two-factor authentication not enabled for this user")
+	ErrInvalidCode = fmt.Errorf("invalid code")
+	ErrLocked      = fmt.Errorf("too many invalid codes, retry later")
+)
+
+// Enrolment is the secret of a user waiting for confirmation, URL is the otpauth:// provisioning URI and QR its PNG image
+type Enrolment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	QR     []byte `json:"-"`
+}
+
+type Status struct {
+	Enabled       bool   `json:"enabled"`
+	Require       string `json:"require,omitempty"`
+	Enrolled      bool   `json:"enrolled"`
+	RecoveryCodes int    `json:"recoveryCodes"`
+}
+
+// user is stored in 'totp.file', recovery codes are saved hashed
+type user struct {
+	Secret      string    `json:"secret"`
+	Enabled     bool      `json:"enabled"`
+	Enrolled    time.Time `json:"enrolled,omitempty"`
+	Recovery    []string  `json:"recovery,omitempty"`
+	LastCounter int64     `json:"lastCounter"`
+
+	failures int
+	locked   time.Time
+}
+
+var (
+	tMutex      sync.Mutex
+	users       map[string]*user
+	file        string
+	issuer      string
+	requirement string
+	routes      []string
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Init loads enrolled users from 'totp.file', two-factor authentication is disabled if it isn't defined
+func Init(conf config.TOTP) error {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users != nil {
+		return fmt.Errorf("Two-factor authentication already initialized")
+	}
+
+	if conf.File == "" {
+		glg.Info("No 'totp.file' defined, two-factor authentication disabled")
+		return nil
+	}
+
+	req := conf.Require
+	if req == "" {
+		req = RequireRoutes
+	}
+	if req != RequireLogin && req != RequireRoutes {
+		return fmt.Errorf("'totp.require' must be '%s' or '%s'", RequireLogin, RequireRoutes)
+	}
+
+	r := conf.Routes
+	if len(r) == 0 {
+		r = DefaultRoutes
+	}
+	for _, route := range r {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("Invalid route in 'totp.routes': %s (must start with /)", route)
+		}
+	}
+
+	loaded := make(map[string]*user)
+	data, err := ioutil.ReadFile(conf.File)
+	if err == nil {
+		if err := json.Unmarshal(data, &loaded); err != nil {
+			return fmt.Errorf("Unable to parse %s: %v", conf.File, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	file, requirement, routes = conf.File, req, r
+	issuer = conf.Issuer
+	if issuer == "" {
+		issuer = DefaultIssuer
+	}
+	users = loaded
+
+	glg.Infof("Two-factor authentication enabled, required by enrolled users at %s", req)
+
+	return nil
+}
+
+func Shutdown() {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	glg.Info("Shuting down two-factor authentication")
+
+	users = nil
+}
+
+// Enabled returns true if users can enrol
+func Enabled() bool {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	return users != nil
+}
+
+// RequiredAtLogin returns true if enrolled users must give the code at login
+func RequiredAtLogin() bool {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	return users != nil && requirement == RequireLogin
+}
+
+// Protected returns true if path (relative to /api) needs a second factor
+func Protected(path string) bool {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return false
+	}
+
+	if requirement == RequireLogin {
+		return true
+	}
+
+	for _, route := range routes {
+		if strings.HasSuffix(route, "*") && strings.HasPrefix(path, strings.TrimSuffix(route, "*")) || path == route {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Enrolled returns true if name has confirmed its enrolment
+func Enrolled(name string) bool {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	u, ok := users[name]
+	return ok && u.Enabled
+}
+
+func GetStatus(name string) Status {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return Status{}
+	}
+
+	status := Status{Enabled: true, Require: requirement}
+	if u, ok := users[name]; ok && u.Enabled {
+		status.Enrolled, status.RecoveryCodes = true, len(u.Recovery)
+	}
+
+	return status
+}
+
+// Enroll generates a new secret for name, it is used only after Confirm. A previous unconfirmed enrolment is replaced
+func Enroll(name string) (Enrolment, error) {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return Enrolment{}, ErrDisabled
+	}
+
+	if u, ok := users[name]; ok && u.Enabled {
+		return Enrolment{}, ErrEnrolled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: issuer, AccountName: name})
+	if err != nil {
+		return Enrolment{}, err
+	}
+
+	img, err := key.Image(qrSize, qrSize)
+	if err != nil {
+		return Enrolment{}, err
+	}
+
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return Enrolment{}, err
+	}
+
+	users[name] = &user{Secret: key.Secret()}
+	if err := save(); err != nil {
+		return Enrolment{}, err
+	}
+
+	glg.Infof("Two-factor enrolment started for %s", name)
+
+	return Enrolment{Secret: key.Secret(), URL: key.URL(), QR: qr.Bytes()}, nil
+}
+
+// Confirm enables two-factor authentication for name if code is valid for the secret of Enroll, it returns the recovery codes
+func Confirm(name, code string) ([]string, error) {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return nil, ErrDisabled
+	}
+
+	u, ok := users[name]
+	if !ok {
+		return nil, ErrNotEnrolled
+	}
+	if u.Enabled {
+		return nil, ErrEnrolled
+	}
+
+	if err := verify(u, code, false); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := recoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	u.Enabled, u.Enrolled, u.Recovery = true, now(), hashes
+	if err := save(); err != nil {
+		return nil, err
+	}
+
+	glg.Infof("Two-factor authentication enabled for %s", name)
+
+	return codes, nil
+}
+
+// Verify checks code of name: a TOTP code, accepted once, or one of the recovery codes, which is consumed
+func Verify(name, code string) error {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return ErrDisabled
+	}
+
+	u, ok := users[name]
+	if !ok || !u.Enabled {
+		return ErrNotEnrolled
+	}
+
+	return verify(u, code, true)
+}
+
+// Disable removes the enrolment of name after checking code
+func Disable(name, code string) error {
+	tMutex.Lock()
+	defer tMutex.Unlock()
+
+	if users == nil {
+		return ErrDisabled
+	}
+
+	u, ok := users[name]
+	if !ok || !u.Enabled {
+		return ErrNotEnrolled
+	}
+
+	if err := verify(u, code, true); err != nil {
+		return err
+	}
+
+	delete(users, name)
+	glg.Infof("Two-factor authentication disabled for %s", name)
+
+	return save()
+}
+
+// verify requires tMutex to be held, after maxFailures invalid codes the user is locked out
+func verify(u *user, code string, recovery bool) error {
+	t := now()
+	if t.Before(u.locked) {
+		return ErrLocked
+	}
+
+	if validTOTP(u, code, t) || recovery && useRecoveryCode(u, code) {
+		u.failures = 0
+		return save()
+	}
+
+	u.failures++
+	if u.failures >= maxFailures {
+		u.failures, u.locked = 0, t.Add(lockout)
+		glg.Warnf("Too many invalid two-factor codes, locked out for %s", lockout)
+	}
+
+	return ErrInvalidCode
+}
+
+// validTOTP accepts codes of the previous and next period too (clock skew). A code is never accepted twice
+func validTOTP(u *user, code string, t time.Time) bool {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != 6 {
+		return false
+	}
+
+	current := t.Unix() / period
+	for counter := current - skew; counter <= current+skew; counter++ {
+		if counter <= u.LastCounter {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(u.Secret, time.Unix(counter*period, 0), totp.ValidateOpts{
+			Period:    period,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			u.LastCounter = counter
+			return true
+		}
+	}
+
+	return false
+}
+
+func useRecoveryCode(u *user, code string) bool {
+	h := hashCode(code)
+	for i, recovery := range u.Recovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(recovery)) == 1 {
+			u.Recovery = append(u.Recovery[:i], u.Recovery[i+1:]...)
+			glg.Infof("Recovery code used, %d left", len(u.Recovery))
+			return true
+		}
+	}
+
+	return false
+}
+
+// recoveryCodes returns RecoveryCodes codes (xxxx-xxxx) and their hashes
+func recoveryCodes() ([]string, []string, error) {
+	codes, hashes := make([]string, RecoveryCodes), make([]string, RecoveryCodes)
+
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i], hashes[i] = c[:4]+"-"+c[4:], hashCode(c)
+	}
+
+	return codes, hashes, nil
+}
+
+// hashCode ignores case, spaces and dashes of recovery codes
+func hashCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// save requires tMutex to be held, the file is replaced atomically
+func save() error {
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
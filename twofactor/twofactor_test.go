@@ -0,0 +1,179 @@
+package twofactor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andreacioni/motionctrl/config"
+)
+
+func testFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "twofactor")
+	require.NoError(t, err)
+
+	return filepath.Join(dir, "totp.json"), func() { os.RemoveAll(dir) }
+}
+
+func code(t *testing.T, secret string, at time.Time) string {
+	c, err := totp.GenerateCode(secret, at)
+	require.NoError(t, err)
+	return c
+}
+
+func TestInit(t *testing.T) {
+	require.NoError(t, Init(config.TOTP{}))
+	require.False(t, Enabled())
+	require.False(t, Protected("/control/startup"))
+
+	_, err := Enroll("user")
+	require.Equal(t, ErrDisabled, err)
+
+	file, clean := testFile(t)
+	defer clean()
+
+	require.Error(t, Init(config.TOTP{File: file, Require: "always"}))
+	require.Error(t, Init(config.TOTP{File: file, Routes: []string{"control/*"}}))
+
+	require.NoError(t, Init(config.TOTP{File: file}))
+	defer Shutdown()
+
+	require.Error(t, Init(config.TOTP{File: file}))
+	require.True(t, Enabled())
+	require.False(t, RequiredAtLogin())
+}
+
+func TestProtected(t *testing.T) {
+	file, clean := testFile(t)
+	defer clean()
+
+	require.NoError(t, Init(config.TOTP{File: file}))
+
+	require.True(t, Protected("/control/startup"))
+	require.True(t, Protected("/config/set"))
+	require.True(t, Protected("/mask/privacy"))
+	require.True(t, Protected("/targetdir/remove/a.jpg"))
+	require.True(t, Protected("/totp/disable"))
+	require.True(t, Protected("/retention/run"))
+	require.True(t, Protected("/timelapse/remove/0123abcd"))
+	require.True(t, Protected("/detection/stop"))
+	require.True(t, Protected("/backup/launch"))
+	require.False(t, Protected("/targetdir/list"))
+	require.False(t, Protected("/detection/start"))
+	require.False(t, Protected("/camera/stream"))
+
+	Shutdown()
+
+	require.NoError(t, Init(config.TOTP{File: file, Routes: []string{"/camera/snapshot"}}))
+	require.True(t, Protected("/camera/snapshot"))
+	require.False(t, Protected("/camera/snapshot/other"))
+	require.False(t, Protected("/control/startup"))
+
+	Shutdown()
+
+	require.NoError(t, Init(config.TOTP{File: file, Require: RequireLogin}))
+	defer Shutdown()
+
+	require.True(t, RequiredAtLogin())
+	require.True(t, Protected("/targetdir/list"))
+}
+
+func TestEnrolment(t *testing.T) {
+	current := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	file, clean := testFile(t)
+	defer clean()
+
+	require.NoError(t, Init(config.TOTP{File: file, Issuer: "camera"}))
+
+	enrolment, err := Enroll("user")
+	require.NoError(t, err)
+	require.NotEmpty(t, enrolment.Secret)
+	require.Contains(t, enrolment.URL, "otpauth://totp/camera:user")
+	require.Equal(t, "\x89PNG", string(enrolment.QR[:4]))
+
+	//Not enabled until confirmed
+	require.False(t, Enrolled("user"))
+	require.Equal(t, ErrNotEnrolled, Verify("user", code(t, enrolment.Secret, current)))
+
+	_, err = Confirm("user", "000000")
+	require.Equal(t, ErrInvalidCode, err)
+
+	codes, err := Confirm("user", code(t, enrolment.Secret, current))
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodes)
+	require.True(t, Enrolled("user"))
+	require.Equal(t, Status{Enabled: true, Require: RequireRoutes, Enrolled: true, RecoveryCodes: RecoveryCodes}, GetStatus("user"))
+
+	_, err = Enroll("user")
+	require.Equal(t, ErrEnrolled, err)
+
+	//Enrolments are persisted, recovery codes are hashed
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), codes[0])
+
+	Shutdown()
+	require.NoError(t, Init(config.TOTP{File: file}))
+	defer Shutdown()
+
+	require.True(t, Enrolled("user"))
+	require.False(t, Enrolled("other"))
+}
+
+func TestVerify(t *testing.T) {
+	current := time.Date(2018, 3, 14, 15, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	file, clean := testFile(t)
+	defer clean()
+
+	require.NoError(t, Init(config.TOTP{File: file}))
+	defer Shutdown()
+
+	enrolment, err := Enroll("user")
+	require.NoError(t, err)
+	codes, err := Confirm("user", code(t, enrolment.Secret, current))
+	require.NoError(t, err)
+
+	//Codes are accepted once
+	current = current.Add(period * time.Second)
+	valid := code(t, enrolment.Secret, current)
+	require.NoError(t, Verify("user", valid))
+	require.Equal(t, ErrInvalidCode, Verify("user", valid))
+
+	//Clock skew of one period
+	require.NoError(t, Verify("user", code(t, enrolment.Secret, current.Add(period*time.Second))))
+	require.Equal(t, ErrInvalidCode, Verify("user", code(t, enrolment.Secret, current.Add(3*period*time.Second))))
+
+	//Recovery codes are consumed, case, spaces and dashes are ignored
+	require.NoError(t, Verify("user", " "+codes[0]+" "))
+	require.Equal(t, ErrInvalidCode, Verify("user", codes[0]))
+	require.NoError(t, Verify("user", strings.ToUpper(strings.Replace(codes[1], "-", "", -1))))
+	require.Equal(t, RecoveryCodes-2, GetStatus("user").RecoveryCodes)
+
+	//Lock out after too many invalid codes, valid ones included
+	for i := 0; i < maxFailures-1; i++ {
+		require.Equal(t, ErrInvalidCode, Verify("user", "000000"))
+	}
+	require.Equal(t, ErrInvalidCode, Verify("user", "111111"))
+	require.Equal(t, ErrLocked, Verify("user", codes[2]))
+
+	current = current.Add(lockout)
+	require.NoError(t, Verify("user", codes[2]))
+
+	require.Equal(t, ErrNotEnrolled, Verify("other", codes[3]))
+
+	require.Equal(t, ErrInvalidCode, Disable("user", "000000"))
+	require.NoError(t, Disable("user", codes[3]))
+	require.False(t, Enrolled("user"))
+}
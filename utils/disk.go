@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"syscall"
+)
+
+// DiskUsage returns total and available (for unprivileged users) bytes of the filesystem containing path
+func DiskUsage(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	return stat.Blocks * uint64(stat.Bsize), stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// DeviceID returns the ID of the device containing path, paths with the same ID share free space
+func DeviceID(path string) (uint64, error) {
+	var stat syscall.Stat_t
+
+	if err := syscall.Stat(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Dev), nil
+}
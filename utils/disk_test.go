@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskUsage(t *testing.T) {
+	total, free, err := DiskUsage(os.TempDir())
+	require.NoError(t, err)
+	require.True(t, total > 0)
+	require.True(t, free <= total)
+
+	_, _, err = DiskUsage("/this/path/does/not/exist")
+	require.Error(t, err)
+}
+
+func TestDeviceID(t *testing.T) {
+	dev, err := DeviceID(os.TempDir())
+	require.NoError(t, err)
+
+	same, err := DeviceID(os.TempDir() + "/.")
+	require.NoError(t, err)
+	require.Equal(t, dev, same)
+
+	_, err = DeviceID("/this/path/does/not/exist")
+	require.Error(t, err)
+}
@@ -2,8 +2,10 @@ package utils
 
 import (
 	"io/ioutil"
+	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func ListFiles(dir string, filterFunc func(os.FileInfo) bool) ([]os.FileInfo, []string, int64, error) {
@@ -34,3 +36,35 @@ func ListFiles(dir string, filterFunc func(os.FileInfo) bool) ([]os.FileInfo, []
 	return fileInfo, fileList, folderSize, err
 
 }
+
+// MimeType returns the MIME type of a file from its extension
+func MimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+
+	switch ext {
+	case ".ppm":
+		return "image/x-portable-pixmap"
+	case ".pgm":
+		return "image/x-portable-graymap"
+	case ".webp":
+		return "image/webp"
+	case ".mkv":
+		return "video/x-matroska"
+	case ".avi":
+		return "video/x-msvideo"
+	case ".mp4":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	case ".flv":
+		return "video/x-flv"
+	case ".swf":
+		return "application/x-shockwave-flash"
+	}
+
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+
+	return "application/octet-stream"
+}
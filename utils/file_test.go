@@ -16,3 +16,12 @@ func TestListFiles(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 0, len(list))
 }
+
+func TestMimeType(t *testing.T) {
+	require.Equal(t, "image/jpeg", MimeType("/tmp/01-20180314152202-01.jpg"))
+	require.Equal(t, "image/x-portable-pixmap", MimeType("lastsnap.PPM"))
+	require.Equal(t, "image/webp", MimeType("lastsnap.webp"))
+	require.Equal(t, "video/x-matroska", MimeType("01-20180314152202.mkv"))
+	require.Equal(t, "video/x-msvideo", MimeType("01-20180314152202.avi"))
+	require.Equal(t, "application/octet-stream", MimeType("noext"))
+}
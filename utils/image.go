@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// limits for the width of images produced by motionctrl
+	MinImageWidth = 16
+	MaxImageWidth = 4096
+
+	// MaxImagePixels bounds pictures decoded from headers that can't be trusted
+	MaxImagePixels = MaxImageWidth * MaxImageWidth
+)
+
+// ScaleToWidth resizes img to width pixels keeping its aspect ratio. img is returned
+// unchanged when it is already narrower than width
+func ScaleToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+
+	if width <= 0 || bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	return Scale(img, width, height)
+}
+
+// ScaleToFit resizes img so that it fits in a size x size box keeping its aspect ratio
+func ScaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+
+	if bounds.Dx() >= bounds.Dy() {
+		return ScaleToWidth(img, size)
+	}
+
+	if bounds.Dy() <= size {
+		return img
+	}
+
+	width := bounds.Dx() * size / bounds.Dy()
+	if width < 1 {
+		width = 1
+	}
+
+	return Scale(img, width, size)
+}
+
+// Scale resizes img to exactly width x height pixels
+func Scale(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// IsCompleteImage checks that data contains a whole JPEG, WebP or PPM picture, according to ext.
+// It is used to know when a file written by motion is ready to be read
+func IsCompleteImage(data []byte, ext string) bool {
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "jpg", "jpeg":
+		return len(data) > 4 && bytes.HasPrefix(data, []byte{0xFF, 0xD8}) && bytes.HasSuffix(data, []byte{0xFF, 0xD9})
+	case "webp":
+		return len(data) > 12 && bytes.HasPrefix(data, []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")) &&
+			int(binary.LittleEndian.Uint32(data[4:8]))+8 <= len(data)
+	case "ppm":
+		return isCompletePPM(data)
+	default:
+		return len(data) > 0
+	}
+}
+
+func isCompletePPM(data []byte) bool {
+	var magic string
+	var width, height, maxValue int
+
+	reader := bytes.NewReader(data)
+
+	if _, err := fmt.Fscan(reader, &magic, &width, &height, &maxValue); err != nil || magic != "P6" || maxValue <= 0 {
+		return false
+	}
+
+	//A single whitespace separates header from pixels
+	headerSize := len(data) - reader.Len() + 1
+
+	bytesPerSample := 1
+	if maxValue > 255 {
+		bytesPerSample = 2
+	}
+
+	return len(data) >= headerSize+width*height*3*bytesPerSample
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaleToWidth(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 640, 480))
+
+	require.Equal(t, image.Rect(0, 0, 320, 240), ScaleToWidth(img, 320).Bounds())
+	require.Equal(t, img, ScaleToWidth(img, 1024))
+	require.Equal(t, img, ScaleToWidth(img, 0))
+}
+
+func TestScaleToFit(t *testing.T) {
+	landscape := image.NewGray(image.Rect(0, 0, 640, 480))
+	portrait := image.NewGray(image.Rect(0, 0, 480, 640))
+
+	require.Equal(t, image.Rect(0, 0, 160, 120), ScaleToFit(landscape, 160).Bounds())
+	require.Equal(t, image.Rect(0, 0, 120, 160), ScaleToFit(portrait, 160).Bounds())
+	require.Equal(t, portrait, ScaleToFit(portrait, 1000))
+}
+
+func TestIsCompleteImage(t *testing.T) {
+	require.True(t, IsCompleteImage([]byte{0xFF, 0xD8, 0x00, 0x00, 0xFF, 0xD9}, ".jpg"))
+	require.False(t, IsCompleteImage([]byte{0xFF, 0xD8, 0x00, 0x00, 0x00}, ".jpg"))
+
+	webp := append([]byte("RIFF\x08\x00\x00\x00WEBP"), 0, 0, 0, 0)
+	require.True(t, IsCompleteImage(webp[:16], ".webp"))
+	require.False(t, IsCompleteImage(webp[:13], ".webp"))
+
+	ppm := append([]byte("P6\n2 1\n255\n"), 1, 2, 3, 4, 5, 6)
+	require.True(t, IsCompleteImage(ppm, ".ppm"))
+	require.False(t, IsCompleteImage(ppm[:len(ppm)-1], ".ppm"))
+	require.False(t, IsCompleteImage([]byte("P3\n2 1\n255\n"), ".ppm"))
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("pgm", "P5", DecodePGM, DecodePGMConfig)
+}
+
+// DecodePGM decodes a binary (P5) PGM picture, the format of motion masks (mask_file and mask_privacy)
+func DecodePGM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	config, maxValue, err := readPGMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewGray(image.Rect(0, 0, config.Width, config.Height))
+
+	bytesPerSample := 1
+	if maxValue > 255 {
+		bytesPerSample = 2
+	}
+
+	row := make([]byte, config.Width*bytesPerSample)
+
+	for y := 0; y < config.Height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("truncated pgm: %v", err)
+		}
+
+		for x := 0; x < config.Width; x++ {
+			value := int(row[x*bytesPerSample])
+			if bytesPerSample == 2 {
+				value = value<<8 | int(row[x*2+1])
+			}
+
+			img.SetGray(x, y, color.Gray{uint8(value * 255 / maxValue)})
+		}
+	}
+
+	return img, nil
+}
+
+func DecodePGMConfig(r io.Reader) (image.Config, error) {
+	config, _, err := readPGMHeader(bufio.NewReader(r))
+	return config, err
+}
+
+// EncodePGM writes img as binary (P5) PGM with 8 bits samples
+func EncodePGM(w io.Writer, img *image.Gray) error {
+	bounds := img.Bounds()
+	bw := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintf(bw, "P5\n%d %d\n255\n", bounds.Dx(), bounds.Dy()); err != nil {
+		return err
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.PixOffset(bounds.Min.X, y)
+		if _, err := bw.Write(img.Pix[i : i+bounds.Dx()]); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+func readPGMHeader(br *bufio.Reader) (image.Config, int, error) {
+	var magic string
+	var width, height, maxValue int
+
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &maxValue); err != nil {
+		return image.Config{}, 0, fmt.Errorf("invalid pgm header: %v", err)
+	}
+
+	if magic != "P5" || width <= 0 || height <= 0 || maxValue <= 0 || maxValue > 65535 {
+		return image.Config{}, 0, fmt.Errorf("unsupported pgm: %s %dx%d (max: %d)", magic, width, height, maxValue)
+	}
+
+	if width > MaxImagePixels/height {
+		return image.Config{}, 0, fmt.Errorf("pgm too large: %dx%d", width, height)
+	}
+
+	//Single whitespace between header and pixels
+	if _, err := br.ReadByte(); err != nil {
+		return image.Config{}, 0, err
+	}
+
+	return image.Config{ColorModel: color.GrayModel, Width: width, Height: height}, maxValue, nil
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePGM(t *testing.T) {
+	pgm := append([]byte("P5\n3 1\n255\n"), 0, 128, 255)
+
+	img, format, err := image.Decode(bytes.NewReader(pgm))
+	require.NoError(t, err)
+	require.Equal(t, "pgm", format)
+	require.Equal(t, image.Rect(0, 0, 3, 1), img.Bounds())
+	require.Equal(t, color.Gray{0}, img.At(0, 0))
+	require.Equal(t, color.Gray{128}, img.At(1, 0))
+	require.Equal(t, color.Gray{255}, img.At(2, 0))
+
+	//16 bits samples
+	img, err = DecodePGM(bytes.NewReader(append([]byte("P5 1 1 65535 "), 0xFF, 0xFF)))
+	require.NoError(t, err)
+	require.Equal(t, color.Gray{255}, img.At(0, 0))
+
+	_, err = DecodePGM(bytes.NewReader(pgm[:len(pgm)-1]))
+	require.Error(t, err)
+
+	_, err = DecodePGM(bytes.NewReader([]byte("P2\n3 1\n255\n0 128 255\n")))
+	require.Error(t, err)
+
+	//Size is checked before allocating pixels
+	_, err = DecodePGM(bytes.NewReader([]byte("P5 200000 200000 255 ")))
+	require.Error(t, err)
+	_, err = DecodePGMConfig(bytes.NewReader([]byte("P5 200000 200000 255 ")))
+	require.Error(t, err)
+}
+
+func TestEncodePGM(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	img.SetGray(1, 0, color.Gray{255})
+	img.SetGray(0, 1, color.Gray{64})
+
+	var buf bytes.Buffer
+	require.NoError(t, EncodePGM(&buf, img))
+	require.Equal(t, append([]byte("P5\n2 2\n255\n"), 0, 255, 64, 0), buf.Bytes())
+
+	decoded, err := DecodePGM(&buf)
+	require.NoError(t, err)
+	require.Equal(t, img.Pix, decoded.(*image.Gray).Pix)
+
+	//Sub images are written from their bounds
+	var sub bytes.Buffer
+	require.NoError(t, EncodePGM(&sub, img.SubImage(image.Rect(1, 0, 2, 2)).(*image.Gray)))
+	require.Equal(t, append([]byte("P5\n1 2\n255\n"), 255, 0), sub.Bytes())
+}
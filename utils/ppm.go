@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	image.RegisterFormat("ppm", "P6", DecodePPM, DecodePPMConfig)
+}
+
+// DecodePPM decodes a binary (P6) PPM picture, the format written by motion when picture_type is ppm
+func DecodePPM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+
+	config, maxValue, err := readPPMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, config.Width, config.Height))
+
+	bytesPerSample := 1
+	if maxValue > 255 {
+		bytesPerSample = 2
+	}
+
+	row := make([]byte, config.Width*3*bytesPerSample)
+
+	for y := 0; y < config.Height; y++ {
+		if _, err := io.ReadFull(br, row); err != nil {
+			return nil, fmt.Errorf("truncated ppm: %v", err)
+		}
+
+		for x := 0; x < config.Width; x++ {
+			var rgb [3]int
+			for c := 0; c < 3; c++ {
+				i := (x*3 + c) * bytesPerSample
+				if bytesPerSample == 2 {
+					rgb[c] = int(row[i])<<8 | int(row[i+1])
+				} else {
+					rgb[c] = int(row[i])
+				}
+			}
+
+			img.SetRGBA(x, y, color.RGBA{uint8(rgb[0] * 255 / maxValue), uint8(rgb[1] * 255 / maxValue), uint8(rgb[2] * 255 / maxValue), 0xFF})
+		}
+	}
+
+	return img, nil
+}
+
+func DecodePPMConfig(r io.Reader) (image.Config, error) {
+	config, _, err := readPPMHeader(bufio.NewReader(r))
+	return config, err
+}
+
+func readPPMHeader(br *bufio.Reader) (image.Config, int, error) {
+	var magic string
+	var width, height, maxValue int
+
+	if _, err := fmt.Fscan(br, &magic, &width, &height, &maxValue); err != nil {
+		return image.Config{}, 0, fmt.Errorf("invalid ppm header: %v", err)
+	}
+
+	if magic != "P6" || width <= 0 || height <= 0 || maxValue <= 0 || maxValue > 65535 {
+		return image.Config{}, 0, fmt.Errorf("unsupported ppm: %s %dx%d (max: %d)", magic, width, height, maxValue)
+	}
+
+	if width > MaxImagePixels/height {
+		return image.Config{}, 0, fmt.Errorf("ppm too large: %dx%d", width, height)
+	}
+
+	//Single whitespace between header and pixels
+	if _, err := br.ReadByte(); err != nil {
+		return image.Config{}, 0, err
+	}
+
+	return image.Config{ColorModel: color.RGBAModel, Width: width, Height: height}, maxValue, nil
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePPM(t *testing.T) {
+	ppm := append([]byte("P6\n2 1\n255\n"), 255, 0, 0, 0, 0, 255)
+
+	img, format, err := image.Decode(bytes.NewReader(ppm))
+	require.NoError(t, err)
+	require.Equal(t, "ppm", format)
+	require.Equal(t, image.Rect(0, 0, 2, 1), img.Bounds())
+	require.Equal(t, color.RGBA{255, 0, 0, 255}, img.At(0, 0))
+	require.Equal(t, color.RGBA{0, 0, 255, 255}, img.At(1, 0))
+
+	_, err = DecodePPM(bytes.NewReader(ppm[:len(ppm)-1]))
+	require.Error(t, err)
+
+	_, err = DecodePPM(bytes.NewReader([]byte("P3\n2 1\n255\n")))
+	require.Error(t, err)
+
+	//Size is checked before allocating pixels
+	_, err = DecodePPM(bytes.NewReader([]byte("P6 200000 200000 255 ")))
+	require.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTime(t *testing.T) {
+	tm, err := ParseTime("1521037322")
+	require.NoError(t, err)
+	require.Equal(t, int64(1521037322), tm.Unix())
+
+	tm, err = ParseTime("2018-03-14T15:22:02+01:00")
+	require.NoError(t, err)
+	require.Equal(t, int64(1521037322), tm.Unix())
+
+	tm, err = ParseTime("2018-03-14T15:22:02")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2018, 3, 14, 15, 22, 2, 0, time.Local), tm)
+
+	tm, err = ParseTime("2018-03-14")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2018, 3, 14, 0, 0, 0, 0, time.Local), tm)
+
+	_, err = ParseTime("yesterday")
+	require.Error(t, err)
+}
+
+func TestParseDuration(t *testing.T) {
+	d, err := ParseDuration("30d")
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, d)
+
+	d, err = ParseDuration("12h30m")
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Hour+30*time.Minute, d)
+
+	_, err = ParseDuration("xd")
+	require.Error(t, err)
+
+	_, err = ParseDuration("month")
+	require.Error(t, err)
+}